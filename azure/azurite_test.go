@@ -0,0 +1,66 @@
+// +build integration
+
+package azure_test
+
+import (
+	"context"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/azure"
+	"github.com/hill-daniel/influx-backup/retry"
+	"os"
+	"strings"
+	"testing"
+)
+
+// The integration tests run against a local Azurite container:
+// docker run -d -p 10000:10000 mcr.microsoft.com/azure-storage/azurite azurite-blob --blobHost 0.0.0.0
+const (
+	envAzuriteEndpoint     = "AZURITE_ENDPOINT"
+	defaultAzuriteEndpoint = "http://127.0.0.1:10000"
+	azuriteAccountName     = "devstoreaccount1"
+	azuriteAccountKey      = "Eby8vdM02xNOcqFlqUwJPLlmEtlCDXJ1OUzFT50uSRZ6IFsuFq2UVErCz4I6tq/K1SZFPTOtr/KBHBeksoGMGw=="
+	azuriteContainer       = "influx-backup-integration-test"
+)
+
+func Test_should_upload_list_and_delete_blob_in_azurite(t *testing.T) {
+	endpoint := os.Getenv(envAzuriteEndpoint)
+	if endpoint == "" {
+		endpoint = defaultAzuriteEndpoint
+	}
+	config := azure.Config{
+		ContainerURL: endpoint + "/" + azuriteAccountName + "/" + azuriteContainer,
+		AccountName:  azuriteAccountName,
+		AccountKey:   azuriteAccountKey,
+	}
+	container, err := azure.NewContainerURL(config, retry.NoRetry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := container.Create(context.Background(), nil, azblob.PublicAccessNone); err != nil {
+		if storageErr, ok := err.(azblob.StorageError); !ok || storageErr.ServiceCode() != azblob.ServiceCodeContainerAlreadyExists {
+			t.Fatalf("failed to create container, %v", err)
+		}
+	}
+	uploader := azure.NewBlobUploader(container, plainKeyProvider{}, azure.WithAccessTier(azblob.AccessTierCool))
+	fileContent := []byte("If you can read this, the upload was successful")
+
+	storageLocation, err := uploader.Upload(&backup.FileContent{Key: "upload-integration-test.txt", Content: &fileContent, Tags: map[string]string{"database": "metrics"}})
+
+	if err != nil {
+		t.Fatalf("failed to upload blob, %v", err)
+	}
+	if !strings.HasSuffix(storageLocation, "metrics/upload-integration-test.txt") {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	objects, err := uploader.List("metrics/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Size != int64(len(fileContent)) {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+	if err := uploader.Delete(objects[0].Key); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,66 @@
+package azure
+
+import (
+	"fmt"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/pkg/errors"
+	"net/url"
+	"strings"
+)
+
+// Config configures the connection to a blob container, either with a shared key or a SAS token.
+type Config struct {
+	// ContainerURL is e.g. https://account.blob.core.windows.net/backups or http://127.0.0.1:10000/devstoreaccount1/backups for Azurite.
+	ContainerURL string
+	AccountName  string
+	AccountKey   string
+	// SASToken is the query string of a shared access signature, with or without a leading ?.
+	SASToken string
+}
+
+// NewContainerURL creates the URL of the container. Requests are retried by the pipeline according to the policy.
+func NewContainerURL(config Config, policy retry.Policy) (azblob.ContainerURL, error) {
+	containerURL, err := url.Parse(config.ContainerURL)
+	if err != nil || containerURL.Host == "" {
+		return azblob.ContainerURL{}, fmt.Errorf("invalid container URL %s", config.ContainerURL)
+	}
+	credential, err := config.credential(containerURL)
+	if err != nil {
+		return azblob.ContainerURL{}, err
+	}
+	attempts := policy.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	pipeline := azblob.NewPipeline(credential, azblob.PipelineOptions{Retry: azblob.RetryOptions{
+		Policy:        azblob.RetryPolicyExponential,
+		MaxTries:      int32(attempts),
+		RetryDelay:    policy.BaseDelay,
+		MaxRetryDelay: policy.MaxDelay,
+	}})
+	return azblob.NewContainerURL(*containerURL, pipeline), nil
+}
+
+func (c Config) credential(containerURL *url.URL) (azblob.Credential, error) {
+	switch {
+	case c.AccountKey != "" && c.SASToken != "":
+		return nil, errors.New("either an account key or a SAS token is required, not both")
+	case c.AccountKey != "":
+		if c.AccountName == "" {
+			return nil, errors.New("account name is required for shared key authentication")
+		}
+		credential, err := azblob.NewSharedKeyCredential(c.AccountName, c.AccountKey)
+		if err != nil {
+			return nil, errors.Wrap(err, "invalid account key")
+		}
+		return credential, nil
+	case c.SASToken != "":
+		containerURL.RawQuery = strings.TrimPrefix(c.SASToken, "?")
+		return azblob.NewAnonymousCredential(), nil
+	case containerURL.RawQuery != "":
+		return azblob.NewAnonymousCredential(), nil
+	default:
+		return nil, errors.New("no azure authentication configured, set an account key or a SAS token")
+	}
+}
@@ -0,0 +1,187 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	"io"
+	"strings"
+	"unicode"
+)
+
+const (
+	// DefaultBlockSize is the size of the staged blocks, a block blob consists of at most 50000 blocks.
+	DefaultBlockSize = 8 * 1024 * 1024
+	// DefaultConcurrency is the number of blocks staged concurrently.
+	DefaultConcurrency = 4
+	maxTags            = 10
+)
+
+// BlobUploader stores files as block blobs in an Azure storage container.
+// The archive is streamed in blocks which are staged concurrently and committed at the end.
+type BlobUploader struct {
+	container   azblob.ContainerURL
+	keyProvider backup.KeyProvider
+	tier        azblob.AccessTierType
+	blockSize   int
+	concurrency int
+}
+
+// Option configures optional behaviour of the BlobUploader.
+type Option func(u *BlobUploader)
+
+// WithAccessTier uploads blobs directly into the given tier, see ParseAccessTier.
+func WithAccessTier(tier azblob.AccessTierType) Option {
+	return func(u *BlobUploader) {
+		u.tier = tier
+	}
+}
+
+// WithBlockSize sets the size of the staged blocks, values <= 0 keep the default.
+func WithBlockSize(size int64) Option {
+	return func(u *BlobUploader) {
+		if size > 0 {
+			u.blockSize = int(size)
+		}
+	}
+}
+
+// WithConcurrency sets the number of blocks staged concurrently, values <= 0 keep the default.
+func WithConcurrency(concurrency int) Option {
+	return func(u *BlobUploader) {
+		if concurrency > 0 {
+			u.concurrency = concurrency
+		}
+	}
+}
+
+// NewBlobUploader creates a new blob uploader, see NewContainerURL.
+func NewBlobUploader(container azblob.ContainerURL, keyProvider backup.KeyProvider, options ...Option) BlobUploader {
+	u := BlobUploader{container: container, keyProvider: keyProvider, blockSize: DefaultBlockSize, concurrency: DefaultConcurrency}
+	for _, option := range options {
+		option(&u)
+	}
+	return u
+}
+
+// ParseAccessTier parses Hot, Cool or Archive case-insensitively, an empty tier uses the default of the account.
+func ParseAccessTier(tier string) (azblob.AccessTierType, error) {
+	for _, t := range []azblob.AccessTierType{azblob.AccessTierNone, azblob.AccessTierHot, azblob.AccessTierCool, azblob.AccessTierArchive} {
+		if strings.EqualFold(tier, string(t)) {
+			return t, nil
+		}
+	}
+	return azblob.AccessTierNone, fmt.Errorf("unknown access tier %s, expected %s, %s or %s", tier, azblob.AccessTierHot, azblob.AccessTierCool, azblob.AccessTierArchive)
+}
+
+// Upload streams the content as block blob. Tags of the content become blob index tags.
+// The storage location is the URL of the blob without credentials.
+func (u BlobUploader) Upload(content *backup.FileContent) (string, error) {
+	if len(content.Tags) > maxTags {
+		return "", fmt.Errorf("too many tags, a blob has at most %d index tags", maxTags)
+	}
+	key, err := u.keyProvider.CreateKeyFor(content.Key)
+	if err != nil {
+		return "", err
+	}
+	blob := u.container.NewBlockBlobURL(key)
+	_, err = azblob.UploadStreamToBlockBlob(context.Background(), content.Reader(), blob, azblob.UploadStreamToBlockBlobOptions{
+		BufferSize:      u.blockSize,
+		MaxBuffers:      u.concurrency,
+		BlobHTTPHeaders: azblob.BlobHTTPHeaders{ContentType: content.ContentType},
+		Metadata:        metadataOf(content.Metadata),
+		BlobAccessTier:  u.tier,
+		BlobTagsMap:     azblob.BlobTagsMap(content.Tags),
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to upload blob %s", key)
+	}
+	location := blob.URL()
+	location.RawQuery = ""
+	return location.String(), nil
+}
+
+// List returns the blobs whose names start with prefix.
+func (u BlobUploader) List(prefix string) ([]backup.StoredObject, error) {
+	var objects []backup.StoredObject
+	for marker := (azblob.Marker{}); marker.NotDone(); {
+		segment, err := u.container.ListBlobsFlatSegment(context.Background(), marker, azblob.ListBlobsSegmentOptions{Prefix: prefix})
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list blobs with prefix %s", prefix)
+		}
+		for _, item := range segment.Segment.BlobItems {
+			object := backup.StoredObject{Key: item.Name, LastModified: item.Properties.LastModified}
+			if item.Properties.ContentLength != nil {
+				object.Size = *item.Properties.ContentLength
+			}
+			objects = append(objects, object)
+		}
+		marker = segment.NextMarker
+	}
+	return objects, nil
+}
+
+// Delete removes the blob with the given key including its snapshots.
+func (u BlobUploader) Delete(key string) error {
+	_, err := u.container.NewBlobURL(key).Delete(context.Background(), azblob.DeleteSnapshotsOptionInclude, azblob.BlobAccessConditions{})
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete blob %s", key)
+	}
+	return nil
+}
+
+// Open returns the content of the blob with the given key, interrupted downloads are resumed up to three times.
+func (u BlobUploader) Open(key string) (io.ReadCloser, error) {
+	response, err := u.container.NewBlobURL(key).Download(context.Background(), 0, azblob.CountToEnd, azblob.BlobAccessConditions{}, false, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download blob %s", key)
+	}
+	return response.Body(azblob.RetryReaderOptions{MaxRetryRequests: 3}), nil
+}
+
+// Stat returns content type, index tags and metadata of the blob with the given key.
+func (u BlobUploader) Stat(key string) (backup.Attributes, error) {
+	blob := u.container.NewBlobURL(key)
+	properties, err := blob.GetProperties(context.Background(), azblob.BlobAccessConditions{}, azblob.ClientProvidedKeyOptions{})
+	if err != nil {
+		return backup.Attributes{}, errors.Wrapf(err, "failed to read properties of blob %s", key)
+	}
+	tags, err := blob.GetTags(context.Background(), nil, nil, nil, nil, nil)
+	if err != nil {
+		return backup.Attributes{}, errors.Wrapf(err, "failed to read tags of blob %s", key)
+	}
+	attributes := backup.Attributes{ContentType: properties.ContentType()}
+	if metadata := properties.NewMetadata(); len(metadata) > 0 {
+		attributes.Metadata = metadata
+	}
+	if len(tags.BlobTagSet) > 0 {
+		attributes.Tags = make(map[string]string, len(tags.BlobTagSet))
+		for _, tag := range tags.BlobTagSet {
+			attributes.Tags[tag.Key] = tag.Value
+		}
+	}
+	return attributes, nil
+}
+
+// metadataOf converts the keys to C# identifiers as required by Azure, e.g. uncompressed-size becomes uncompressed_size.
+func metadataOf(metadata map[string]string) azblob.Metadata {
+	if len(metadata) == 0 {
+		return nil
+	}
+	converted := azblob.Metadata{}
+	for key, value := range metadata {
+		name := strings.Map(func(r rune) rune {
+			if r == '_' || r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+				return r
+			}
+			return '_'
+		}, key)
+		if name == "" || unicode.IsDigit(rune(name[0])) {
+			name = "_" + name
+		}
+		converted[name] = value
+	}
+	return converted
+}
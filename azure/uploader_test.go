@@ -0,0 +1,303 @@
+package azure_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"github.com/Azure/azure-storage-blob-go/azblob"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/azure"
+	"github.com/hill-daniel/influx-backup/retry"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+const containerPath = "/devstoreaccount1/backups/"
+
+type plainKeyProvider struct{}
+
+func (plainKeyProvider) CreateKeyFor(symbol string) (string, error) {
+	return "metrics/" + symbol, nil
+}
+
+func (plainKeyProvider) Prefix() string {
+	return "metrics/"
+}
+
+type committedBlob struct {
+	content []byte
+	headers http.Header
+}
+
+// fakeBlobService implements the parts of the blob REST API used by the uploader.
+type fakeBlobService struct {
+	mutex  sync.Mutex
+	blocks map[string][]byte
+	blobs  map[string]committedBlob
+}
+
+func newFakeBlobService() *fakeBlobService {
+	return &fakeBlobService{blocks: map[string][]byte{}, blobs: map[string]committedBlob{}}
+}
+
+func (f *fakeBlobService) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	query := r.URL.Query()
+	name := strings.TrimPrefix(r.URL.Path, containerPath)
+	switch {
+	case r.Method == http.MethodPut && query.Get("comp") == "block":
+		body, _ := ioutil.ReadAll(r.Body)
+		f.blocks[name+"/"+query.Get("blockid")] = body
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodPut && query.Get("comp") == "blocklist":
+		var blockList struct {
+			Latest []string `xml:"Latest"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&blockList); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var content []byte
+		for _, id := range blockList.Latest {
+			content = append(content, f.blocks[name+"/"+id]...)
+		}
+		f.blobs[name] = committedBlob{content: content, headers: r.Header}
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == http.MethodGet && query.Get("comp") == "list":
+		f.list(w, query.Get("prefix"))
+	case r.Method == http.MethodHead:
+		blob, ok := f.blobs[name]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", blob.headers.Get("x-ms-blob-content-type"))
+		for header, values := range blob.headers {
+			if strings.HasPrefix(strings.ToLower(header), "x-ms-meta-") {
+				w.Header()[header] = values
+			}
+		}
+		w.WriteHeader(http.StatusOK)
+	case r.Method == http.MethodGet && query.Get("comp") == "tags":
+		f.tags(w, f.blobs[name].headers.Get("x-ms-tags"))
+	case r.Method == http.MethodDelete:
+		if _, ok := f.blobs[name]; !ok {
+			w.Header().Set("x-ms-error-code", "BlobNotFound")
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		delete(f.blobs, name)
+		w.WriteHeader(http.StatusAccepted)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func (f *fakeBlobService) list(w http.ResponseWriter, prefix string) {
+	var names []string
+	for name := range f.blobs {
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var buffer bytes.Buffer
+	buffer.WriteString(`<?xml version="1.0" encoding="utf-8"?><EnumerationResults ContainerName="backups"><Blobs>`)
+	for _, name := range names {
+		fmt.Fprintf(&buffer, "<Blob><Name>%s</Name><Properties><Last-Modified>Mon, 14 Oct 2019 12:00:00 GMT</Last-Modified><Content-Length>%d</Content-Length></Properties></Blob>", name, len(f.blobs[name].content))
+	}
+	buffer.WriteString("</Blobs><NextMarker/></EnumerationResults>")
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(buffer.Bytes())
+}
+
+func (f *fakeBlobService) tags(w http.ResponseWriter, encoded string) {
+	tags, _ := url.ParseQuery(encoded)
+	var buffer bytes.Buffer
+	buffer.WriteString(`<?xml version="1.0" encoding="utf-8"?><Tags><TagSet>`)
+	for key := range tags {
+		fmt.Fprintf(&buffer, "<Tag><Key>%s</Key><Value>%s</Value></Tag>", key, tags.Get(key))
+	}
+	buffer.WriteString("</TagSet></Tags>")
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = w.Write(buffer.Bytes())
+}
+
+func createUploader(t *testing.T, server *httptest.Server, options ...azure.Option) azure.BlobUploader {
+	config := azure.Config{
+		ContainerURL: server.URL + strings.TrimSuffix(containerPath, "/"),
+		AccountName:  "devstoreaccount1",
+		AccountKey:   base64.StdEncoding.EncodeToString([]byte("test")),
+	}
+	container, err := azure.NewContainerURL(config, retry.NoRetry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return azure.NewBlobUploader(container, plainKeyProvider{}, options...)
+}
+
+func Test_should_stage_blocks_and_commit_blob_with_tier_tags_and_metadata(t *testing.T) {
+	service := newFakeBlobService()
+	server := httptest.NewServer(service)
+	defer server.Close()
+	uploader := createUploader(t, server, azure.WithAccessTier(azblob.AccessTierCool), azure.WithBlockSize(1024*1024))
+	body := strings.Repeat("0123456789", 250*1024)
+	content := &backup.FileContent{
+		Key:         "dump_20191014120000.tar.gz",
+		Body:        strings.NewReader(body),
+		Size:        int64(len(body)),
+		ContentType: "application/gzip",
+		Tags:        map[string]string{"database": "metrics"},
+		Metadata:    map[string]string{"uncompressed-size": "42"},
+	}
+
+	storageLocation, err := uploader.Upload(content)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageLocation != server.URL+containerPath+"metrics/dump_20191014120000.tar.gz" {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	blob, ok := service.blobs["metrics/dump_20191014120000.tar.gz"]
+	if !ok {
+		t.Fatal("blob was not committed")
+	}
+	if string(blob.content) != body {
+		t.Fatalf("actual: %d bytes expected: %d bytes", len(blob.content), len(body))
+	}
+	if len(service.blocks) != 3 {
+		t.Fatalf("actual: %d blocks expected: 3", len(service.blocks))
+	}
+	expectedHeaders := map[string]string{
+		"x-ms-access-tier":            "Cool",
+		"x-ms-tags":                   "database=metrics",
+		"x-ms-meta-uncompressed_size": "42",
+		"x-ms-blob-content-type":      "application/gzip",
+	}
+	for header, expected := range expectedHeaders {
+		if actual := blob.headers.Get(header); actual != expected {
+			t.Errorf("header %s actual: %s expected: %s", header, actual, expected)
+		}
+	}
+}
+
+func Test_should_reject_more_than_ten_tags(t *testing.T) {
+	server := httptest.NewServer(newFakeBlobService())
+	defer server.Close()
+	uploader := createUploader(t, server)
+	tags := map[string]string{}
+	for i := 0; i < 11; i++ {
+		tags[fmt.Sprintf("tag%d", i)] = "value"
+	}
+	content := []byte("archive")
+
+	_, err := uploader.Upload(&backup.FileContent{Key: "dump_20191014120000.tar.gz", Content: &content, Tags: tags})
+
+	if err == nil {
+		t.Fatal("expected error for too many tags")
+	}
+}
+
+func Test_should_list_and_delete_blobs(t *testing.T) {
+	service := newFakeBlobService()
+	server := httptest.NewServer(service)
+	defer server.Close()
+	uploader := createUploader(t, server)
+	for _, name := range []string{"dump_20191014120000.tar.gz", "dump_20191015120000.tar.gz"} {
+		content := []byte(name)
+		if _, err := uploader.Upload(&backup.FileContent{Key: name, Content: &content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := uploader.Delete("metrics/dump_20191014120000.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := uploader.List("metrics/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "metrics/dump_20191015120000.tar.gz" {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+	if objects[0].Size != int64(len("dump_20191015120000.tar.gz")) {
+		t.Fatalf("actual: %d expected: %d", objects[0].Size, len("dump_20191015120000.tar.gz"))
+	}
+	if objects[0].LastModified.Year() != 2019 {
+		t.Fatalf("unexpected last modified %s", objects[0].LastModified)
+	}
+}
+
+func Test_should_parse_access_tiers(t *testing.T) {
+	for value, expected := range map[string]azblob.AccessTierType{"": azblob.AccessTierNone, "hot": azblob.AccessTierHot, "Cool": azblob.AccessTierCool, "ARCHIVE": azblob.AccessTierArchive} {
+		tier, err := azure.ParseAccessTier(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tier != expected {
+			t.Errorf("actual: %s expected: %s", tier, expected)
+		}
+	}
+	if _, err := azure.ParseAccessTier("P10"); err == nil {
+		t.Fatal("expected error for premium tier")
+	}
+}
+
+func Test_should_require_credentials(t *testing.T) {
+	_, err := azure.NewContainerURL(azure.Config{ContainerURL: "https://account.blob.core.windows.net/backups"}, retry.NoRetry)
+
+	if err == nil {
+		t.Fatal("expected error without account key and SAS token")
+	}
+}
+
+func Test_should_use_sas_token_as_query(t *testing.T) {
+	config := azure.Config{ContainerURL: "https://account.blob.core.windows.net/backups", SASToken: "?sv=2019-12-12&sig=abc"}
+
+	container, err := azure.NewContainerURL(config, retry.NoRetry)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	location := container.URL()
+	if location.RawQuery != "sv=2019-12-12&sig=abc" {
+		t.Fatalf("unexpected query %s", location.RawQuery)
+	}
+}
+
+func Test_should_read_content_type_tags_and_metadata_of_blob(t *testing.T) {
+	service := newFakeBlobService()
+	server := httptest.NewServer(service)
+	defer server.Close()
+	uploader := createUploader(t, server)
+	_, err := uploader.Upload(&backup.FileContent{
+		Key:         "dump_20191014120000.tar.gz",
+		Content:     &[]byte{1, 2, 3},
+		Size:        3,
+		ContentType: "application/gzip",
+		Tags:        map[string]string{"database": "metrics"},
+		Metadata:    map[string]string{"uncompressed-size": "42"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attributes, err := uploader.Stat("metrics/dump_20191014120000.tar.gz")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes.ContentType != "application/gzip" || attributes.Tags["database"] != "metrics" || attributes.Metadata["uncompressed_size"] != "42" {
+		t.Fatalf("unexpected attributes %+v", attributes)
+	}
+}
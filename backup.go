@@ -1,5 +1,12 @@
 package backup
 
+import (
+	"bytes"
+	"github.com/pkg/errors"
+	"io"
+	"time"
+)
+
 // Backup is an abstraction for creating (dumping) a database snapshot.
 type Backup interface {
 	BackUp(databaseName string) (string, error)
@@ -18,9 +25,71 @@ type Uploader interface {
 	Upload(content *FileContent) (storageLocation string, err error)
 }
 
+// KeyProvider is an abstraction for creating the keys (names) of stored backup files.
+type KeyProvider interface {
+	CreateKeyFor(symbol string) (string, error)
+	// Prefix is the common prefix of all created keys.
+	Prefix() string
+}
+
 // FileContent is used in Uploader and holds information about the files to backup.
+// The content is either given in memory or streamed from Body, e.g. an opened archive file.
+// Tags are used for cost allocation and lifecycle rules, Metadata is stored along with the file.
+// Uploaders apply both as far as their storage supports it.
 type FileContent struct {
 	Key         string
 	Content     *[]byte
 	ContentType string
+	Body        io.Reader
+	Size        int64
+	Tags        map[string]string
+	Metadata    map[string]string
+}
+
+// Reader returns the Body if set, otherwise a reader for Content.
+func (c *FileContent) Reader() io.Reader {
+	if c.Body != nil {
+		return c.Body
+	}
+	if c.Content == nil {
+		return bytes.NewReader(nil)
+	}
+	return bytes.NewReader(*c.Content)
 }
+
+// StoredObject describes a backup file in a storage.
+type StoredObject struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Lister is an abstraction for listing stored backup files, e.g. all backups of a database below a prefix.
+type Lister interface {
+	List(prefix string) ([]StoredObject, error)
+}
+
+// Deleter is an abstraction for removing stored backup files.
+type Deleter interface {
+	Delete(key string) error
+}
+
+// Opener is an abstraction for reading stored backup files, e.g. to copy or restore them.
+type Opener interface {
+	Open(key string) (io.ReadCloser, error)
+}
+
+// Attributes are the content type, tags and metadata of a stored backup file as far as its storage keeps them.
+type Attributes struct {
+	ContentType string
+	Tags        map[string]string
+	Metadata    map[string]string
+}
+
+// Stater is an abstraction for reading the attributes of stored backup files, e.g. to keep them when copying.
+type Stater interface {
+	Stat(key string) (Attributes, error)
+}
+
+// ErrLocked is returned by a Deleter for files which are protected from deletion, e.g. by S3 Object Lock.
+var ErrLocked = errors.New("backup is locked")
@@ -0,0 +1,163 @@
+package catalog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Catalog describes the shards of a backup, it is stored next to the backup with the key of the backup
+// and the suffix backup.CatalogSuffix. It answers which backup contains a shard without downloading backups.
+type Catalog struct {
+	// Name is the name of the backup, e.g. dump_20191014120000.tar.gz.
+	Name string `json:"name"`
+	// Location is the storage location of the backup as returned by the uploader.
+	Location  string    `json:"location"`
+	Created   time.Time `json:"created"`
+	Manifests []string  `json:"manifests"`
+	// Size is the size of all shard archives.
+	Size   int64   `json:"size"`
+	Shards []Shard `json:"shards"`
+}
+
+// Shard is a shard contained in a backup. Start and End are the time range of its shard group,
+// they are missing if the meta data of the backup could not be read.
+type Shard struct {
+	Database string     `json:"database"`
+	Policy   string     `json:"policy"`
+	ID       uint64     `json:"id"`
+	Start    *time.Time `json:"start,omitempty"`
+	End      *time.Time `json:"end,omitempty"`
+	File     string     `json:"file"`
+	Size     int64      `json:"size"`
+}
+
+// Build creates the catalog of the portable backups in dir from their manifests. Only files selected by filter
+// are archived, every file a manifest refers to has to be selected. The shards of newer manifests replace
+// the ones of older manifests.
+func Build(dir string, filter gzip.Filter) (Catalog, error) {
+	selected := make(map[string]os.FileInfo)
+	var manifests []string
+	err := filter.Walk(dir, func(filePath string, name string, info os.FileInfo) error {
+		if info.IsDir() {
+			return nil
+		}
+		selected[name] = info
+		if strings.HasSuffix(name, ManifestSuffix) {
+			manifests = append(manifests, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return Catalog{}, err
+	}
+	if len(manifests) == 0 {
+		return Catalog{}, fmt.Errorf("no manifest of a portable backup found in %s", dir)
+	}
+	// manifests are named by their timestamp, the newest is read last
+	sort.Strings(manifests)
+	shards := make(map[uint64]Shard)
+	for _, name := range manifests {
+		if err := addShards(dir, name, selected, shards); err != nil {
+			return Catalog{}, err
+		}
+	}
+	catalog := Catalog{Manifests: manifests}
+	for _, shard := range shards {
+		catalog.Shards = append(catalog.Shards, shard)
+		catalog.Size += shard.Size
+	}
+	sort.Slice(catalog.Shards, func(i, j int) bool {
+		return catalog.Shards[i].ID < catalog.Shards[j].ID
+	})
+	return catalog, nil
+}
+
+// addShards adds the shards of a manifest, the files it refers to are relative to the manifest.
+func addShards(dir string, name string, selected map[string]os.FileInfo, shards map[uint64]Shard) error {
+	manifest, err := ReadManifest(filepath.Join(dir, filepath.FromSlash(name)))
+	if err != nil {
+		return err
+	}
+	base := path.Dir(name)
+	metaFile := path.Join(base, manifest.Meta.FileName)
+	var missing []string
+	if _, ok := selected[metaFile]; !ok {
+		missing = append(missing, metaFile)
+	}
+	for _, entry := range manifest.Files {
+		if _, ok := selected[path.Join(base, entry.FileName)]; !ok {
+			missing = append(missing, path.Join(base, entry.FileName))
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("manifest %s refers to files which are missing or excluded: %s", name, strings.Join(missing, ", "))
+	}
+	ranges, err := readTimeRanges(filepath.Join(dir, filepath.FromSlash(metaFile)))
+	if err != nil {
+		log.Warnf("cataloging shards of %s without time ranges, %v", name, err)
+	}
+	for _, entry := range manifest.Files {
+		file := path.Join(base, entry.FileName)
+		shard := Shard{Database: entry.Database, Policy: entry.Policy, ID: entry.ShardID, File: file, Size: selected[file].Size()}
+		if timeRange, ok := ranges[entry.ShardID]; ok {
+			shard.Start, shard.End = &timeRange.start, &timeRange.end
+		}
+		shards[entry.ShardID] = shard
+	}
+	return nil
+}
+
+// Find returns the shards of the catalog matching database, policy and id, empty values and id 0 match all shards.
+func (c Catalog) Find(database string, policy string, id uint64) []Shard {
+	var found []Shard
+	for _, shard := range c.Shards {
+		if (database == "" || shard.Database == database) && (policy == "" || shard.Policy == policy) && (id == 0 || shard.ID == id) {
+			found = append(found, shard)
+		}
+	}
+	return found
+}
+
+// Upload stores the catalog next to its backup with the given tags and metadata and returns its storage location.
+func Upload(uploader backup.Uploader, catalog Catalog, tags map[string]string, metadata map[string]string) (string, error) {
+	encoded, err := json.MarshalIndent(catalog, "", "  ")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encode catalog of %s", catalog.Name)
+	}
+	return uploader.Upload(&backup.FileContent{
+		Key:         catalog.Name + backup.CatalogSuffix,
+		Content:     &encoded,
+		ContentType: "application/json",
+		Size:        int64(len(encoded)),
+		Tags:        tags,
+		Metadata:    metadata,
+	})
+}
+
+// Read downloads the catalog with the given key.
+func Read(opener backup.Opener, key string) (Catalog, error) {
+	reader, err := opener.Open(key)
+	if err != nil {
+		return Catalog{}, err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Errorf("failed to close io reader of %s, %v", key, err)
+		}
+	}()
+	var catalog Catalog
+	if err := json.NewDecoder(reader).Decode(&catalog); err != nil {
+		return Catalog{}, errors.Wrapf(err, "failed to read catalog %s", key)
+	}
+	return catalog, nil
+}
@@ -0,0 +1,181 @@
+package catalog_test
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"github.com/hill-daniel/influx-backup/catalog"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	groupStart = time.Date(2019, 10, 7, 0, 0, 0, 0, time.UTC)
+	groupEnd   = time.Date(2019, 10, 14, 0, 0, 0, 0, time.UTC)
+)
+
+func Test_should_catalog_shards_of_portable_backup(t *testing.T) {
+	dir := portableBackup(t, "20191014T120000Z", map[uint64]string{412: "autogen", 413: "monthly"})
+	defer os.RemoveAll(dir)
+
+	actual, err := catalog.Build(dir, gzip.Filter{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.Manifests) != 1 || actual.Manifests[0] != "20191014T120000Z.manifest" {
+		t.Fatalf("unexpected manifests %v", actual.Manifests)
+	}
+	found := actual.Find("metrics", "autogen", 412)
+	if len(found) != 1 {
+		t.Fatalf("expected shard 412 of autogen, got %v", actual.Shards)
+	}
+	shard := found[0]
+	if shard.File != "20191014T120000Z.s412.tar.gz" || shard.Size != 4 {
+		t.Fatalf("unexpected shard %+v", shard)
+	}
+	if shard.Start == nil || !shard.Start.Equal(groupStart) || shard.End == nil || !shard.End.Equal(groupEnd) {
+		t.Fatalf("unexpected time range %v - %v", shard.Start, shard.End)
+	}
+	if len(actual.Find("", "autogen", 413)) != 0 || len(actual.Find("", "", 0)) != 2 || actual.Size != 8 {
+		t.Fatalf("unexpected shards %v", actual.Shards)
+	}
+}
+
+func Test_should_replace_shards_of_older_manifests(t *testing.T) {
+	dir := portableBackup(t, "20191014T120000Z", map[uint64]string{412: "autogen"})
+	defer os.RemoveAll(dir)
+	writeBackup(t, dir, "20191015T120000Z", map[uint64]string{412: "autogen"})
+
+	actual, err := catalog.Build(dir, gzip.Filter{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.Shards) != 1 || actual.Shards[0].File != "20191015T120000Z.s412.tar.gz" {
+		t.Fatalf("unexpected shards %v", actual.Shards)
+	}
+}
+
+func Test_should_fail_if_manifest_refers_to_missing_file(t *testing.T) {
+	dir := portableBackup(t, "20191014T120000Z", map[uint64]string{412: "autogen"})
+	defer os.RemoveAll(dir)
+	if err := os.Remove(filepath.Join(dir, "20191014T120000Z.s412.tar.gz")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := catalog.Build(dir, gzip.Filter{})
+
+	if err == nil || !strings.Contains(err.Error(), "20191014T120000Z.s412.tar.gz") {
+		t.Fatalf("expected error naming the missing file, got %v", err)
+	}
+}
+
+func Test_should_fail_if_manifest_refers_to_excluded_file(t *testing.T) {
+	dir := portableBackup(t, "20191014T120000Z", map[uint64]string{412: "autogen"})
+	defer os.RemoveAll(dir)
+
+	_, err := catalog.Build(dir, gzip.Filter{Exclude: []string{"*.meta"}})
+
+	if err == nil || !strings.Contains(err.Error(), "20191014T120000Z.meta") {
+		t.Fatalf("expected error naming the excluded file, got %v", err)
+	}
+}
+
+func Test_should_fail_without_manifest(t *testing.T) {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if _, err := catalog.Build(dir, gzip.Filter{}); err == nil {
+		t.Fatal("expected error without manifest")
+	}
+}
+
+func Test_should_catalog_shards_without_time_range_if_meta_data_is_unreadable(t *testing.T) {
+	dir := portableBackup(t, "20191014T120000Z", map[uint64]string{412: "autogen"})
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "20191014T120000Z.meta"), []byte{0x0a, 0xff}, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	actual, err := catalog.Build(dir, gzip.Filter{})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(actual.Shards) != 1 || actual.Shards[0].Start != nil || actual.Shards[0].End != nil {
+		t.Fatalf("unexpected shards %v", actual.Shards)
+	}
+}
+
+func portableBackup(t *testing.T, timestamp string, policies map[uint64]string) string {
+	dir, err := ioutil.TempDir("", "catalog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeBackup(t, dir, timestamp, policies)
+	return dir
+}
+
+// writeBackup writes the files of influxd backup -portable for database metrics with the given shards and their policies.
+func writeBackup(t *testing.T, dir string, timestamp string, policies map[uint64]string) {
+	manifest := catalog.Manifest{Meta: catalog.MetaEntry{FileName: timestamp + ".meta"}}
+	var database []byte
+	database = appendBytes(database, 1, []byte("metrics"))
+	for id, policy := range policies {
+		fileName := timestamp + ".s" + strconv.FormatUint(id, 10) + ".tar.gz"
+		if err := ioutil.WriteFile(filepath.Join(dir, fileName), []byte("data"), 0600); err != nil {
+			t.Fatal(err)
+		}
+		manifest.Files = append(manifest.Files, catalog.ManifestEntry{Database: "metrics", Policy: policy, ShardID: id, FileName: fileName, Size: 4})
+		var shard, group, rp []byte
+		shard = appendVarint(shard, 1, id)
+		group = appendVarint(group, 1, id)
+		group = appendVarint(group, 2, uint64(groupStart.UnixNano()))
+		group = appendVarint(group, 3, uint64(groupEnd.UnixNano()))
+		group = appendBytes(group, 5, shard)
+		rp = appendBytes(rp, 1, []byte(policy))
+		rp = appendVarint(rp, 2, 0)
+		rp = appendBytes(rp, 5, group)
+		database = appendBytes(database, 3, rp)
+	}
+	var data, portable []byte
+	data = appendVarint(data, 1, 1)
+	data = appendBytes(data, 5, database)
+	portable = appendBytes(portable, 1, data)
+	portable = appendVarint(portable, 2, 1)
+	if err := ioutil.WriteFile(filepath.Join(dir, timestamp+".meta"), portable, 0600); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, timestamp+catalog.ManifestSuffix), encoded, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func appendVarint(buf []byte, field int, value uint64) []byte {
+	buf = appendUvarint(buf, uint64(field)<<3)
+	return appendUvarint(buf, value)
+}
+
+func appendBytes(buf []byte, field int, content []byte) []byte {
+	buf = appendUvarint(buf, uint64(field)<<3|2)
+	buf = appendUvarint(buf, uint64(len(content)))
+	return append(buf, content...)
+}
+
+func appendUvarint(buf []byte, value uint64) []byte {
+	encoded := make([]byte, binary.MaxVarintLen64)
+	return append(buf, encoded[:binary.PutUvarint(encoded, value)]...)
+}
@@ -0,0 +1,57 @@
+package catalog
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+// ManifestSuffix is the extension of the manifests written by influxd backup -portable, e.g. 20191014T120000Z.manifest.
+const ManifestSuffix = ".manifest"
+
+// Manifest lists the files of a portable backup, it is written by influxd backup -portable next to the files.
+type Manifest struct {
+	Meta    MetaEntry       `json:"meta"`
+	Limited bool            `json:"limited"`
+	Files   []ManifestEntry `json:"files"`
+	// Database, Policy and ShardID are set if the backup was limited to them.
+	Database string `json:"database,omitempty"`
+	Policy   string `json:"policy,omitempty"`
+	ShardID  uint64 `json:"shard_id,omitempty"`
+}
+
+// MetaEntry is the file with the meta data of the backup, e.g. 20191014T120000Z.meta.
+// Its size is the one of the meta data, not of the file.
+type MetaEntry struct {
+	FileName string `json:"fileName"`
+	Size     int64  `json:"size"`
+}
+
+// ManifestEntry is the archive of a shard, e.g. 20191014T120000Z.s412.tar.gz.
+type ManifestEntry struct {
+	Database     string `json:"database"`
+	Policy       string `json:"policy"`
+	ShardID      uint64 `json:"shardID"`
+	FileName     string `json:"fileName"`
+	Size         int64  `json:"size"`
+	LastModified int64  `json:"lastModified"`
+}
+
+// ReadManifest reads the manifest at the given path.
+func ReadManifest(path string) (Manifest, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Manifest{}, errors.Wrapf(err, "failed to open manifest %s", path)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Errorf("failed to close io file, %v", err)
+		}
+	}()
+	var manifest Manifest
+	if err := json.NewDecoder(file).Decode(&manifest); err != nil {
+		return Manifest{}, errors.Wrapf(err, "failed to read manifest %s", path)
+	}
+	return manifest, nil
+}
@@ -0,0 +1,144 @@
+package catalog
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"time"
+)
+
+// Field numbers of the protocol buffer messages of the influxdb meta data, see meta/internal/meta.proto of influxdb 1.x.
+// The .meta file of a portable backup is a PortableData message wrapping the Data message.
+const (
+	portableDataField     = 1
+	dataDatabasesField    = 5
+	databaseNameField     = 1
+	databasePoliciesField = 3
+	policyNameField       = 1
+	policyGroupsField     = 5
+	groupStartField       = 2
+	groupEndField         = 3
+	groupShardsField      = 5
+	groupTruncatedField   = 6
+	shardIDField          = 1
+)
+
+// timeRange is the time range of the shard group a shard belongs to, the end is exclusive.
+type timeRange struct {
+	start time.Time
+	end   time.Time
+}
+
+// readTimeRanges returns the time ranges of all shards in the meta data of a portable backup by shard id.
+func readTimeRanges(path string) (map[uint64]timeRange, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read meta data %s", path)
+	}
+	ranges := make(map[uint64]timeRange)
+	err = forEachField(data, func(field int, _ uint64, meta []byte) error {
+		if field != portableDataField {
+			return nil
+		}
+		return forEachField(meta, func(field int, _ uint64, database []byte) error {
+			if field != dataDatabasesField {
+				return nil
+			}
+			return forEachField(database, func(field int, _ uint64, policy []byte) error {
+				if field != databasePoliciesField {
+					return nil
+				}
+				return forEachField(policy, func(field int, _ uint64, group []byte) error {
+					if field != policyGroupsField {
+						return nil
+					}
+					return readShardGroup(group, ranges)
+				})
+			})
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decode meta data %s", path)
+	}
+	return ranges, nil
+}
+
+// readShardGroup adds the time range of the group to all its shards, a truncated group ends at its truncation.
+func readShardGroup(group []byte, ranges map[uint64]timeRange) error {
+	var start, end, truncated int64
+	var shards []uint64
+	err := forEachField(group, func(field int, value uint64, shard []byte) error {
+		switch field {
+		case groupStartField:
+			start = int64(value)
+		case groupEndField:
+			end = int64(value)
+		case groupTruncatedField:
+			truncated = int64(value)
+		case groupShardsField:
+			return forEachField(shard, func(field int, value uint64, _ []byte) error {
+				if field == shardIDField {
+					shards = append(shards, value)
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if truncated > 0 && truncated < end {
+		end = truncated
+	}
+	for _, id := range shards {
+		ranges[id] = timeRange{start: time.Unix(0, start).UTC(), end: time.Unix(0, end).UTC()}
+	}
+	return nil
+}
+
+// forEachField calls fn for every field of an encoded protocol buffer message with the value of varint fields
+// or the content of length delimited fields. Fixed size fields are skipped.
+func forEachField(data []byte, fn func(field int, value uint64, content []byte) error) error {
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return errors.New("invalid field key")
+		}
+		data = data[n:]
+		field, wireType := int(key>>3), key&7
+		var value uint64
+		var content []byte
+		switch wireType {
+		case 0:
+			if value, n = binary.Uvarint(data); n <= 0 {
+				return fmt.Errorf("invalid varint of field %d", field)
+			}
+			data = data[n:]
+		case 1, 5:
+			size := 8
+			if wireType == 5 {
+				size = 4
+			}
+			if len(data) < size {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			data = data[size:]
+			continue
+		case 2:
+			length, n := binary.Uvarint(data)
+			if n <= 0 || uint64(len(data)-n) < length {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			content = data[n : n+int(length)]
+			data = data[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", wireType, field)
+		}
+		if err := fn(field, value, content); err != nil {
+			return err
+		}
+	}
+	return nil
+}
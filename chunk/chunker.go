@@ -0,0 +1,160 @@
+package chunk
+
+import (
+	"fmt"
+	"io"
+)
+
+// Params bound the size of chunks. Chunks end at content defined boundaries, so inserting data into a file
+// changes only the chunks around the insertion. Chunks are about Avg bytes, Avg must be a power of two.
+type Params struct {
+	Min int
+	Avg int
+	Max int
+}
+
+// DefaultParams create chunks of about 1 MiB, most TSM files consist of a few hundred chunks.
+var DefaultParams = Params{Min: 256 * 1024, Avg: 1024 * 1024, Max: 4 * 1024 * 1024}
+
+// Validate checks that Min < Max and Avg is a power of two of at least 16 bytes.
+func (p Params) Validate() error {
+	if p.Min <= 0 || p.Max <= p.Min {
+		return fmt.Errorf("invalid chunk sizes, expected 0 < min %d < max %d", p.Min, p.Max)
+	}
+	if p.Avg < 16 || p.Avg&(p.Avg-1) != 0 || bitsOf(p.Avg)+normalization > maskSpan {
+		return fmt.Errorf("invalid average chunk size %d, expected a power of two from 16 to 2^%d", p.Avg, maskSpan-normalization)
+	}
+	return nil
+}
+
+const (
+	// normalization is the number of bits the mask before Avg has more and the mask after Avg has less,
+	// the sizes of chunks concentrate around Avg (normalized chunking level 2 of FastCDC).
+	normalization = 2
+	// maskSpan is the number of upper bits of the hash the masks are spread over. Bit n of the hash depends
+	// on the last n+1 bytes, spread masks make boundaries depend on a window of 48 bytes like FastCDC.
+	maskSpan = 48
+)
+
+// spreadMask returns a mask with ones bits spread evenly over the upper maskSpan bits of the hash.
+func spreadMask(ones int) uint64 {
+	var mask uint64
+	for i := 0; i < ones; i++ {
+		mask |= 1 << uint(63-i*maskSpan/ones)
+	}
+	return mask
+}
+
+// bitsOf returns the exponent of a power of two.
+func bitsOf(powerOfTwo int) int {
+	bits := 0
+	for powerOfTwo > 1 {
+		powerOfTwo >>= 1
+		bits++
+	}
+	return bits
+}
+
+// gear maps bytes to random values for the rolling hash. The values must never change,
+// otherwise the boundaries and hashes of chunks change and nothing is deduplicated.
+var gear = func() [256]uint64 {
+	var table [256]uint64
+	// splitmix64 with a fixed seed
+	state := uint64(0x696e666c75786462)
+	for i := range table {
+		state += 0x9e3779b97f4a7c15
+		z := state
+		z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+		z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+		table[i] = z ^ (z >> 31)
+	}
+	return table
+}()
+
+// Chunker splits a stream into chunks with a gear based rolling hash like FastCDC.
+type Chunker struct {
+	reader io.Reader
+	params Params
+	// strictMask is used before Avg bytes and makes boundaries less likely, looseMask is used after.
+	strictMask uint64
+	looseMask  uint64
+	buf        []byte
+	start      int
+	end        int
+	eof        bool
+}
+
+// NewChunker creates a Chunker reading from reader, params must be valid.
+func NewChunker(reader io.Reader, params Params) *Chunker {
+	bits := bitsOf(params.Avg)
+	return &Chunker{
+		reader:     reader,
+		params:     params,
+		strictMask: spreadMask(bits + normalization),
+		looseMask:  spreadMask(bits - normalization),
+		buf:        make([]byte, params.Max),
+	}
+}
+
+// Next returns the next chunk, it is valid until the next call. io.EOF is returned after the last chunk.
+func (c *Chunker) Next() ([]byte, error) {
+	if c.end-c.start < c.params.Max && !c.eof {
+		if err := c.fill(); err != nil {
+			return nil, err
+		}
+	}
+	if c.start == c.end {
+		return nil, io.EOF
+	}
+	data := c.buf[c.start:c.end]
+	n := c.cut(data)
+	c.start += n
+	return data[:n], nil
+}
+
+// fill moves the remaining bytes to the front of the buffer and reads until it is full.
+func (c *Chunker) fill() error {
+	c.end = copy(c.buf, c.buf[c.start:c.end])
+	c.start = 0
+	n, err := io.ReadFull(c.reader, c.buf[c.end:])
+	c.end += n
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		c.eof = true
+		return nil
+	}
+	return err
+}
+
+// cut returns the length of the chunk at the start of data. The hash skips the first Min bytes,
+// boundaries are found with the strict mask up to Avg bytes and with the loose mask after.
+func (c *Chunker) cut(data []byte) int {
+	if len(data) <= c.params.Min {
+		return len(data)
+	}
+	limit := len(data)
+	if limit > c.params.Max {
+		limit = c.params.Max
+	}
+	normal := c.params.Avg
+	if normal < c.params.Min {
+		normal = c.params.Min
+	}
+	if normal > limit {
+		normal = limit
+	}
+	var hash uint64
+	i := c.params.Min
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.strictMask == 0 {
+			return i + 1
+		}
+	}
+	for ; i < limit; i++ {
+		hash = (hash << 1) + gear[data[i]]
+		if hash&c.looseMask == 0 {
+			return i + 1
+		}
+	}
+	return limit
+}
@@ -0,0 +1,104 @@
+package chunk_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"github.com/hill-daniel/influx-backup/chunk"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+var testParams = chunk.Params{Min: 2 * 1024, Avg: 8 * 1024, Max: 32 * 1024}
+
+func Test_should_split_data_into_chunks_within_bounds(t *testing.T) {
+	data := randomData(1, 1024*1024)
+
+	chunks := split(t, data)
+
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatal("chunks do not reassemble the data")
+	}
+	for i, c := range chunks {
+		if len(c) > testParams.Max || (len(c) < testParams.Min && i < len(chunks)-1) {
+			t.Fatalf("chunk %d has %d bytes", i, len(c))
+		}
+	}
+	if average := len(data) / len(chunks); average < testParams.Avg/2 || average > testParams.Avg*2 {
+		t.Fatalf("unexpected average chunk size %d of %d chunks", average, len(chunks))
+	}
+}
+
+func Test_should_keep_chunks_after_inserted_data(t *testing.T) {
+	data := randomData(2, 1024*1024)
+	changed := append(append(append([]byte(nil), data[:500000]...), []byte("inserted points")...), data[500000:]...)
+
+	original := hashes(split(t, data))
+	modified := hashes(split(t, changed))
+
+	differing := 0
+	for hash := range modified {
+		if !original[hash] {
+			differing++
+		}
+	}
+	if differing > 2 {
+		t.Fatalf("%d of %d chunks changed by a small insertion", differing, len(modified))
+	}
+}
+
+func Test_should_keep_chunks_after_byte_inserted_at_the_front(t *testing.T) {
+	data := randomData(3, 1024*1024)
+	changed := append([]byte{42}, data...)
+
+	original := hashes(split(t, data))
+	modified := hashes(split(t, changed))
+
+	differing := 0
+	for hash := range modified {
+		if !original[hash] {
+			differing++
+		}
+	}
+	if differing > 2 {
+		t.Fatalf("%d of %d chunks changed by a byte inserted at the front", differing, len(modified))
+	}
+}
+
+func Test_should_reject_invalid_params(t *testing.T) {
+	for _, params := range []chunk.Params{{Min: 10, Avg: 12, Max: 100}, {Min: 100, Avg: 16, Max: 10}, {Min: 1, Avg: 8, Max: 100}, {}} {
+		if err := params.Validate(); err == nil {
+			t.Fatalf("expected an error for %+v", params)
+		}
+	}
+}
+
+func split(t *testing.T, data []byte) [][]byte {
+	chunker := chunk.NewChunker(bytes.NewReader(data), testParams)
+	var chunks [][]byte
+	for {
+		c, err := chunker.Next()
+		if err == io.EOF {
+			return chunks
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		chunks = append(chunks, append([]byte(nil), c...))
+	}
+}
+
+func hashes(chunks [][]byte) map[string]bool {
+	result := make(map[string]bool)
+	for _, c := range chunks {
+		sum := sha256.Sum256(c)
+		result[string(sum[:])] = true
+	}
+	return result
+}
+
+func randomData(seed int64, size int) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
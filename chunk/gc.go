@@ -0,0 +1,82 @@
+package chunk
+
+import (
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"path"
+)
+
+// CollectGarbage removes all but the newest keep backups, keep <= 0 keeps all, and deletes the chunks
+// which are not referenced by any remaining index. It fails while a backup is running, chunks a running backup
+// relies on are not referenced by an index yet. Chunks of backups which failed before writing their index are removed.
+// The keys of the deleted indexes and chunks are returned.
+func (r *Repository) CollectGarbage(keep int) ([]string, error) {
+	lock, err := r.acquire(kindGC)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.release()
+	indexes, err := r.Indexes()
+	if err != nil {
+		return nil, err
+	}
+	var deleted []string
+	var remaining []backup.StoredObject
+	for i, index := range indexes {
+		if keep <= 0 || i < keep {
+			remaining = append(remaining, index)
+			continue
+		}
+		if removed, err := r.delete(index.Key); err != nil {
+			return deleted, err
+		} else if !removed {
+			remaining = append(remaining, index)
+			continue
+		}
+		deleted = append(deleted, index.Key)
+	}
+	referenced := make(map[string]bool)
+	for _, object := range remaining {
+		// a chunk of an unreadable index could be deleted, nothing is collected then
+		index, err := r.ReadIndex(object.Key)
+		if err != nil {
+			return deleted, err
+		}
+		for _, entry := range index.Entries {
+			for _, hash := range entry.Chunks {
+				referenced[hash] = true
+			}
+		}
+	}
+	chunks, err := r.store.List(r.prefix + chunkDir)
+	if err != nil {
+		return deleted, err
+	}
+	for _, chunk := range chunks {
+		if referenced[path.Base(chunk.Key)] {
+			continue
+		}
+		if removed, err := r.delete(chunk.Key); err != nil {
+			return deleted, err
+		} else if removed {
+			deleted = append(deleted, chunk.Key)
+		}
+	}
+	log.Infof("kept %d backups referencing %d chunks", len(remaining), len(referenced))
+	return deleted, nil
+}
+
+// delete removes a stored object, locked objects are skipped.
+func (r *Repository) delete(key string) (bool, error) {
+	err := r.store.Delete(key)
+	if errors.Cause(err) == backup.ErrLocked {
+		log.Infof("skipping locked %s", key)
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	log.Debugf("deleted %s", key)
+	return true, nil
+}
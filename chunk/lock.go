@@ -0,0 +1,103 @@
+package chunk
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultStaleLockAge is the age after which a lock is considered left over by a crashed run.
+	// Running backups refresh their lock well before.
+	DefaultStaleLockAge = 24 * time.Hour
+	lockDir             = "locks/"
+	kindBackup          = "backup"
+	kindGC              = "gc"
+)
+
+// lock marks a running backup or garbage collection in the store. Backups may run concurrently,
+// a garbage collection runs alone. Both write their lock first and list the locks afterwards,
+// so of two runs starting at the same time at least one sees the other and gives up.
+type lock struct {
+	repository *Repository
+	key        string
+	written    time.Time
+}
+
+type lockInfo struct {
+	Kind    string    `json:"kind"`
+	Host    string    `json:"host"`
+	PID     int       `json:"pid"`
+	Created time.Time `json:"created"`
+}
+
+func (r *Repository) acquire(kind string) (*lock, error) {
+	host, err := os.Hostname()
+	if err != nil {
+		host = "unknown"
+	}
+	now := r.now()
+	l := &lock{repository: r, key: fmt.Sprintf("%s%s%s-%s-%d-%d", r.prefix, lockDir, kind, host, os.Getpid(), now.UnixNano())}
+	if err := l.write(lockInfo{Kind: kind, Host: host, PID: os.Getpid(), Created: now.UTC()}); err != nil {
+		return nil, err
+	}
+	objects, err := r.locks.List(r.prefix + lockDir)
+	if err != nil {
+		l.release()
+		return nil, err
+	}
+	for _, object := range objects {
+		if object.Key == l.key {
+			continue
+		}
+		if age := now.Sub(object.LastModified); age > r.staleAfter {
+			log.Warnf("ignoring stale lock %s of %s ago", object.Key, age.Round(time.Second))
+			continue
+		}
+		other := strings.SplitN(path.Base(object.Key), "-", 2)[0]
+		if kind == kindGC || other == kindGC {
+			l.release()
+			return nil, fmt.Errorf("repository %s is locked by %s, a %s is running", r.prefix, object.Key, other)
+		}
+	}
+	return l, nil
+}
+
+func (l *lock) write(info lockInfo) error {
+	encoded, err := json.Marshal(info)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode lock")
+	}
+	if _, err := l.repository.locks.Upload(&backup.FileContent{
+		Key:         l.key,
+		Content:     &encoded,
+		ContentType: "application/json",
+		Size:        int64(len(encoded)),
+	}); err != nil {
+		return errors.Wrapf(err, "failed to write lock %s", l.key)
+	}
+	l.written = l.repository.now()
+	return nil
+}
+
+// refresh rewrites the lock long before it becomes stale, so a long running backup keeps it.
+func (l *lock) refresh() error {
+	if l.repository.now().Sub(l.written) < l.repository.staleAfter/4 {
+		return nil
+	}
+	kind := strings.SplitN(path.Base(l.key), "-", 2)[0]
+	host, _ := os.Hostname()
+	return l.write(lockInfo{Kind: kind, Host: host, PID: os.Getpid(), Created: l.repository.now().UTC()})
+}
+
+func (l *lock) release() {
+	if err := l.repository.locks.Delete(l.key); err != nil {
+		log.Errorf("failed to remove lock %s, %v", l.key, err)
+	}
+}
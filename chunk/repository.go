@@ -0,0 +1,393 @@
+package chunk
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// IndexSuffix is the suffix of the keys of backup indexes, e.g. metrics/indexes/dump_20191014120000.chunks.json.
+	IndexSuffix = ".chunks.json"
+	// DefaultConcurrency is the number of chunks uploaded concurrently.
+	DefaultConcurrency = 4
+	chunkDir           = "chunks/"
+	indexDir           = "indexes/"
+)
+
+// Store stores chunks, indexes and locks under their keys, the keys have to be kept as they are, see backup.IdentityKeyProvider.
+type Store interface {
+	backup.Uploader
+	backup.Storage
+	backup.Opener
+}
+
+// Repository stores backups deduplicated by content below a prefix. Files are split into chunks by content,
+// each chunk is compressed with zstd and stored under the SHA-256 of its content in chunks/. A backup is an index
+// in indexes/ listing the files with their chunks, chunks already stored by earlier backups are not uploaded again.
+type Repository struct {
+	store       Store
+	locks       Store
+	prefix      string
+	params      Params
+	concurrency int
+	staleAfter  time.Duration
+	now         func() time.Time
+}
+
+// Option configures optional behaviour of the Repository.
+type Option func(r *Repository)
+
+// WithParams sets the sizes of chunks. Changing them changes the boundaries of chunks, the next backup stores all files again.
+func WithParams(params Params) Option {
+	return func(r *Repository) {
+		r.params = params
+	}
+}
+
+// WithConcurrency sets the number of chunks uploaded concurrently, values <= 0 keep the default.
+func WithConcurrency(concurrency int) Option {
+	return func(r *Repository) {
+		if concurrency > 0 {
+			r.concurrency = concurrency
+		}
+	}
+}
+
+// WithStaleLockAge sets the age after which locks of crashed runs are ignored, see DefaultStaleLockAge.
+func WithStaleLockAge(age time.Duration) Option {
+	return func(r *Repository) {
+		r.staleAfter = age
+	}
+}
+
+// WithLockStore writes the locks into the given store instead of the store of the chunks. Locks are deleted
+// at the end of every run, the store of the locks must not apply object lock or retention.
+func WithLockStore(store Store) Option {
+	return func(r *Repository) {
+		r.locks = store
+	}
+}
+
+// NewRepository creates a Repository storing below prefix, e.g. metrics/.
+func NewRepository(store Store, prefix string, options ...Option) *Repository {
+	r := &Repository{store: store, locks: store, prefix: prefix, params: DefaultParams, concurrency: DefaultConcurrency, staleAfter: DefaultStaleLockAge, now: time.Now}
+	for _, option := range options {
+		option(r)
+	}
+	return r
+}
+
+// Entry types of an Index.
+const (
+	TypeDir     = "dir"
+	TypeFile    = "file"
+	TypeSymlink = "symlink"
+)
+
+// Index lists the entries of a backup, directories precede their entries.
+type Index struct {
+	Name     string            `json:"name"`
+	Created  time.Time         `json:"created"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+	Entries  []Entry           `json:"entries"`
+}
+
+// Entry is a file, directory or symlink of a backup. The content of a file is the concatenation of its chunks.
+type Entry struct {
+	Name    string      `json:"name"`
+	Type    string      `json:"type"`
+	Mode    os.FileMode `json:"mode"`
+	ModTime time.Time   `json:"modTime"`
+	Size    int64       `json:"size,omitempty"`
+	Link    string      `json:"link,omitempty"`
+	Chunks  []string    `json:"chunks,omitempty"`
+}
+
+// Stats describe a stored backup.
+type Stats struct {
+	Files  int
+	Chunks int
+	// NewChunks were not stored before and uploaded.
+	NewChunks int
+	Size      int64
+	// UploadedSize is the compressed size of the new chunks.
+	UploadedSize int64
+}
+
+// Backup stores the files of dir selected by filter as backup with the given name, e.g. dump_20191014120000.
+// The index is uploaded after all chunks, a backup without index is incomplete and its chunks are removed
+// by the next garbage collection. The storage location of the index is returned.
+func (r *Repository) Backup(name string, dir string, filter gzip.Filter, metadata map[string]string) (string, Stats, error) {
+	var stats Stats
+	if err := r.params.Validate(); err != nil {
+		return "", stats, err
+	}
+	lock, err := r.acquire(kindBackup)
+	if err != nil {
+		return "", stats, err
+	}
+	defer lock.release()
+	// the chunks cannot be removed while the lock is held
+	known, err := r.storedChunks()
+	if err != nil {
+		return "", stats, err
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		return "", stats, errors.Wrap(err, "failed to create zstd encoder")
+	}
+	defer func() {
+		if err := encoder.Close(); err != nil {
+			log.Errorf("failed to close zstd encoder, %v", err)
+		}
+	}()
+	uploads := r.startUploads(encoder)
+	index := Index{Name: name, Created: r.now().UTC(), Metadata: metadata}
+	err = filter.Walk(dir, func(filePath string, name string, info os.FileInfo) error {
+		entry := Entry{Name: name, Mode: info.Mode().Perm(), ModTime: info.ModTime().UTC()}
+		switch {
+		case info.IsDir():
+			entry.Type = TypeDir
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(filePath)
+			if err != nil {
+				return errors.Wrapf(err, "failed to read symlink %s", filePath)
+			}
+			entry.Type, entry.Link = TypeSymlink, link
+		case info.Mode().IsRegular():
+			chunks, err := r.chunkFile(filePath, known, uploads, lock, &stats)
+			if err != nil {
+				return err
+			}
+			entry.Type, entry.Size, entry.Chunks = TypeFile, info.Size(), chunks
+			stats.Files++
+			stats.Size += info.Size()
+		default:
+			log.Warnf("skipping special file %s", filePath)
+			return nil
+		}
+		index.Entries = append(index.Entries, entry)
+		return nil
+	})
+	uploaded, uploadErr := uploads.wait()
+	if err != nil {
+		return "", stats, err
+	}
+	if uploadErr != nil {
+		return "", stats, uploadErr
+	}
+	stats.UploadedSize = uploaded
+	encoded, err := json.Marshal(index)
+	if err != nil {
+		return "", stats, errors.Wrapf(err, "failed to encode index of %s", name)
+	}
+	storageLocation, err := r.store.Upload(&backup.FileContent{
+		Key:         r.indexKey(name),
+		Content:     &encoded,
+		ContentType: "application/json",
+		Size:        int64(len(encoded)),
+		Metadata:    metadata,
+	})
+	if err != nil {
+		return "", stats, err
+	}
+	log.Infof("stored %d files in %d chunks, uploaded %d new chunks with %d bytes", stats.Files, stats.Chunks, stats.NewChunks, stats.UploadedSize)
+	return storageLocation, stats, nil
+}
+
+// chunkFile splits a file into chunks and submits the chunks which are not stored yet for upload.
+func (r *Repository) chunkFile(filePath string, known map[string]bool, uploads *uploadPool, lock *lock, stats *Stats) ([]string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open file %s", filePath)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Errorf("failed to close io file, %v", err)
+		}
+	}()
+	var hashes []string
+	chunker := NewChunker(file, r.params)
+	for {
+		data, err := chunker.Next()
+		if err == io.EOF {
+			return hashes, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to read file %s", filePath)
+		}
+		if err := lock.refresh(); err != nil {
+			return nil, err
+		}
+		sum := sha256.Sum256(data)
+		hash := hex.EncodeToString(sum[:])
+		hashes = append(hashes, hash)
+		stats.Chunks++
+		if known[hash] {
+			continue
+		}
+		known[hash] = true
+		stats.NewChunks++
+		if err := uploads.submit(pendingChunk{hash: hash, data: append([]byte(nil), data...)}); err != nil {
+			return nil, err
+		}
+	}
+}
+
+type pendingChunk struct {
+	hash string
+	data []byte
+}
+
+// uploadPool compresses and uploads chunks concurrently, the first error stops all further uploads.
+type uploadPool struct {
+	work     chan pendingChunk
+	wg       sync.WaitGroup
+	mu       sync.Mutex
+	err      error
+	uploaded int64
+}
+
+func (r *Repository) startUploads(encoder *zstd.Encoder) *uploadPool {
+	pool := &uploadPool{work: make(chan pendingChunk)}
+	for i := 0; i < r.concurrency; i++ {
+		pool.wg.Add(1)
+		go func() {
+			defer pool.wg.Done()
+			for chunk := range pool.work {
+				if pool.failed() != nil {
+					continue
+				}
+				compressed := encoder.EncodeAll(chunk.data, nil)
+				_, err := r.store.Upload(&backup.FileContent{
+					Key:         r.chunkKey(chunk.hash),
+					Content:     &compressed,
+					ContentType: gzip.ContentTypeZstd,
+					Size:        int64(len(compressed)),
+				})
+				pool.done(int64(len(compressed)), errors.Wrapf(err, "failed to upload chunk %s", chunk.hash))
+			}
+		}()
+	}
+	return pool
+}
+
+func (p *uploadPool) submit(chunk pendingChunk) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	p.work <- chunk
+	return nil
+}
+
+func (p *uploadPool) done(size int64, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if err != nil {
+		if p.err == nil {
+			p.err = err
+		}
+		return
+	}
+	p.uploaded += size
+}
+
+func (p *uploadPool) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+// wait waits for the submitted uploads and returns the uploaded size.
+func (p *uploadPool) wait() (int64, error) {
+	close(p.work)
+	p.wg.Wait()
+	return p.uploaded, p.err
+}
+
+// storedChunks returns the hashes of all stored chunks.
+func (r *Repository) storedChunks() (map[string]bool, error) {
+	objects, err := r.store.List(r.prefix + chunkDir)
+	if err != nil {
+		return nil, err
+	}
+	hashes := make(map[string]bool, len(objects))
+	for _, object := range objects {
+		hashes[path.Base(object.Key)] = true
+	}
+	return hashes, nil
+}
+
+// Indexes returns the stored indexes, newest first. Indexes are ordered by the time of the backup in their name,
+// like the archives of prune, the modification time is used only for names without a timestamp.
+func (r *Repository) Indexes() ([]backup.StoredObject, error) {
+	objects, err := r.store.List(r.prefix + indexDir)
+	if err != nil {
+		return nil, err
+	}
+	var indexes []backup.StoredObject
+	for _, object := range objects {
+		if strings.HasSuffix(object.Key, IndexSuffix) {
+			indexes = append(indexes, object)
+		}
+	}
+	sort.SliceStable(indexes, func(i, j int) bool {
+		return createdAt(indexes[i]).After(createdAt(indexes[j]))
+	})
+	return indexes, nil
+}
+
+func createdAt(index backup.StoredObject) time.Time {
+	if created, ok := backup.TimestampOf(index.Key); ok {
+		return created
+	}
+	return index.LastModified
+}
+
+// ReadIndex downloads the index with the given key.
+func (r *Repository) ReadIndex(key string) (Index, error) {
+	reader, err := r.store.Open(key)
+	if err != nil {
+		return Index{}, err
+	}
+	defer func() {
+		if err := reader.Close(); err != nil {
+			log.Errorf("failed to close io reader of %s, %v", key, err)
+		}
+	}()
+	var index Index
+	if err := json.NewDecoder(reader).Decode(&index); err != nil {
+		return Index{}, errors.Wrapf(err, "failed to read index %s", key)
+	}
+	return index, nil
+}
+
+// PrefixOf returns the prefix of the repository an index key belongs to.
+func PrefixOf(indexKey string) string {
+	if position := strings.LastIndex(indexKey, indexDir); position >= 0 {
+		return indexKey[:position]
+	}
+	return ""
+}
+
+func (r *Repository) indexKey(name string) string {
+	return r.prefix + indexDir + name + IndexSuffix
+}
+
+// chunkKey spreads the chunks over 256 directories by the first byte of their hash.
+func (r *Repository) chunkKey(hash string) string {
+	return r.prefix + chunkDir + hash[:2] + "/" + hash
+}
@@ -0,0 +1,269 @@
+package chunk_test
+
+import (
+	"bytes"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/chunk"
+	"github.com/hill-daniel/influx-backup/filesystem"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/klauspost/compress/zstd"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_should_upload_only_new_chunks_and_restore_backups(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	source := filepath.Join(dir, "snapshot")
+	writeFiles(t, source, map[string][]byte{"000001.tsm": randomData(3, 300*1024), "meta.00": []byte("meta")})
+	repository := chunk.NewRepository(store(dir), "metrics/", chunk.WithParams(testParams))
+
+	_, first, err := repository.Backup("dump_1", source, gzip.Filter{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeFiles(t, source, map[string][]byte{"000002.tsm": []byte("new shard")})
+	location, second, err := repository.Backup("dump_2", source, gzip.Filter{}, map[string]string{"database": "metrics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if first.NewChunks != first.Chunks || first.Files != 2 {
+		t.Fatalf("unexpected stats of the first backup %+v", first)
+	}
+	if second.NewChunks != 1 || second.Files != 3 {
+		t.Fatalf("only the chunk of the new file should have been uploaded, %+v", second)
+	}
+	if !strings.HasSuffix(location, "metrics/indexes/dump_2"+chunk.IndexSuffix) {
+		t.Fatalf("unexpected storage location %s", location)
+	}
+	restored := filepath.Join(dir, "restored")
+	if err := repository.Restore("metrics/indexes/dump_2"+chunk.IndexSuffix, restored); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFiles(t, source, restored, "000001.tsm", "000002.tsm", "meta.00")
+}
+
+func Test_should_collect_chunks_of_removed_backups(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	source := filepath.Join(dir, "snapshot")
+	repository := chunk.NewRepository(store(dir), "metrics/", chunk.WithParams(testParams))
+	writeFiles(t, source, map[string][]byte{"000001.tsm": randomData(4, 100*1024)})
+	if _, _, err := repository.Backup("dump_1", source, gzip.Filter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	setModTime(t, filepath.Join(dir, "metrics/indexes/dump_1"+chunk.IndexSuffix), time.Now().Add(-time.Hour))
+	writeFiles(t, source, map[string][]byte{"000001.tsm": randomData(5, 100*1024)})
+	if _, _, err := repository.Backup("dump_2", source, gzip.Filter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	deleted, err := repository.CollectGarbage(1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) < 2 || deleted[0] != "metrics/indexes/dump_1"+chunk.IndexSuffix {
+		t.Fatalf("unexpected deleted objects %v", deleted)
+	}
+	restored := filepath.Join(dir, "restored")
+	if err := repository.Restore("metrics/indexes/dump_2"+chunk.IndexSuffix, restored); err != nil {
+		t.Fatal(err)
+	}
+	assertSameFiles(t, source, restored, "000001.tsm")
+	if deleted, err := repository.CollectGarbage(1); err != nil || len(deleted) != 0 {
+		t.Fatalf("nothing should be left to collect, deleted %v, %v", deleted, err)
+	}
+}
+
+func Test_should_collect_backups_by_the_time_in_their_names(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	source := filepath.Join(dir, "snapshot")
+	repository := chunk.NewRepository(store(dir), "metrics/", chunk.WithParams(testParams))
+	writeFiles(t, source, map[string][]byte{"000001.tsm": randomData(6, 100*1024)})
+	for _, name := range []string{"dump_20191014120000", "dump_20191013120000"} {
+		if _, _, err := repository.Backup(name, source, gzip.Filter{}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the older backup was uploaded last, e.g. copied from another storage
+	setModTime(t, filepath.Join(dir, "metrics/indexes/dump_20191014120000"+chunk.IndexSuffix), time.Now().Add(-time.Hour))
+
+	deleted, err := repository.CollectGarbage(1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "metrics/indexes/dump_20191013120000"+chunk.IndexSuffix {
+		t.Fatalf("unexpected deleted objects %v", deleted)
+	}
+}
+
+func Test_should_not_collect_garbage_while_backup_is_running(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := store(dir)
+	repository := chunk.NewRepository(s, "metrics/", chunk.WithParams(testParams))
+	lock := []byte("{}")
+	if _, err := s.Upload(&backup.FileContent{Key: "metrics/locks/backup-host-1-1", Content: &lock}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := repository.CollectGarbage(0); err == nil || !strings.Contains(err.Error(), "a backup is running") {
+		t.Fatalf("expected the running backup to block garbage collection, got %v", err)
+	}
+
+	setModTime(t, filepath.Join(dir, "metrics/locks/backup-host-1-1"), time.Now().Add(-2*chunk.DefaultStaleLockAge))
+	if _, err := repository.CollectGarbage(0); err != nil {
+		t.Fatalf("stale lock should be ignored, %v", err)
+	}
+}
+
+func Test_should_not_back_up_while_garbage_is_collected(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	s := store(dir)
+	source := filepath.Join(dir, "snapshot")
+	writeFiles(t, source, map[string][]byte{"meta.00": []byte("meta")})
+	repository := chunk.NewRepository(s, "metrics/", chunk.WithParams(testParams))
+	lock := []byte("{}")
+	if _, err := s.Upload(&backup.FileContent{Key: "metrics/locks/gc-host-1-1", Content: &lock}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err := repository.Backup("dump_1", source, gzip.Filter{}, nil)
+
+	if err == nil || !strings.Contains(err.Error(), "a gc is running") {
+		t.Fatalf("expected the garbage collection to block the backup, got %v", err)
+	}
+	locks, err := s.List("metrics/locks/")
+	if err != nil || len(locks) != 1 {
+		t.Fatalf("the lock of the backup should have been removed, found %v, %v", locks, err)
+	}
+}
+
+func Test_should_write_locks_into_lock_store(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	source := filepath.Join(dir, "snapshot")
+	writeFiles(t, source, map[string][]byte{"meta.00": []byte("meta")})
+	chunks := lockedStore{store(filepath.Join(dir, "chunks"))}
+	locks := store(filepath.Join(dir, "locks"))
+	repository := chunk.NewRepository(chunks, "metrics/", chunk.WithParams(testParams), chunk.WithLockStore(locks))
+
+	if _, _, err := repository.Backup("dump_1", source, gzip.Filter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if written, err := chunks.List("metrics/locks/"); err != nil || len(written) != 0 {
+		t.Fatalf("no lock should have been written into the store of the chunks, found %v, %v", written, err)
+	}
+	if left, err := locks.List("metrics/locks/"); err != nil || len(left) != 0 {
+		t.Fatalf("the lock of the backup should have been removed, found %v, %v", left, err)
+	}
+	if _, err := repository.CollectGarbage(0); err != nil {
+		t.Fatalf("the finished backup should not block garbage collection, %v", err)
+	}
+}
+
+func Test_should_reject_corrupted_chunk(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+	source := filepath.Join(dir, "snapshot")
+	writeFiles(t, source, map[string][]byte{"meta.00": []byte("meta")})
+	s := store(dir)
+	repository := chunk.NewRepository(s, "metrics/", chunk.WithParams(testParams))
+	if _, _, err := repository.Backup("dump_1", source, gzip.Filter{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	chunks, err := s.List("metrics/chunks/")
+	if err != nil || len(chunks) != 1 {
+		t.Fatalf("expected one chunk, found %v, %v", chunks, err)
+	}
+	encoder, err := zstd.NewWriter(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaced := encoder.EncodeAll([]byte("other"), nil)
+	if err := ioutil.WriteFile(filepath.Join(dir, filepath.FromSlash(chunks[0].Key)), replaced, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	err = repository.Restore("metrics/indexes/dump_1"+chunk.IndexSuffix, filepath.Join(dir, "restored"))
+
+	if err == nil || !strings.Contains(err.Error(), "is corrupted") {
+		t.Fatalf("expected an error for the corrupted chunk, got %v", err)
+	}
+}
+
+// lockedStore rejects deleting objects like a bucket with object lock.
+type lockedStore struct {
+	chunk.Store
+}
+
+func (lockedStore) Delete(key string) error {
+	return backup.ErrLocked
+}
+
+func store(dir string) chunk.Store {
+	uploader := filesystem.NewUploader(dir, backup.IdentityKeyProvider{})
+	return &uploader
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "chunk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove %s, %v", dir, err)
+		}
+	}
+}
+
+func writeFiles(t *testing.T, dir string, files map[string][]byte) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), content, 0640); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func setModTime(t *testing.T, path string, modTime time.Time) {
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func assertSameFiles(t *testing.T, expectedDir string, actualDir string, names ...string) {
+	for _, name := range names {
+		expected, err := ioutil.ReadFile(filepath.Join(expectedDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		actual, err := ioutil.ReadFile(filepath.Join(actualDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(actual, expected) {
+			t.Fatalf("restored %s differs", name)
+		}
+		info, err := os.Stat(filepath.Join(actualDir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0640 {
+			t.Fatalf("unexpected permissions %v of %s", info.Mode(), name)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package chunk
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Restore writes the files of the backup with the given index key into dir. Every chunk is checked against its hash.
+// Permissions and modification times are restored, entries pointing outside of dir are rejected like by gzip.Extract.
+func (r *Repository) Restore(indexKey string, dir string) error {
+	index, err := r.ReadIndex(indexKey)
+	if err != nil {
+		return err
+	}
+	decoder, err := zstd.NewReader(nil)
+	if err != nil {
+		return errors.Wrap(err, "failed to create zstd decoder")
+	}
+	defer decoder.Close()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", dir)
+	}
+	// times of directories are restored at the end, restoring their content changes them
+	var directories []Entry
+	for _, entry := range index.Entries {
+		target := filepath.Join(dir, filepath.FromSlash(entry.Name))
+		if err := gzip.CheckTarget(dir, entry.Name, target); err != nil {
+			return err
+		}
+		switch entry.Type {
+		case TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return errors.Wrapf(err, "failed to create directory %s", target)
+			}
+			directories = append(directories, entry)
+			continue
+		case TypeFile:
+			if err := r.restoreFile(decoder, target, entry); err != nil {
+				return err
+			}
+		case TypeSymlink:
+			if err := os.Symlink(entry.Link, target); err != nil {
+				return errors.Wrapf(err, "failed to create symlink %s", target)
+			}
+			continue
+		default:
+			log.Warnf("skipping entry %s of unsupported type %s", entry.Name, entry.Type)
+			continue
+		}
+		if err := restoreAttributes(target, entry); err != nil {
+			return err
+		}
+	}
+	for _, entry := range directories {
+		if err := restoreAttributes(filepath.Join(dir, filepath.FromSlash(entry.Name)), entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Repository) restoreFile(decoder *zstd.Decoder, target string, entry Entry) error {
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create file %s", target)
+	}
+	var written int64
+	for _, hash := range entry.Chunks {
+		data, err := r.readChunk(decoder, hash)
+		if err != nil {
+			_ = file.Close()
+			return errors.Wrapf(err, "failed to restore file %s", target)
+		}
+		if _, err := file.Write(data); err != nil {
+			_ = file.Close()
+			return errors.Wrapf(err, "failed to restore file %s", target)
+		}
+		written += int64(len(data))
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "failed to restore file %s", target)
+	}
+	if written != entry.Size {
+		return fmt.Errorf("restored %d bytes of %s, expected %d", written, target, entry.Size)
+	}
+	return nil
+}
+
+// readChunk downloads and decompresses a chunk and checks its hash.
+func (r *Repository) readChunk(decoder *zstd.Decoder, hash string) ([]byte, error) {
+	reader, err := r.store.Open(r.chunkKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	compressed, err := ioutil.ReadAll(reader)
+	if closeErr := reader.Close(); closeErr != nil {
+		log.Errorf("failed to close io reader of chunk %s, %v", hash, closeErr)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read chunk %s", hash)
+	}
+	data, err := decoder.DecodeAll(compressed, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to decompress chunk %s", hash)
+	}
+	expected, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid hash of chunk %s", hash)
+	}
+	if actual := sha256.Sum256(data); !bytes.Equal(actual[:], expected) {
+		return nil, fmt.Errorf("chunk %s is corrupted, its content has the hash %x", hash, actual)
+	}
+	return data, nil
+}
+
+func restoreAttributes(target string, entry Entry) error {
+	if err := os.Chmod(target, entry.Mode.Perm()); err != nil {
+		return errors.Wrapf(err, "failed to change permissions of %s", target)
+	}
+	if err := os.Chtimes(target, entry.ModTime, entry.ModTime); err != nil {
+		return errors.Wrapf(err, "failed to change modification time of %s", target)
+	}
+	return nil
+}
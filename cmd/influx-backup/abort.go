@@ -0,0 +1,36 @@
+package main
+
+import (
+	"flag"
+	"github.com/hill-daniel/influx-backup/s3"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// abortStaleUploads aborts incomplete multipart uploads, which would otherwise be billed as storage forever.
+func abortStaleUploads(args []string) {
+	flags := flag.NewFlagSet(cmdAbortStaleUploads, flag.ExitOnError)
+	database := flags.String("database", "myDbName", "database of the uploads, used in key templates")
+	storageSettings := storageFlags(flags)
+	keys := keyFlags(flags)
+	prefix := flags.String("prefix", "", "only uploads with keys starting with the prefix are aborted, defaults to the prefix of the key provider")
+	olderThan := flags.Duration("olderThan", 7*24*time.Hour, "only uploads initiated before this duration are aborted")
+	policy := retryFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	keyProvider := keys.provider(*database)
+	if *prefix == "" {
+		*prefix = keyProvider.Prefix()
+	}
+	if storageSettings.kind != storageS3 {
+		log.Fatalf("%s is only supported by the %s storage", cmdAbortStaleUploads, storageS3)
+	}
+	binaryUploader := createS3Uploader(storageSettings.s3Session, keyProvider, storageSettings.bucketName, 0, 0, s3.WithRetryPolicy(*policy))
+	aborted, err := binaryUploader.AbortStaleUploads(*prefix, *olderThan)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("aborted %d stale multipart uploads in bucket %s", len(aborted), storageSettings.bucketName)
+}
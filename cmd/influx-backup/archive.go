@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"strings"
+)
+
+const (
+	compressionGzip = "gzip"
+	compressionZstd = "zstd"
+)
+
+// archiveSettings select the compression of created archives.
+type archiveSettings struct {
+	compression   string
+	level         int
+	long          bool
+	gzipWorkers   int
+	gzipBlockSize byteSize
+	include       string
+	exclude       string
+	deterministic bool
+}
+
+func archiveFlags(flags *flag.FlagSet) *archiveSettings {
+	settings := &archiveSettings{}
+	flags.StringVar(&settings.compression, "compression", compressionGzip, "compression of archives, "+compressionGzip+" (.tar.gz) or "+compressionZstd+" (.tar.zst)")
+	flags.IntVar(&settings.level, "zstdLevel", gzip.DefaultZstdLevel, "zstd compression level from 1 to 22")
+	flags.BoolVar(&settings.long, "zstdLong", false, "zstd long distance matching with a 128 MiB window, like zstd --long")
+	flags.IntVar(&settings.gzipWorkers, "gzipWorkers", 0, "number of cores compressing gzip archives, 0 uses all cores")
+	settings.gzipBlockSize = gzip.DefaultBlockSize
+	flags.Var(&settings.gzipBlockSize, "gzipBlockSize", "size of the blocks compressed concurrently, e.g. 1M")
+	flags.StringVar(&settings.include, "include", "", "archive only files matching these comma separated patterns, e.g. data/**,meta/*, empty includes all")
+	flags.StringVar(&settings.exclude, "exclude", "", "skip files and directories matching these comma separated patterns, e.g. *.tmp,**/*.lock,wal")
+	flags.BoolVar(&settings.deterministic, "deterministic", false, "create identical archives of identical files, modification times and owners are not archived")
+	return settings
+}
+
+func (a *archiveSettings) filter() (gzip.Filter, error) {
+	return gzip.NewFilter(patterns(a.include), patterns(a.exclude))
+}
+
+func (a *archiveSettings) archiver() (gzip.Tarer, error) {
+	filter, err := a.filter()
+	if err != nil {
+		return nil, err
+	}
+	switch a.compression {
+	case compressionGzip:
+		if a.gzipWorkers == 1 {
+			return gzip.GzTarer{Filter: filter, Deterministic: a.deterministic}, nil
+		}
+		return gzip.ParallelGzTarer{Filter: filter, Deterministic: a.deterministic, Workers: a.gzipWorkers, BlockSize: int(a.gzipBlockSize)}, nil
+	case compressionZstd:
+		if a.level < 1 || a.level > 22 {
+			return nil, fmt.Errorf("invalid zstd level %d, expected 1 to 22", a.level)
+		}
+		return gzip.ZstdTarer{Filter: filter, Deterministic: a.deterministic, Level: a.level, Long: a.long}, nil
+	default:
+		return nil, fmt.Errorf("unknown compression %s, expected %s or %s", a.compression, compressionGzip, compressionZstd)
+	}
+}
+
+func patterns(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/catalog"
+	log "github.com/sirupsen/logrus"
+	"sort"
+	"strings"
+	"time"
+)
+
+// showCatalog prints the shards contained in the backups of a database, newest backup first,
+// e.g. to find the backups containing shard 412 of retention policy autogen.
+func showCatalog(args []string) {
+	flags := flag.NewFlagSet(cmdCatalog, flag.ExitOnError)
+	database := flags.String("database", "myDbName", "database to look up the shards for")
+	retentionPolicy := flags.String("rp", "", "retention policy of the shards, empty matches all")
+	shardID := flags.Uint64("shard", 0, "id of the shard, 0 matches all")
+	storageSettings := storageFlags(flags)
+	keys := keyFlags(flags)
+	policy := retryFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	keyProvider := keys.scopedProvider(*database)
+	storage := storageSettings.create(keyProvider, *policy, 0, 0)
+	objects, err := storage.List(keyProvider.Prefix())
+	if err != nil {
+		log.Fatal(err)
+	}
+	sort.Slice(objects, func(i, j int) bool {
+		return objects[i].LastModified.After(objects[j].LastModified)
+	})
+	for _, object := range objects {
+		if !strings.HasSuffix(object.Key, backup.CatalogSuffix) {
+			continue
+		}
+		entry, err := catalog.Read(storage, object.Key)
+		if err != nil {
+			log.Errorf("skipping catalog %s, %v", object.Key, err)
+			continue
+		}
+		for _, shard := range entry.Find(*database, *retentionPolicy, *shardID) {
+			fmt.Printf("%s\t%s\t%d\t%s\t%s\t%d\t%s\n", entry.Created.Format(time.RFC3339), shard.Policy, shard.ID,
+				formatTime(shard.Start), formatTime(shard.End), shard.Size, entry.Location)
+		}
+	}
+}
+
+// formatTime formats a bound of the time range of a shard, - if it is unknown.
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"flag"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/chunk"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	formatArchive = "archive"
+	formatChunks  = "chunks"
+)
+
+// chunkSettings select the chunk repository of deduplicated backups.
+type chunkSettings struct {
+	format string
+	prefix string
+}
+
+func chunkFlags(flags *flag.FlagSet) *chunkSettings {
+	settings := &chunkSettings{}
+	flags.StringVar(&settings.format, "format", formatArchive, "backup format, "+formatArchive+" uploads an archive per backup, "+formatChunks+" uploads only new chunks of the files")
+	flags.StringVar(&settings.prefix, "chunkPrefix", "", "key prefix of the chunk repository, empty uses <database>/")
+	return settings
+}
+
+func (c *chunkSettings) repositoryPrefix(database string) string {
+	if c.prefix == "" {
+		return database + "/"
+	}
+	return c.prefix
+}
+
+// collectGarbage removes old deduplicated backups and the chunks no backup references anymore.
+func collectGarbage(args []string) {
+	flags := flag.NewFlagSet(cmdGC, flag.ExitOnError)
+	database := flags.String("database", "myDbName", "database of the chunk repository")
+	storageSettings := storageFlags(flags)
+	chunks := chunkFlags(flags)
+	keep := flags.Int("keep", 0, "number of newest backups to keep, 0 keeps all and removes only unreferenced chunks")
+	policy := retryFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	storage := storageSettings.create(backup.IdentityKeyProvider{}, *policy, 0, 0)
+	repository := chunk.NewRepository(storage, chunks.repositoryPrefix(*database))
+	deleted, err := repository.CollectGarbage(*keep)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("deleted %d objects of the chunk repository of %s", len(deleted), *database)
+}
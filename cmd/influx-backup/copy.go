@@ -0,0 +1,91 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/s3"
+	log "github.com/sirupsen/logrus"
+	"time"
+)
+
+// copyBackups copies the selected backups of a database from one storage to another, keeping their keys.
+// Copies between buckets of the same S3 account are done server-side. Every copy is verified by its SHA-256 digest,
+// server-side copies by the digest recorded at upload if there is one.
+func copyBackups(args []string) {
+	flags := flag.NewFlagSet(cmdCopy, flag.ExitOnError)
+	database := flags.String("database", "myDbName", "database to copy the backups of")
+	source := prefixedStorageFlags(flags, "source")
+	destination := prefixedStorageFlags(flags, "destination")
+	keys := keyFlags(flags)
+	policy := retryFlags(flags)
+	from := flags.String("from", "", "copy backups created at or after this time according to their keys, e.g. 2019-10-14 or 2019-10-14T12:00:00Z")
+	to := flags.String("to", "", "copy backups created before this time")
+	latest := flags.Int("latest", 0, "copy only the newest backups, 0 copies all")
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	selection := backup.Selection{Latest: *latest}
+	var err error
+	if selection.From, err = parseTime(*from); err != nil {
+		log.Fatal(err)
+	}
+	if selection.To, err = parseTime(*to); err != nil {
+		log.Fatal(err)
+	}
+
+	keyProvider := keys.scopedProvider(*database)
+	sourceStorage := source.create(keyProvider, *policy, 0, 0)
+	destinationStorage := destination.create(backup.IdentityKeyProvider{}, *policy, 0, 0)
+	objects, err := sourceStorage.List(keyProvider.Prefix())
+	if err != nil {
+		log.Fatal(err)
+	}
+	binaryUploader, serverSide := destinationStorage.(*s3.BinaryUploader)
+	serverSide = serverSide && source.kind == storageS3 && source.s3Session == destination.s3Session
+	failed := 0
+	selected := selection.Apply(objects)
+	for _, object := range selected {
+		var storageLocation string
+		if serverSide {
+			storageLocation, err = binaryUploader.CopyFrom(source.bucketName, object.Key, object.Size)
+			if err == nil {
+				err = verifyCopy(binaryUploader, source.bucketName, sourceStorage, object.Key)
+			}
+		} else {
+			storageLocation, err = backup.Copy(sourceStorage, destinationStorage, object)
+		}
+		if err != nil {
+			log.Errorf("failed to copy %s, %v", object.Key, err)
+			failed++
+			continue
+		}
+		log.Infof("copied %s to %s", object.Key, storageLocation)
+	}
+	if failed > 0 {
+		log.Fatalf("failed to copy %d of %d backups of database %s", failed, len(selected), *database)
+	}
+	log.Infof("copied %d backups of database %s", len(selected), *database)
+}
+
+// verifyCopy verifies a server-side copy by the digest recorded at upload, without one source and copy are downloaded.
+func verifyCopy(binaryUploader *s3.BinaryUploader, sourceBucket string, sourceStorage backup.Opener, key string) error {
+	verified, err := binaryUploader.VerifyCopy(sourceBucket, key)
+	if err != nil || verified {
+		return err
+	}
+	log.Infof("no digest recorded for %s, verifying the copy by its content", key)
+	return backup.Verify(sourceStorage, binaryUploader, key)
+}
+
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %s, expected e.g. 2019-10-14 or 2019-10-14T12:00:00Z", value)
+}
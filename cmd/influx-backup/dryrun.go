@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+	"os"
+)
+
+// dryRun prints the files of a snapshot an archive would contain with the given -include and -exclude patterns.
+func dryRun(args []string) {
+	flags := flag.NewFlagSet(cmdDryRun, flag.ExitOnError)
+	backupPath := flags.String("backupPath", "/Users/ec2user/influxdb/data/backup", "directory with the snapshot files, e.g. a copy of a snapshot")
+	archiveSettings := archiveFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	filter, err := archiveSettings.filter()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = filter.Walk(*backupPath, func(path string, name string, info os.FileInfo) error {
+		fmt.Printf("%s\t%d\t%s\n", info.Mode(), info.Size(), name)
+		return nil
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	summary, err := filter.Summarize(*backupPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("%d files, %d directories, %d bytes\n", summary.Files, summary.Directories, summary.Size)
+}
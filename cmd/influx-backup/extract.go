@@ -0,0 +1,63 @@
+package main
+
+import (
+	"flag"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/chunk"
+	"github.com/hill-daniel/influx-backup/gzip"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+)
+
+// extract downloads a backup and unpacks it into a directory, e.g. to restore it with influxd restore.
+// The compression of the archive is detected, so gzip and zstd archives can be mixed in a storage.
+func extract(args []string) {
+	flags := flag.NewFlagSet(cmdExtract, flag.ExitOnError)
+	key := flags.String("key", "", "key of the backup as printed by "+cmdList+", the key of the index of split archives or deduplicated backups")
+	targetDir := flags.String("extractDir", "", "directory to extract the backup into")
+	storageSettings := storageFlags(flags)
+	policy := retryFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if *key == "" || *targetDir == "" {
+		log.Fatal("-key and -extractDir are required")
+	}
+
+	storage := storageSettings.create(backup.IdentityKeyProvider{}, *policy, 0, 0)
+	if strings.HasSuffix(*key, chunk.IndexSuffix) {
+		if err := chunk.NewRepository(storage, chunk.PrefixOf(*key)).Restore(*key, *targetDir); err != nil {
+			log.Fatalf("failed to restore %s, %v", *key, err)
+		}
+		log.Infof("restored %s to %s", *key, *targetDir)
+		return
+	}
+	archive, err := openBackup(storage, *key)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer func() {
+		if err := archive.Close(); err != nil {
+			log.Errorf("failed to close io archive, %v", err)
+		}
+	}()
+	if err := gzip.Extract(archive, *targetDir); err != nil {
+		log.Fatalf("failed to extract %s, %v", *key, err)
+	}
+	log.Infof("extracted %s to %s", *key, *targetDir)
+}
+
+// openBackup returns the content of a backup, the parts of a split archive are streamed in order given the key of its index.
+func openBackup(storage storage, key string) (io.ReadCloser, error) {
+	// key templates may place segments after the name of the index
+	if !strings.Contains(key, backup.VolumeIndexSuffix) {
+		return storage.Open(key)
+	}
+	index, err := backup.ReadVolumeIndex(storage, key)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("reading %d parts of %s", len(index.Parts), index.Archive)
+	return backup.NewVolumeReader(storage, key, index)
+}
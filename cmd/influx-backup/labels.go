@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"github.com/hill-daniel/influx-backup/influx"
+	"github.com/hill-daniel/influx-backup/retry"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strings"
+)
+
+// version of the tool, set with -ldflags "-X main.version=1.2.3"
+var version = "dev"
+
+const backupType = "portable"
+
+// objectTags creates the tags used for cost allocation and lifecycle rules of uploaded archives.
+func objectTags(database string, extraTags string) (map[string]string, error) {
+	tags := map[string]string{
+		"database":     database,
+		"backup-type":  backupType,
+		"tool-version": version,
+	}
+	if host, err := os.Hostname(); err == nil {
+		tags["host"] = host
+	}
+	if strings.TrimSpace(extraTags) == "" {
+		return tags, nil
+	}
+	for _, tag := range strings.Split(extraTags, ",") {
+		keyValue := strings.SplitN(strings.TrimSpace(tag), "=", 2)
+		if len(keyValue) != 2 || keyValue[0] == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected format key=value", tag)
+		}
+		tags[keyValue[0]] = keyValue[1]
+	}
+	return tags, nil
+}
+
+// objectMetadata creates metadata stored along with the archive, the influxdb version is added if available.
+func objectMetadata(policy retry.Policy) map[string]string {
+	metadata := map[string]string{"tool-version": version}
+	influxVersion, err := influx.Version(policy)
+	if err != nil {
+		log.Warnf("failed to determine influxdb version, %v", err)
+		return metadata
+	}
+	metadata["influxdb-version"] = influxVersion
+	return metadata
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	log "github.com/sirupsen/logrus"
+)
+
+// list prints the backups of a database, which are all objects below the prefix of the key provider.
+func list(args []string) {
+	flags := flag.NewFlagSet(cmdList, flag.ExitOnError)
+	database := flags.String("database", "myDbName", "database to list the backups for")
+	storageSettings := storageFlags(flags)
+	keys := keyFlags(flags)
+	policy := retryFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+
+	keyProvider := keys.scopedProvider(*database)
+	storage := storageSettings.create(keyProvider, *policy, 0, 0)
+	objects, err := storage.List(keyProvider.Prefix())
+	if err != nil {
+		log.Fatal(err)
+	}
+	for _, object := range objects {
+		fmt.Printf("%s\t%d\t%s\n", object.LastModified.Format("2006-01-02T15:04:05Z07:00"), object.Size, object.Key)
+	}
+}
@@ -2,17 +2,47 @@ package main
 
 import (
 	"flag"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"fmt"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/chunk"
 	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/hill-daniel/influx-backup/hook"
 	"github.com/hill-daniel/influx-backup/influx"
+	"github.com/hill-daniel/influx-backup/retry"
 	"github.com/hill-daniel/influx-backup/s3"
+	"github.com/hill-daniel/influx-backup/throttle"
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 )
 
-const envLogLevel = "LOG_LEVEL"
+const (
+	envLogLevel          = "LOG_LEVEL"
+	cmdAbortStaleUploads = "abort-stale-uploads"
+	cmdList              = "list"
+	cmdPrune             = "prune"
+	cmdCopy              = "copy"
+	cmdExtract           = "extract"
+	cmdDryRun            = "dry-run"
+	cmdGC                = "gc"
+	cmdCatalog           = "catalog"
+)
+
+var commands = map[string]func(args []string){
+	cmdAbortStaleUploads: abortStaleUploads,
+	cmdList:              list,
+	cmdPrune:             prune,
+	cmdCopy:              copyBackups,
+	cmdExtract:           extract,
+	cmdDryRun:            dryRun,
+	cmdGC:                collectGarbage,
+	cmdCatalog:           showCatalog,
+}
 
 func init() {
 	lvl, err := log.ParseLevel(os.Getenv(envLogLevel))
@@ -24,37 +54,337 @@ func init() {
 }
 
 func main() {
+	if len(os.Args) > 1 {
+		if command, ok := commands[os.Args[1]]; ok {
+			command(os.Args[2:])
+			return
+		}
+	}
 	data := backup.Data{}
 	flag.StringVar(&data.Database, "database", "myDbName", "database to backup")
 	flag.StringVar(&data.MountedPath, "mountedPath", "/var/lib/influxdb/backup", "path for the backup dir, mounted in docker container")
 	flag.StringVar(&data.BackupPath, "backupPath", "/Users/ec2user/influxdb/data/backup", "path for the backup dir on the host system")
-	flag.StringVar(&data.BucketName, "bucketName", "myS3Bucket", "s3 bucket name for backup upload")
+	storageSettings := storageFlags(flag.CommandLine)
+	keys := keyFlags(flag.CommandLine)
+	stagingDir := flag.String("stagingDir", "", "directory for archives, each run uses its own subdirectory, empty writes archives into the backup dir")
+	retention := retentionFlags(flag.CommandLine)
+	catalogShards := flag.Bool("catalog", true, "check the manifest of the portable snapshot before archiving and upload a catalog of its shards next to the backup")
+	hooksPath := flag.String("hooks", "", "JSON file with hooks run before and after the steps of the backup, see README")
+	stateDir := flag.String("stateDir", defaultStateDir(), "directory to persist the progress of multipart uploads, empty disables resuming")
+	policy := retryFlags(flag.CommandLine)
+	preflight := preflightFlags(flag.CommandLine)
+	archiveSettings := archiveFlags(flag.CommandLine)
+	chunks := chunkFlags(flag.CommandLine)
+	// the defaults differ by storage, e.g. 5M parts on S3 and 16M chunks on GCS, they are applied by the uploaders
+	var partSize byteSize
+	flag.Var(&partSize, "partSize", "size of the parts of multipart uploads, e.g. 64M, 0 uses the default of the storage")
+	concurrency := flag.Int("concurrency", 0, "number of parts uploaded concurrently, 0 uses the default of the storage")
+	volumeSize := byteSize(0)
+	flag.Var(&volumeSize, "volumeSize", "split archives into parts of this size, e.g. 4G for FAT file systems, 0 uploads archives as a whole")
+	uploadRate := byteSize(0)
+	flag.Var(&uploadRate, "uploadRate", "max upload bytes per second, e.g. 512K or 10M, 0 is unlimited")
+	uploadRateSchedule := flag.String("uploadRateSchedule", "", "upload rates per time of day overriding -uploadRate, e.g. 08:00-20:00=1M,20:00-22:00=0")
+	storageClass := flag.String("storageClass", "", "s3 storage class of uploaded archives, e.g. STANDARD_IA or GLACIER_IR, empty uses the bucket default")
+	extraTags := flag.String("tags", "", "additional object tags, e.g. team=metrics,env=prod")
+	lock := s3.ObjectLock{}
+	flag.StringVar(&lock.Mode, "objectLockMode", "", "object lock mode GOVERNANCE or COMPLIANCE, empty disables object lock")
+	flag.DurationVar(&lock.RetainFor, "objectLockRetention", 30*24*time.Hour, "period uploaded archives are retained by object lock")
+	flag.BoolVar(&lock.LegalHold, "legalHold", false, "put uploaded archives under legal hold")
 	flag.Parse()
+	if err := validateFlags(chunks, storageSettings); err != nil {
+		log.Fatalf("invalid flags, %v", err)
+	}
+	data.BucketName = storageSettings.bucketName
+	archiver, err := archiveSettings.archiver()
+	if err != nil {
+		log.Fatal(err)
+	}
+	schedule, err := throttle.ParseSchedule(int64(uploadRate), *uploadRateSchedule)
+	if err != nil {
+		log.Fatalf("invalid upload rate schedule, %v", err)
+	}
 
-	if err := influx.CreateSnapshot(data); err != nil {
-		log.Fatalf("failed to create snapshot for docker influxdb, %v", err)
+	keyProvider := keys.provider(data.Database)
+	tags, err := objectTags(data.Database, *extraTags)
+	if err != nil {
+		log.Fatalf("invalid tags, %v", err)
+	}
+	options := []s3.Option{
+		s3.WithResumableUploads(*stateDir),
+		s3.WithRateLimit(throttle.NewLimiter(schedule, throttle.SystemClock{})),
+		s3.WithStorageClass(*storageClass),
+	}
+	// locks of the chunk repository are deleted after every run, they are written without object lock
+	unlockedOptions := options
+	if lock.Mode != "" {
+		if err := lock.Validate(); err != nil {
+			log.Fatal(err)
+		}
+		options = append(append([]s3.Option{}, options...), s3.WithObjectLock(lock))
+	}
+	uploader, storages := storageSettings.createUploader(keyProvider, *policy, int64(partSize), *concurrency, options...)
+	for _, single := range storages {
+		if binaryUploader, ok := single.(*s3.BinaryUploader); ok && lock.Mode != "" {
+			if err := binaryUploader.CheckObjectLock(); err != nil {
+				log.Fatalf("bucket is not ready for object lock, %v", err)
+			}
+		}
+	}
+	if err := retention.Validate(data.BackupPath); err != nil {
+		log.Fatalf("invalid local retention, %v", err)
+	}
+	hooks, err := loadHooks(*hooksPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	event := hook.Event{Database: data.Database, BackupPath: data.BackupPath}
+	fail := func(err error) {
+		event.Err = err
+		if hookErr := hooks.Run(hook.OnFailure, event); hookErr != nil {
+			log.Error(hookErr)
+		}
+		log.Fatal(err)
+	}
+	archiveDir := data.BackupPath
+	if *stagingDir != "" {
+		archiveDir = *stagingDir
+	}
+	if chunks.format == formatChunks {
+		// like streaming, chunks are uploaded without an archive
+		preflight.stream = true
 	}
-	binaryUploader := createS3Uploader(data.BucketName)
-	bb := createBackuper(binaryUploader)
+	streaming, err := preflight.streaming(data, archiveDir, *policy)
+	if err != nil {
+		fail(errors.Wrap(err, "not taking a snapshot"))
+	}
+	backupOptions := []s3.BackupOption{
+		s3.WithTags(tags),
+		s3.WithMetadata(objectMetadata(*policy)),
+		s3.WithVolumeSize(int64(volumeSize)),
+		s3.WithStagingDir(*stagingDir),
+		s3.WithDatabase(data.Database),
+		s3.WithStreaming(streaming),
+		s3.WithLocalRetention(*retention),
+		s3.WithHooks(hooks, event),
+	}
+	if *catalogShards {
+		filter, err := archiveSettings.filter()
+		if err != nil {
+			log.Fatal(err)
+		}
+		backupOptions = append(backupOptions, s3.WithCatalog(filter))
+	}
+	if chunks.format == formatChunks {
+		filter, err := archiveSettings.filter()
+		if err != nil {
+			log.Fatal(err)
+		}
+		store := storageSettings.create(backup.IdentityKeyProvider{}, *policy, int64(partSize), *concurrency, options...)
+		lockStore := storageSettings.create(backup.IdentityKeyProvider{}, *policy, 0, 0, unlockedOptions...)
+		repository := chunk.NewRepository(store, chunks.repositoryPrefix(data.Database), chunk.WithConcurrency(*concurrency), chunk.WithLockStore(lockStore))
+		backupOptions = append(backupOptions, s3.WithChunkRepository(repository, filter))
+	}
+	if err := validateBackup(uploader, archiver, backupOptions, preflight.streamOnLowSpace); err != nil {
+		log.Fatalf("invalid flags, %v", err)
+	}
+	if err := hooks.Run(hook.BeforeSnapshot, event); err != nil {
+		fail(err)
+	}
+	// entries existing before the snapshot were not created by this run and are kept
+	if names, err := existingEntries(data.BackupPath); err != nil {
+		fail(err)
+	} else if names != nil {
+		backupOptions = append(backupOptions, s3.WithPreexistingEntries(names))
+	}
+	if err := influx.CreateSnapshot(data, *policy); err != nil {
+		fail(errors.Wrap(err, "failed to create snapshot for docker influxdb"))
+	}
+	if err := hooks.Run(hook.AfterSnapshot, event); err != nil {
+		fail(err)
+	}
+	bb := createBackuper(uploader, archiver, backupOptions...)
 	storageLocation, err := bb.BackUp(data.BackupPath)
+	if err != nil {
+		fail(err)
+	}
+	log.Infof("successfully dumped influxdb %s to %s at %s", data.Database, storageSettings.kind, storageLocation)
+}
+
+// validateFlags rejects flags which do not work together before anything is done. Combinations of backup options
+// are checked by BucketBackup.Validate, see validateBackup.
+func validateFlags(chunks *chunkSettings, storageSettings *storageSettings) error {
+	switch chunks.format {
+	case formatArchive:
+		return nil
+	case formatChunks:
+		if strings.Contains(storageSettings.kind, ",") {
+			return fmt.Errorf("-format=chunks stores chunks in a single storage, got -storage=%s", storageSettings.kind)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown backup format %s, expected %s or %s", chunks.format, formatArchive, formatChunks)
+	}
+}
+
+// validateBackup checks the options of the backup before the snapshot is taken. With -streamOnLowSpace
+// the archive may be streamed, the options have to work for a streamed archive as well then.
+func validateBackup(uploader backup.Uploader, archiver gzip.Tarer, options []s3.BackupOption, mayStream bool) error {
+	if err := s3.NewBucketBackup(uploader, archiver, options...).Validate(); err != nil {
+		return err
+	}
+	if !mayStream {
+		return nil
+	}
+	streamed := append(append([]s3.BackupOption{}, options...), s3.WithStreaming(true))
+	if err := s3.NewBucketBackup(uploader, archiver, streamed...).Validate(); err != nil {
+		return errors.Wrap(err, "-streamOnLowSpace may stream the archive")
+	}
+	return nil
+}
+
+func retentionFlags(flags *flag.FlagSet) *s3.LocalRetention {
+	retention := &s3.LocalRetention{}
+	flags.StringVar(&retention.Dir, "localDir", "", "directory for local copies of uploaded archives and snapshots, outside of the backup dir")
+	flags.IntVar(&retention.Archives.Last, "keepArchives", 0, "number of newest archives kept in -localDir")
+	flags.DurationVar(&retention.Archives.For, "keepArchivesFor", 0, "keep archives in -localDir for this duration, e.g. 168h")
+	flags.IntVar(&retention.Snapshots.Last, "keepSnapshots", 0, "number of newest raw snapshots kept in -localDir")
+	flags.DurationVar(&retention.Snapshots.For, "keepSnapshotsFor", 0, "keep raw snapshots in -localDir for this duration, e.g. 24h")
+	return retention
+}
+
+func retryFlags(flags *flag.FlagSet) *retry.Policy {
+	policy := retry.DefaultPolicy()
+	flags.IntVar(&policy.MaxAttempts, "retryAttempts", policy.MaxAttempts, "max attempts for snapshot and storage calls, 1 disables retries")
+	flags.DurationVar(&policy.BaseDelay, "retryBaseDelay", policy.BaseDelay, "delay after the first failed attempt, doubled for each further attempt")
+	flags.DurationVar(&policy.MaxDelay, "retryMaxDelay", policy.MaxDelay, "upper bound for the delay between attempts")
+	flags.Float64Var(&policy.Jitter, "retryJitter", policy.Jitter, "fraction (0-1) to randomize the delay between attempts")
+	return &policy
+}
+
+// keySettings select the key provider. The hex prefixed keys shared by all databases stay the default,
+// so the backups already stored in a bucket keep being listed, pruned and copied.
+type keySettings struct {
+	template string
+	prefix   string
+	scoped   bool
+}
+
+func keyFlags(flags *flag.FlagSet) *keySettings {
+	keys := &keySettings{}
+	flags.StringVar(&keys.template, "keyTemplate", "", "template for object keys, e.g. "+s3.DefaultKeyTemplate+", empty uses hex prefixed keys")
+	flags.BoolVar(&keys.scoped, "scopeKeys", false, "scope the hex prefixed keys by database, e.g. 6d657472_metrics/dump_<timestamp>.tar.gz")
+	flags.StringVar(&keys.prefix, "keyPrefix", "influx-backup", "value of {{.Prefix}} in the key template")
+	return keys
+}
+
+func (k *keySettings) provider(database string) s3.BucketKeyProvider {
+	if k.template == "" {
+		if k.scoped {
+			return s3.HexKeyProvider{Database: database}
+		}
+		return s3.HexKeyProvider{}
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		log.Fatalf("failed to determine host name for key template, %v", err)
+	}
+	keyProvider, err := s3.NewTemplateKeyProvider(k.template, s3.KeyData{Prefix: k.prefix, Host: host, Database: database})
 	if err != nil {
 		log.Fatal(err)
 	}
-	log.Infof("successfully dumped influxdb %s to s3 at %s", data.Database, storageLocation)
+	return keyProvider
+}
+
+// scopedProvider returns the key provider for commands selecting the backups of a database by the prefix, e.g. prune.
+// A template without the database in the prefix would select the backups of all databases, it is rejected.
+// The unscoped hex keys are shared by all databases, they are still selected for buckets written before -scopeKeys.
+func (k *keySettings) scopedProvider(database string) s3.BucketKeyProvider {
+	keyProvider := k.provider(database)
+	if k.template == "" && !k.scoped {
+		log.Warnf("keys are not scoped by database, the backups of all databases are selected, see -scopeKeys")
+	}
+	if templateProvider, ok := keyProvider.(*s3.TemplateKeyProvider); ok && !templateProvider.ScopedToDatabase() {
+		log.Fatalf("key template %s does not scope the keys by database, {{.Database}} has to precede the date and name of the backup", k.template)
+	}
+	return keyProvider
+}
+
+func defaultStateDir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".influx-backup", "uploads")
+}
+
+// byteSize is a flag accepting a number of bytes with a unit, e.g. 64M.
+type byteSize int64
+
+func (b *byteSize) String() string {
+	return strconv.FormatInt(int64(*b), 10)
+}
+
+func (b *byteSize) Set(value string) error {
+	size, err := throttle.ParseBytes(value)
+	if err != nil {
+		return err
+	}
+	*b = byteSize(size)
+	return nil
 }
 
-func createS3Uploader(bucketName string) *s3.BinaryUploader {
-	sharedSession := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	uploader := s3manager.NewUploader(sharedSession)
-	keyProvider := s3.HexKeyProvider{}
-	binaryUploader := s3.NewBinaryUploader(uploader, keyProvider, bucketName)
+// createS3Uploader keeps the part size and concurrency of s3manager unless they are given.
+func createS3Uploader(config s3.SessionConfig, keyProvider s3.BucketKeyProvider, bucketName string, partSize int64, concurrency int, options ...s3.Option) *s3.BinaryUploader {
+	sharedSession, err := s3.NewSession(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploader := s3manager.NewUploader(sharedSession, func(u *s3manager.Uploader) {
+		if partSize > 0 {
+			u.PartSize = partSize
+		}
+		if concurrency > 0 {
+			u.Concurrency = concurrency
+		}
+	})
+	binaryUploader := s3.NewBinaryUploader(uploader, keyProvider, bucketName, options...)
 	return &binaryUploader
 }
 
-func createBackuper(uploader backup.Uploader) backup.Backup {
-	archiver := gzip.GzTarer{}
-	bb := s3.NewBucketBackup(uploader, archiver)
+// loadHooks loads the hooks of the given file, without file no hooks are run.
+func loadHooks(path string) (*hook.Runner, error) {
+	if path == "" {
+		return nil, nil
+	}
+	hooks, err := hook.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	return hook.NewRunner(hooks), nil
+}
+
+// existingEntries returns the names in dir, nil if dir does not exist and is created by the snapshot.
+func existingEntries(dir string) ([]string, error) {
+	file, err := os.Open(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open backup dir %s", dir)
+	}
+	defer func() {
+		if err := file.Close(); err != nil {
+			log.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	names, err := file.Readdirnames(0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read backup dir %s", dir)
+	}
+	return append([]string{}, names...), nil
+}
+
+func createBackuper(uploader backup.Uploader, archiver gzip.Tarer, options ...s3.BackupOption) backup.Backup {
+	bb := s3.NewBucketBackup(uploader, archiver, options...)
 	return bb
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"flag"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/disk"
+	"github.com/hill-daniel/influx-backup/influx"
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// preflightSettings configure the free space check before taking a snapshot.
+type preflightSettings struct {
+	check            bool
+	dataDir          string
+	headroom         float64
+	stream           bool
+	streamOnLowSpace bool
+}
+
+func preflightFlags(flags *flag.FlagSet) *preflightSettings {
+	settings := &preflightSettings{}
+	flags.BoolVar(&settings.check, "checkSpace", true, "check the free space for snapshot and archive before taking the snapshot")
+	flags.StringVar(&settings.dataDir, "influxDataDir", influx.DefaultDataDir, "data directory of influxdb in the docker container, its size estimates the size of the snapshot")
+	flags.Float64Var(&settings.headroom, "spaceHeadroom", 0.1, "fraction of free space required in addition to the estimated size")
+	flags.BoolVar(&settings.stream, "stream", false, "upload archives while they are written without storing them locally")
+	flags.BoolVar(&settings.streamOnLowSpace, "streamOnLowSpace", false, "stream the archive if there is space for the snapshot but not for the archive")
+	return settings
+}
+
+// streaming checks that the snapshot and the archive fit on their file systems and returns whether the archive
+// has to be streamed. The snapshot and the archive each need about the size of the shards of the database.
+func (p *preflightSettings) streaming(data backup.Data, archiveDir string, policy retry.Policy) (bool, error) {
+	if !p.check {
+		return p.stream, nil
+	}
+	size, err := influx.DataSize(data.Database, p.dataDir, policy)
+	if err != nil {
+		log.Warnf("unable to estimate the size of the snapshot, skipping free space check, %v", err)
+		return p.stream, nil
+	}
+	required := int64(float64(size) * (1 + p.headroom))
+	log.Infof("snapshot of %s needs about %s", data.Database, disk.FormatBytes(uint64(required)))
+	snapshot := disk.Requirement{Path: data.BackupPath, Size: required}
+	if p.stream {
+		return true, disk.RequireAll(snapshot)
+	}
+	err = disk.RequireAll(snapshot, disk.Requirement{Path: archiveDir, Size: required})
+	if errors.Cause(err) != disk.ErrNotEnoughSpace || !p.streamOnLowSpace {
+		return false, err
+	}
+	if err := disk.RequireAll(snapshot); err != nil {
+		return false, err
+	}
+	log.Warnf("streaming the archive, %v", err)
+	return true, nil
+}
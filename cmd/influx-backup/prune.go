@@ -0,0 +1,31 @@
+package main
+
+import (
+	"flag"
+	"github.com/hill-daniel/influx-backup"
+	log "github.com/sirupsen/logrus"
+)
+
+// prune deletes old backups of a database, keeping the newest ones. Locked backups are never deleted.
+func prune(args []string) {
+	flags := flag.NewFlagSet(cmdPrune, flag.ExitOnError)
+	database := flags.String("database", "myDbName", "database to prune the backups for")
+	storageSettings := storageFlags(flags)
+	keep := flags.Int("keep", 7, "number of newest backups to keep")
+	keys := keyFlags(flags)
+	policy := retryFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		log.Fatal(err)
+	}
+	if *keep < 0 {
+		log.Fatalf("-keep has to be at least 0, got %d", *keep)
+	}
+
+	keyProvider := keys.scopedProvider(*database)
+	storage := storageSettings.create(keyProvider, *policy, 0, 0)
+	deleted, err := backup.Prune(storage, keyProvider.Prefix(), *keep)
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Infof("pruned %d backups of database %s", len(deleted), *database)
+}
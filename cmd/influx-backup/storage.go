@@ -0,0 +1,223 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/azure"
+	"github.com/hill-daniel/influx-backup/filesystem"
+	"github.com/hill-daniel/influx-backup/gcs"
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/hill-daniel/influx-backup/s3"
+	"github.com/hill-daniel/influx-backup/sftp"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	storageS3         = "s3"
+	storageFilesystem = "filesystem"
+	storageSFTP       = "sftp"
+	storageAzure      = "azure"
+	storageGCS        = "gcs"
+)
+
+// secrets are read from the environment to keep them out of the process list
+const (
+	envSFTPKeyPassphrase = "SFTP_KEY_PASSPHRASE"
+	envAzureAccountKey   = "AZURE_STORAGE_KEY"
+	envAzureSASToken     = "AZURE_STORAGE_SAS_TOKEN"
+)
+
+// storage stores, lists, reads and deletes backups.
+type storage interface {
+	backup.Uploader
+	backup.Storage
+	backup.Opener
+}
+
+// storageSettings select where backups are stored.
+type storageSettings struct {
+	kind               string
+	bucketName         string
+	targetDir          string
+	fileMode           string
+	owner              string
+	s3Session          s3.SessionConfig
+	sftp               sftp.Config
+	azure              azure.Config
+	azureTier          string
+	gcsCredentialsFile string
+	gcsStorageClass    string
+	minDestinations    int
+}
+
+func storageFlags(flags *flag.FlagSet) *storageSettings {
+	return prefixedStorageFlags(flags, "")
+}
+
+// prefixedStorageFlags registers the storage flags with a prefix, e.g. -sourceBucketName for prefix source.
+func prefixedStorageFlags(flags *flag.FlagSet, prefix string) *storageSettings {
+	settings := &storageSettings{}
+	flags.StringVar(&settings.kind, flagName(prefix, "storage"), storageS3, "storage backend, one of "+storageS3+", "+storageFilesystem+", "+storageSFTP+", "+storageAzure+", "+storageGCS+", backups are uploaded to all of a comma separated list")
+	flags.IntVar(&settings.minDestinations, flagName(prefix, "minDestinations"), 0, "uploads to a list of storages succeed if at least this many succeed, 0 requires all")
+	flags.StringVar(&settings.bucketName, flagName(prefix, "bucketName"), "myS3Bucket", "s3 or gcs bucket name for backup upload")
+	flags.StringVar(&settings.targetDir, flagName(prefix, "targetDir"), "", "target directory of the filesystem or sftp storage, e.g. an NFS mount")
+	flags.StringVar(&settings.fileMode, flagName(prefix, "fileMode"), "0640", "permissions of files in the filesystem or sftp storage")
+	flags.StringVar(&settings.owner, flagName(prefix, "owner"), "", "uid:gid of files in the filesystem storage, empty keeps the current user")
+	session := &settings.s3Session
+	flags.StringVar(&session.Endpoint, flagName(prefix, "s3Endpoint"), "", "endpoint URL of S3-compatible storages, e.g. https://minio.local:9000")
+	flags.StringVar(&session.Region, flagName(prefix, "s3Region"), "", "region of the bucket, empty uses the shared AWS config")
+	flags.BoolVar(&session.PathStyle, flagName(prefix, "s3PathStyle"), false, "use path-style addressing (endpoint/bucket/key), required by most S3-compatible storages")
+	flags.StringVar(&session.CABundle, flagName(prefix, "s3CABundle"), "", "PEM file with CA certificates of the S3 endpoint")
+	flags.BoolVar(&session.InsecureSkipVerify, flagName(prefix, "s3InsecureSkipVerify"), false, "skip verification of the TLS certificate of the S3 endpoint")
+	flags.StringVar(&session.Credentials, flagName(prefix, "s3Credentials"), s3.CredentialsDefault, "source of credentials, one of "+s3.CredentialsDefault+", "+s3.CredentialsEnv+", "+s3.CredentialsFile)
+	flags.StringVar(&session.CredentialsFile, flagName(prefix, "s3CredentialsFile"), "", "credentials file in the format of ~/.aws/credentials")
+	flags.StringVar(&session.CredentialsProfile, flagName(prefix, "s3CredentialsProfile"), "", "profile in the credentials file, empty uses default")
+	ssh := &settings.sftp
+	flags.StringVar(&ssh.Address, flagName(prefix, "sftpAddress"), "", "host or host:port of the sftp storage")
+	flags.StringVar(&ssh.User, flagName(prefix, "sftpUser"), "", "ssh user of the sftp storage, defaults to root")
+	flags.StringVar(&ssh.KeyFile, flagName(prefix, "sftpKeyFile"), "", "private key for ssh authentication")
+	flags.BoolVar(&ssh.UseAgent, flagName(prefix, "sftpUseAgent"), false, "authenticate with the ssh agent at SSH_AUTH_SOCK")
+	flags.StringVar(&ssh.KnownHostsFile, flagName(prefix, "sftpKnownHosts"), "", "known_hosts file to verify the host key, defaults to ~/.ssh/known_hosts")
+	flags.StringVar(&settings.azure.ContainerURL, flagName(prefix, "azureContainerURL"), "", "URL of the blob container, e.g. https://account.blob.core.windows.net/backups")
+	flags.StringVar(&settings.azure.AccountName, flagName(prefix, "azureAccountName"), "", "storage account name for shared key authentication, the key is read from "+envAzureAccountKey)
+	flags.StringVar(&settings.azureTier, flagName(prefix, "azureAccessTier"), "", "access tier of uploaded blobs, one of Hot, Cool, Archive, empty uses the account default")
+	flags.StringVar(&settings.gcsCredentialsFile, flagName(prefix, "gcsCredentialsFile"), "", "service account JSON of the gcs storage, empty uses the application default credentials, e.g. workload identity")
+	flags.StringVar(&settings.gcsStorageClass, flagName(prefix, "gcsStorageClass"), "", "gcs storage class of uploaded archives, e.g. NEARLINE or COLDLINE, empty uses the bucket default")
+	return settings
+}
+
+func flagName(prefix string, name string) string {
+	if prefix == "" {
+		return name
+	}
+	return prefix + strings.ToUpper(name[:1]) + name[1:]
+}
+
+// createUploader builds the selected storages, a list of storages is combined by a fan-out uploader.
+// The single storages are returned as well, e.g. to check their configuration.
+func (s *storageSettings) createUploader(keyProvider backup.KeyProvider, policy retry.Policy, partSize int64, concurrency int, options ...s3.Option) (backup.Uploader, []storage) {
+	kinds := strings.Split(s.kind, ",")
+	if len(kinds) == 1 {
+		single := s.create(keyProvider, policy, partSize, concurrency, options...)
+		return single, []storage{single}
+	}
+	var destinations []backup.Destination
+	var storages []storage
+	for _, kind := range kinds {
+		kind = strings.TrimSpace(kind)
+		single := s.createKind(kind, keyProvider, policy, partSize, concurrency, options...)
+		destinations = append(destinations, backup.Destination{Name: kind, Uploader: single})
+		storages = append(storages, single)
+	}
+	return backup.NewFanOutUploader(destinations, s.minDestinations), storages
+}
+
+// create builds the selected storage, the s3 options are ignored by other storages.
+func (s *storageSettings) create(keyProvider backup.KeyProvider, policy retry.Policy, partSize int64, concurrency int, options ...s3.Option) storage {
+	if strings.Contains(s.kind, ",") {
+		log.Fatalf("a single storage is required, got %s", s.kind)
+	}
+	return s.createKind(s.kind, keyProvider, policy, partSize, concurrency, options...)
+}
+
+// createKind builds a single storage, storages without retries of their own are wrapped in the retry policy.
+func (s *storageSettings) createKind(kind string, keyProvider backup.KeyProvider, policy retry.Policy, partSize int64, concurrency int, options ...s3.Option) storage {
+	switch kind {
+	case storageS3:
+		options = append([]s3.Option{s3.WithRetryPolicy(policy)}, options...)
+		return createS3Uploader(s.s3Session, keyProvider, s.bucketName, partSize, concurrency, options...)
+	case storageFilesystem:
+		return backup.NewRetryingStorage(s.createFilesystemUploader(keyProvider), policy)
+	case storageSFTP:
+		return backup.NewRetryingStorage(s.createSFTPUploader(keyProvider), policy)
+	case storageAzure:
+		return s.createAzureUploader(keyProvider, policy, partSize, concurrency)
+	case storageGCS:
+		return backup.NewRetryingStorage(s.createGCSUploader(keyProvider, partSize), policy)
+	default:
+		log.Fatalf("unknown storage %s", kind)
+		return nil
+	}
+}
+
+func (s *storageSettings) createFilesystemUploader(keyProvider backup.KeyProvider) *filesystem.Uploader {
+	if s.targetDir == "" {
+		log.Fatal("-targetDir is required for the filesystem storage")
+	}
+	mode, err := strconv.ParseUint(s.fileMode, 8, 32)
+	if err != nil {
+		log.Fatalf("invalid file mode %s, %v", s.fileMode, err)
+	}
+	options := []filesystem.Option{filesystem.WithPermissions(os.FileMode(mode))}
+	if s.owner != "" {
+		uid, gid, err := parseOwner(s.owner)
+		if err != nil {
+			log.Fatal(err)
+		}
+		options = append(options, filesystem.WithOwner(uid, gid))
+	}
+	uploader := filesystem.NewUploader(s.targetDir, keyProvider, options...)
+	return &uploader
+}
+
+// createSFTPUploader connects to the server, the connection is closed when the process exits.
+func (s *storageSettings) createSFTPUploader(keyProvider backup.KeyProvider) *sftp.Uploader {
+	if s.targetDir == "" {
+		log.Fatal("-targetDir is required for the sftp storage")
+	}
+	mode, err := strconv.ParseUint(s.fileMode, 8, 32)
+	if err != nil {
+		log.Fatalf("invalid file mode %s, %v", s.fileMode, err)
+	}
+	s.sftp.KeyPassphrase = os.Getenv(envSFTPKeyPassphrase)
+	connection, err := sftp.Dial(s.sftp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploader := sftp.NewUploader(connection.Client, connection.Host(), s.targetDir, keyProvider, sftp.WithPermissions(os.FileMode(mode)))
+	return &uploader
+}
+
+func (s *storageSettings) createAzureUploader(keyProvider backup.KeyProvider, policy retry.Policy, blockSize int64, concurrency int) *azure.BlobUploader {
+	tier, err := azure.ParseAccessTier(s.azureTier)
+	if err != nil {
+		log.Fatal(err)
+	}
+	s.azure.AccountKey = os.Getenv(envAzureAccountKey)
+	s.azure.SASToken = os.Getenv(envAzureSASToken)
+	container, err := azure.NewContainerURL(s.azure, policy)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploader := azure.NewBlobUploader(container, keyProvider, azure.WithAccessTier(tier), azure.WithBlockSize(blockSize), azure.WithConcurrency(concurrency))
+	return &uploader
+}
+
+func (s *storageSettings) createGCSUploader(keyProvider backup.KeyProvider, chunkSize int64) *gcs.ObjectUploader {
+	client, err := gcs.NewClient(s.gcsCredentialsFile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	uploader := gcs.NewObjectUploader(client, s.bucketName, keyProvider, gcs.WithStorageClass(s.gcsStorageClass), gcs.WithChunkSize(chunkSize))
+	return &uploader
+}
+
+func parseOwner(owner string) (int, int, error) {
+	ids := strings.SplitN(owner, ":", 2)
+	if len(ids) != 2 {
+		return 0, 0, fmt.Errorf("invalid owner %q, expected format uid:gid", owner)
+	}
+	uid, err := strconv.Atoi(ids[0])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid %q", ids[0])
+	}
+	gid, err := strconv.Atoi(ids[1])
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gid %q", ids[1])
+	}
+	return uid, gid, nil
+}
@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"time"
+)
+
+// Selection selects stored backups by time range and count. Zero values do not restrict the selection.
+type Selection struct {
+	// From and To limit the time of the backup to [From, To). It is the timestamp in the key of the backup,
+	// the newest modification time of its files if the key has none.
+	From   time.Time
+	To     time.Time
+	Latest int
+}
+
+// Apply returns the selected objects, newest first. The parts and index of a split archive count as one backup,
+// they are selected together by the time of the backup and the index follows the parts, the catalog follows both.
+func (s Selection) Apply(objects []StoredObject) []StoredObject {
+	var selected []StoredObject
+	count := 0
+	for _, backup := range groupBackups(objects) {
+		if !s.From.IsZero() && backup.created.Before(s.From) {
+			continue
+		}
+		if !s.To.IsZero() && !backup.created.Before(s.To) {
+			continue
+		}
+		if s.Latest > 0 && count == s.Latest {
+			break
+		}
+		selected = append(selected, backup.objects...)
+		count++
+	}
+	return selected
+}
+
+// CopyDestination stores copied backups and reads them back for verification.
+// The uploader has to keep the keys as they are, see IdentityKeyProvider.
+type CopyDestination interface {
+	Uploader
+	Opener
+}
+
+// IdentityKeyProvider keeps keys unchanged, used for destinations of copies which keep the keys of the source.
+type IdentityKeyProvider struct{}
+
+// CreateKeyFor returns the symbol unchanged.
+func (IdentityKeyProvider) CreateKeyFor(symbol string) (string, error) {
+	return symbol, nil
+}
+
+// Prefix returns an empty prefix.
+func (IdentityKeyProvider) Prefix() string {
+	return ""
+}
+
+// Copy streams the backup from source to destination and verifies the SHA-256 digest of the copy.
+// Content type, tags and metadata are carried over if the source is a Stater, other sources do not keep them.
+func Copy(source Opener, destination CopyDestination, object StoredObject) (string, error) {
+	var attributes Attributes
+	if stater, ok := source.(Stater); ok {
+		var err error
+		if attributes, err = stater.Stat(object.Key); err != nil {
+			return "", err
+		}
+	}
+	reader, err := source.Open(object.Key)
+	if err != nil {
+		return "", err
+	}
+	defer closeReader(reader, object.Key)
+	hash := sha256.New()
+	storageLocation, err := destination.Upload(&FileContent{
+		Key:         object.Key,
+		Body:        io.TeeReader(reader, hash),
+		Size:        object.Size,
+		ContentType: attributes.ContentType,
+		Tags:        attributes.Tags,
+		Metadata:    attributes.Metadata,
+	})
+	if err != nil {
+		return "", err
+	}
+	copied, err := Digest(destination, object.Key)
+	if err != nil {
+		return "", err
+	}
+	if digest := hash.Sum(nil); !bytes.Equal(digest, copied) {
+		return "", fmt.Errorf("digest of copy %x does not match digest of source %x for %s", copied, digest, object.Key)
+	}
+	return storageLocation, nil
+}
+
+// Verify compares the SHA-256 digests of a backup in two storages, e.g. after a server-side copy.
+func Verify(source Opener, destination Opener, key string) error {
+	expected, err := Digest(source, key)
+	if err != nil {
+		return err
+	}
+	actual, err := Digest(destination, key)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(expected, actual) {
+		return fmt.Errorf("digest of copy %x does not match digest of source %x for %s", actual, expected, key)
+	}
+	return nil
+}
+
+// Digest reads the backup and calculates its SHA-256 digest.
+func Digest(opener Opener, key string) ([]byte, error) {
+	reader, err := opener.Open(key)
+	if err != nil {
+		return nil, err
+	}
+	defer closeReader(reader, key)
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, errors.Wrapf(err, "failed to read %s", key)
+	}
+	return hash.Sum(nil), nil
+}
+
+func closeReader(reader io.Closer, key string) {
+	if err := reader.Close(); err != nil {
+		log.Errorf("failed to close reader of %s, %v", key, err)
+	}
+}
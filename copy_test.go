@@ -0,0 +1,143 @@
+package backup_test
+
+import (
+	"bytes"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+// memoryStorage keeps files and their attributes in memory, corrupt flips the first byte of every stored file.
+type memoryStorage struct {
+	files      map[string][]byte
+	attributes map[string]backup.Attributes
+	corrupt    bool
+}
+
+func (s *memoryStorage) Upload(content *backup.FileContent) (string, error) {
+	data, err := ioutil.ReadAll(content.Reader())
+	if err != nil {
+		return "", err
+	}
+	if s.corrupt && len(data) > 0 {
+		data[0]++
+	}
+	s.files[content.Key] = data
+	if s.attributes == nil {
+		s.attributes = make(map[string]backup.Attributes)
+	}
+	s.attributes[content.Key] = backup.Attributes{ContentType: content.ContentType, Tags: content.Tags, Metadata: content.Metadata}
+	return "memory://" + content.Key, nil
+}
+
+func (s *memoryStorage) Stat(key string) (backup.Attributes, error) {
+	if _, ok := s.files[key]; !ok {
+		return backup.Attributes{}, errors.New("not found")
+	}
+	return s.attributes[key], nil
+}
+
+func (s *memoryStorage) Open(key string) (io.ReadCloser, error) {
+	data, ok := s.files[key]
+	if !ok {
+		return nil, errors.New("not found")
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+func Test_should_select_backups_by_time_range_and_latest(t *testing.T) {
+	day := time.Date(2019, 10, 14, 12, 0, 0, 0, time.UTC)
+	objects := []backup.StoredObject{
+		{Key: "dump_1", LastModified: day.Add(-72 * time.Hour)},
+		{Key: "dump_4", LastModified: day},
+		{Key: "dump_2", LastModified: day.Add(-48 * time.Hour)},
+		{Key: "dump_3", LastModified: day.Add(-24 * time.Hour)},
+	}
+
+	selected := backup.Selection{From: day.Add(-72 * time.Hour), To: day, Latest: 2}.Apply(objects)
+
+	if len(selected) != 2 || selected[0].Key != "dump_3" || selected[1].Key != "dump_2" {
+		t.Fatalf("unexpected selection %v", selected)
+	}
+}
+
+func Test_should_select_backups_by_timestamp_in_key(t *testing.T) {
+	day := time.Date(2019, 10, 14, 12, 0, 0, 0, time.Local)
+	objects := []backup.StoredObject{
+		{Key: "metrics/dump_20191011120000.tar.gz", LastModified: day},
+		{Key: "metrics/dump_20191012120000.tar.gz", LastModified: day},
+		{Key: "metrics/dump_20191013120000.tar.gz", LastModified: day.Add(-96 * time.Hour)},
+	}
+
+	selected := backup.Selection{From: day.Add(-60 * time.Hour), To: day}.Apply(objects)
+
+	if len(selected) != 2 || selected[0].Key != "metrics/dump_20191013120000.tar.gz" || selected[1].Key != "metrics/dump_20191012120000.tar.gz" {
+		t.Fatalf("unexpected selection %v", selected)
+	}
+}
+
+func Test_should_select_all_backups_without_restrictions(t *testing.T) {
+	objects := []backup.StoredObject{{Key: "dump_1"}, {Key: "dump_2"}}
+
+	selected := backup.Selection{}.Apply(objects)
+
+	if len(selected) != 2 {
+		t.Fatalf("unexpected selection %v", selected)
+	}
+}
+
+func Test_should_copy_backup_and_verify_digest(t *testing.T) {
+	source := &memoryStorage{files: map[string][]byte{"metrics/dump_1.tar.gz": []byte("archive")}}
+	destination := &memoryStorage{files: map[string][]byte{}}
+
+	storageLocation, err := backup.Copy(source, destination, backup.StoredObject{Key: "metrics/dump_1.tar.gz", Size: 7})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageLocation != "memory://metrics/dump_1.tar.gz" {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	if string(destination.files["metrics/dump_1.tar.gz"]) != "archive" {
+		t.Fatalf("unexpected copy %s", destination.files["metrics/dump_1.tar.gz"])
+	}
+}
+
+func Test_should_keep_content_type_tags_and_metadata_of_copy(t *testing.T) {
+	attributes := backup.Attributes{
+		ContentType: "application/gzip",
+		Tags:        map[string]string{"retention": "monthly"},
+		Metadata:    map[string]string{"uncompressed-size": "42"},
+	}
+	source := &memoryStorage{
+		files:      map[string][]byte{"metrics/dump_1.tar.gz": []byte("archive")},
+		attributes: map[string]backup.Attributes{"metrics/dump_1.tar.gz": attributes},
+	}
+	destination := &memoryStorage{files: map[string][]byte{}}
+
+	if _, err := backup.Copy(source, destination, backup.StoredObject{Key: "metrics/dump_1.tar.gz", Size: 7}); err != nil {
+		t.Fatal(err)
+	}
+
+	copied := destination.attributes["metrics/dump_1.tar.gz"]
+	if copied.ContentType != "application/gzip" || copied.Tags["retention"] != "monthly" || copied.Metadata["uncompressed-size"] != "42" {
+		t.Fatalf("unexpected attributes of copy %+v", copied)
+	}
+}
+
+func Test_should_fail_copy_if_digests_differ(t *testing.T) {
+	source := &memoryStorage{files: map[string][]byte{"metrics/dump_1.tar.gz": []byte("archive")}}
+	destination := &memoryStorage{files: map[string][]byte{}, corrupt: true}
+
+	_, err := backup.Copy(source, destination, backup.StoredObject{Key: "metrics/dump_1.tar.gz", Size: 7})
+
+	if err == nil {
+		t.Fatal("expected error for corrupted copy")
+	}
+	if err := backup.Verify(source, destination, "metrics/dump_1.tar.gz"); err == nil {
+		t.Fatal("expected error when verifying corrupted copy")
+	}
+}
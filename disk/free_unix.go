@@ -0,0 +1,27 @@
+// +build linux darwin
+
+package disk
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"syscall"
+)
+
+// Free returns the bytes available to unprivileged users on the file system of path.
+func Free(path string) (uint64, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return 0, errors.Wrapf(err, "failed to determine free space of %s", path)
+	}
+	return stat.Bavail * uint64(stat.Bsize), nil
+}
+
+// fileSystem identifies the file system of path by its device.
+func fileSystem(path string) (string, error) {
+	var stat syscall.Stat_t
+	if err := syscall.Stat(path, &stat); err != nil {
+		return "", errors.Wrapf(err, "failed to determine file system of %s", path)
+	}
+	return fmt.Sprint(stat.Dev), nil
+}
@@ -0,0 +1,40 @@
+package disk
+
+import (
+	"github.com/pkg/errors"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+// Free returns the bytes available to the current user on the volume of path.
+func Free(path string) (uint64, error) {
+	kernel32, err := syscall.LoadDLL("kernel32.dll")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to load kernel32.dll")
+	}
+	getDiskFreeSpaceEx, err := kernel32.FindProc("GetDiskFreeSpaceExW")
+	if err != nil {
+		return 0, errors.Wrap(err, "failed to find GetDiskFreeSpaceExW")
+	}
+	pathPointer, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid path %s", path)
+	}
+	var available uint64
+	result, _, err := getDiskFreeSpaceEx.Call(uintptr(unsafe.Pointer(pathPointer)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	if result == 0 {
+		return 0, errors.Wrapf(err, "failed to determine free space of %s", path)
+	}
+	return available, nil
+}
+
+// fileSystem identifies the volume of path by its name, e.g. C:.
+func fileSystem(path string) (string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to determine volume of %s", path)
+	}
+	return strings.ToUpper(filepath.VolumeName(absolute)), nil
+}
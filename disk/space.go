@@ -0,0 +1,118 @@
+package disk
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrNotEnoughSpace is returned by Require if the file system has too little free space.
+	ErrNotEnoughSpace = errors.New("not enough free disk space")
+	// ErrUnknown is returned by Free on platforms without support for determining the free space.
+	ErrUnknown = errors.New("free disk space is unknown on this platform")
+)
+
+// Require checks that the file system of path has at least required bytes available.
+// The check is skipped with a warning if the free space is unknown on the platform.
+func Require(path string, required int64) error {
+	available, err := Free(path)
+	if err == ErrUnknown {
+		log.Warnf("not checking the free space of %s, %v", path, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if uint64(required) > available {
+		return errors.Wrap(ErrNotEnoughSpace, fmt.Sprintf("%s needs %s, %s available", path, FormatBytes(uint64(required)), FormatBytes(available)))
+	}
+	return nil
+}
+
+// Requirement is the space needed on the file system of Path, Path may not exist yet.
+type Requirement struct {
+	Path string
+	Size int64
+}
+
+// RequireAll checks the free space of all requirements, the sizes of requirements on the same file system add up.
+// Paths which do not exist yet are checked at their closest existing parent. The check is skipped
+// with a warning if the free space is unknown on the platform.
+func RequireAll(requirements ...Requirement) error {
+	var order []string
+	required := make(map[string]int64)
+	paths := make(map[string][]string)
+	checked := make(map[string]string)
+	for _, requirement := range requirements {
+		existing, err := existingParent(requirement.Path)
+		if err != nil {
+			return err
+		}
+		id, err := fileSystem(existing)
+		if err != nil {
+			return err
+		}
+		if _, ok := required[id]; !ok {
+			order = append(order, id)
+			checked[id] = existing
+		}
+		required[id] += requirement.Size
+		paths[id] = append(paths[id], requirement.Path)
+	}
+	var failures []string
+	for _, id := range order {
+		available, err := Free(checked[id])
+		if err == ErrUnknown {
+			log.Warnf("not checking the free space of %s, %v", strings.Join(paths[id], " and "), err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if uint64(required[id]) > available {
+			failures = append(failures, fmt.Sprintf("%s needs %s, %s available",
+				strings.Join(paths[id], " and "), FormatBytes(uint64(required[id])), FormatBytes(available)))
+		}
+	}
+	if len(failures) > 0 {
+		return errors.Wrap(ErrNotEnoughSpace, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+func existingParent(path string) (string, error) {
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid path %s", path)
+	}
+	for {
+		if _, err := os.Stat(absolute); err == nil {
+			return absolute, nil
+		} else if !os.IsNotExist(err) {
+			return "", errors.Wrapf(err, "failed to check %s", absolute)
+		}
+		parent := filepath.Dir(absolute)
+		if parent == absolute {
+			return "", fmt.Errorf("no existing parent of %s", path)
+		}
+		absolute = parent
+	}
+}
+
+// FormatBytes formats a number of bytes with a binary unit, e.g. 1.5 GiB.
+func FormatBytes(bytes uint64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	value, exponent := float64(bytes)/unit, 0
+	for value >= unit && exponent < 4 {
+		value /= unit
+		exponent++
+	}
+	return fmt.Sprintf("%.1f %ciB", value, "KMGTP"[exponent])
+}
@@ -0,0 +1,58 @@
+package disk_test
+
+import (
+	"github.com/hill-daniel/influx-backup/disk"
+	"github.com/pkg/errors"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_should_determine_free_space(t *testing.T) {
+	free, err := disk.Free(os.TempDir())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if free == 0 {
+		t.Fatal("expected free space in the temp dir")
+	}
+}
+
+func Test_should_fail_if_required_space_exceeds_free_space(t *testing.T) {
+	err := disk.Require(os.TempDir(), math.MaxInt64)
+
+	if errors.Cause(err) != disk.ErrNotEnoughSpace {
+		t.Fatalf("expected %v, got %v", disk.ErrNotEnoughSpace, err)
+	}
+	if err := disk.Require(os.TempDir(), 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_add_up_requirements_on_the_same_file_system(t *testing.T) {
+	free, err := disk.Free(os.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	half := int64(free/2) + 1
+	notYetCreated := filepath.Join(os.TempDir(), "influx-backup-not-created", "snapshot")
+
+	err = disk.RequireAll(disk.Requirement{Path: os.TempDir(), Size: half}, disk.Requirement{Path: notYetCreated, Size: half})
+
+	if errors.Cause(err) != disk.ErrNotEnoughSpace {
+		t.Fatalf("expected %v, got %v", disk.ErrNotEnoughSpace, err)
+	}
+	if err := disk.RequireAll(disk.Requirement{Path: os.TempDir(), Size: 1}, disk.Requirement{Path: notYetCreated, Size: 1}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_format_bytes_with_binary_unit(t *testing.T) {
+	for bytes, expected := range map[uint64]string{512: "512 B", 1536: "1.5 KiB", 15 * 1024 * 1024 * 1024: "15.0 GiB"} {
+		if actual := disk.FormatBytes(bytes); actual != expected {
+			t.Fatalf("actual: %s expected: %s", actual, expected)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package backup
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"sync"
+)
+
+// Destination is a named Uploader of a FanOutUploader, the name is used in the report.
+type Destination struct {
+	Name     string
+	Uploader Uploader
+}
+
+// DestinationResult reports the outcome of the upload to a single destination.
+type DestinationResult struct {
+	Name            string
+	StorageLocation string
+	Err             error
+}
+
+// FanOutUploader uploads the same file to several destinations, e.g. S3 and a local NAS for 3-2-1 backups.
+// The content is read once and streamed to all destinations concurrently, the slowest destination sets the pace.
+type FanOutUploader struct {
+	destinations []Destination
+	minSuccess   int
+}
+
+// NewFanOutUploader creates an uploader which succeeds if at least minSuccess destinations succeed.
+// A minSuccess <= 0 or above the number of destinations requires all destinations to succeed.
+func NewFanOutUploader(destinations []Destination, minSuccess int) FanOutUploader {
+	if minSuccess <= 0 || minSuccess > len(destinations) {
+		minSuccess = len(destinations)
+	}
+	return FanOutUploader{destinations: destinations, minSuccess: minSuccess}
+}
+
+// Upload uploads the content to all destinations and logs the result of each.
+// The storage locations of the successful destinations are joined by a space.
+func (f FanOutUploader) Upload(content *FileContent) (string, error) {
+	results, err := f.UploadAll(content)
+	var locations []string
+	for _, result := range results {
+		if result.Err != nil {
+			log.Errorf("upload of %s to %s failed, %v", content.Key, result.Name, result.Err)
+			continue
+		}
+		log.Infof("uploaded %s to %s at %s", content.Key, result.Name, result.StorageLocation)
+		locations = append(locations, result.StorageLocation)
+	}
+	return strings.Join(locations, " "), err
+}
+
+// UploadAll uploads the content to all destinations and returns a result per destination in the order of the destinations.
+// An error is returned if fewer than the required destinations succeeded.
+func (f FanOutUploader) UploadAll(content *FileContent) ([]DestinationResult, error) {
+	if len(f.destinations) == 0 {
+		return nil, errors.New("no destinations to upload to")
+	}
+	results := make([]DestinationResult, len(f.destinations))
+	writers := make([]*io.PipeWriter, len(f.destinations))
+	var wg sync.WaitGroup
+	for i, destination := range f.destinations {
+		reader, writer := io.Pipe()
+		writers[i] = writer
+		destinationContent := *content
+		destinationContent.Content = nil
+		destinationContent.Body = reader
+		if content.Body == nil && content.Content != nil {
+			destinationContent.Size = int64(len(*content.Content))
+		}
+		wg.Add(1)
+		go func(i int, destination Destination) {
+			defer wg.Done()
+			location, err := destination.Uploader.Upload(&destinationContent)
+			// unblocks the tee if the uploader stopped reading, e.g. on errors
+			_ = reader.CloseWithError(errDestinationDone)
+			results[i] = DestinationResult{Name: destination.Name, StorageLocation: location, Err: err}
+		}(i, destination)
+	}
+	_, copyErr := io.Copy(&tee{writers: writers}, content.Reader())
+	if copyErr == errAllDestinationsFailed {
+		copyErr = nil
+	}
+	for _, writer := range writers {
+		_ = writer.CloseWithError(copyErr)
+	}
+	wg.Wait()
+	if copyErr != nil {
+		return results, errors.Wrapf(copyErr, "failed to read %s", content.Key)
+	}
+	return results, f.check(results)
+}
+
+func (f FanOutUploader) check(results []DestinationResult) error {
+	var failures []string
+	for _, result := range results {
+		if result.Err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", result.Name, result.Err))
+		}
+	}
+	if succeeded := len(results) - len(failures); succeeded < f.minSuccess {
+		return fmt.Errorf("upload succeeded for %d of %d destinations, at least %d required (%s)", succeeded, len(results), f.minSuccess, strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+var (
+	errDestinationDone       = errors.New("destination stopped reading")
+	errAllDestinationsFailed = errors.New("all destinations stopped reading")
+)
+
+// tee writes to all pipes, pipes whose reader stopped are skipped from then on.
+type tee struct {
+	writers []*io.PipeWriter
+	failed  map[int]bool
+}
+
+func (t *tee) Write(p []byte) (int, error) {
+	if t.failed == nil {
+		t.failed = map[int]bool{}
+	}
+	for i, writer := range t.writers {
+		if t.failed[i] {
+			continue
+		}
+		if _, err := writer.Write(p); err != nil {
+			t.failed[i] = true
+		}
+	}
+	if len(t.failed) == len(t.writers) {
+		return 0, errAllDestinationsFailed
+	}
+	return len(p), nil
+}
@@ -0,0 +1,107 @@
+package backup_test
+
+import (
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+type recordingUploader struct {
+	location string
+	received string
+}
+
+func (u *recordingUploader) Upload(content *backup.FileContent) (string, error) {
+	data, err := ioutil.ReadAll(content.Reader())
+	if err != nil {
+		return "", err
+	}
+	u.received = string(data)
+	return u.location, nil
+}
+
+// failingUploader reads the given number of bytes and fails.
+type failingUploader struct {
+	readBytes int64
+}
+
+func (u failingUploader) Upload(content *backup.FileContent) (string, error) {
+	if _, err := io.CopyN(ioutil.Discard, content.Reader(), u.readBytes); err != nil {
+		return "", err
+	}
+	return "", errors.New("connection reset")
+}
+
+func Test_should_stream_content_to_all_destinations(t *testing.T) {
+	s3 := &recordingUploader{location: "https://bucket.s3.amazonaws.com/dump.tar.gz"}
+	nas := &recordingUploader{location: "file:///mnt/nas/dump.tar.gz"}
+	uploader := backup.NewFanOutUploader([]backup.Destination{{Name: "s3", Uploader: s3}, {Name: "nas", Uploader: nas}}, 0)
+	body := strings.Repeat("0123456789", 100000)
+
+	storageLocation, err := uploader.Upload(&backup.FileContent{Key: "dump.tar.gz", Body: strings.NewReader(body)})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageLocation != "https://bucket.s3.amazonaws.com/dump.tar.gz file:///mnt/nas/dump.tar.gz" {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	if s3.received != body || nas.received != body {
+		t.Fatalf("actual: %d and %d bytes expected: %d bytes", len(s3.received), len(nas.received), len(body))
+	}
+}
+
+func Test_should_fail_if_any_destination_fails_by_default(t *testing.T) {
+	nas := &recordingUploader{location: "file:///mnt/nas/dump.tar.gz"}
+	uploader := backup.NewFanOutUploader([]backup.Destination{{Name: "s3", Uploader: failingUploader{readBytes: 1000}}, {Name: "nas", Uploader: nas}}, 0)
+	body := strings.Repeat("0123456789", 100000)
+
+	results, err := uploader.UploadAll(&backup.FileContent{Key: "dump.tar.gz", Body: strings.NewReader(body)})
+
+	if err == nil {
+		t.Fatal("expected error if a destination fails")
+	}
+	if results[0].Err == nil || results[1].Err != nil {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if nas.received != body {
+		t.Fatalf("failed destination interrupted the others, received %d bytes", len(nas.received))
+	}
+}
+
+func Test_should_succeed_if_at_least_n_destinations_succeed(t *testing.T) {
+	nas := &recordingUploader{location: "file:///mnt/nas/dump.tar.gz"}
+	destinations := []backup.Destination{
+		{Name: "s3", Uploader: failingUploader{}},
+		{Name: "nas", Uploader: nas},
+		{Name: "sftp", Uploader: failingUploader{readBytes: 10}},
+	}
+	uploader := backup.NewFanOutUploader(destinations, 1)
+	content := []byte("archive")
+
+	storageLocation, err := uploader.Upload(&backup.FileContent{Key: "dump.tar.gz", Content: &content})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageLocation != "file:///mnt/nas/dump.tar.gz" {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	if nas.received != "archive" {
+		t.Fatalf("unexpected content %s", nas.received)
+	}
+}
+
+func Test_should_fail_if_all_destinations_fail(t *testing.T) {
+	uploader := backup.NewFanOutUploader([]backup.Destination{{Name: "s3", Uploader: failingUploader{}}, {Name: "nas", Uploader: failingUploader{readBytes: 5}}}, 1)
+	body := strings.Repeat("0123456789", 100000)
+
+	_, err := uploader.Upload(&backup.FileContent{Key: "dump.tar.gz", Body: strings.NewReader(body)})
+
+	if err == nil {
+		t.Fatal("expected error if all destinations fail")
+	}
+}
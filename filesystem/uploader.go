@@ -0,0 +1,199 @@
+package filesystem
+
+import (
+	"fmt"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const tmpSuffix = ".tmp"
+
+// Uploader stores files in a target directory, e.g. an NFS mount.
+// Files are written atomically, a crash never leaves a partially written backup under its final name.
+type Uploader struct {
+	dir         string
+	keyProvider backup.KeyProvider
+	fileMode    os.FileMode
+	uid         int
+	gid         int
+}
+
+// Option configures optional behaviour of the Uploader.
+type Option func(u *Uploader)
+
+// WithPermissions sets the permissions of stored files.
+func WithPermissions(mode os.FileMode) Option {
+	return func(u *Uploader) {
+		u.fileMode = mode
+	}
+}
+
+// WithOwner sets the owner of stored files, requires the privileges to chown.
+func WithOwner(uid int, gid int) Option {
+	return func(u *Uploader) {
+		u.uid = uid
+		u.gid = gid
+	}
+}
+
+// NewUploader creates a new filesystem uploader storing files below dir.
+func NewUploader(dir string, keyProvider backup.KeyProvider, options ...Option) Uploader {
+	u := Uploader{dir: dir, keyProvider: keyProvider, fileMode: 0640, uid: -1, gid: -1}
+	for _, option := range options {
+		option(&u)
+	}
+	return u
+}
+
+// Upload writes the content to a temporary file, syncs it and renames it to the path of its key.
+// The storage location is a file:// URL.
+func (u Uploader) Upload(content *backup.FileContent) (storageLocation string, err error) {
+	key, err := u.keyProvider.CreateKeyFor(content.Key)
+	if err != nil {
+		return "", err
+	}
+	path, err := u.pathOf(key)
+	if err != nil {
+		return "", err
+	}
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", errors.Wrapf(err, "failed to create directory %s", dir)
+	}
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(path)+".*"+tmpSuffix)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create temporary file in %s", dir)
+	}
+	defer func() {
+		if err != nil {
+			if removeErr := os.Remove(tmpFile.Name()); removeErr != nil && !os.IsNotExist(removeErr) {
+				log.Errorf("failed to remove temporary file %s, %v", tmpFile.Name(), removeErr)
+			}
+		}
+	}()
+	if err = u.write(tmpFile, content); err != nil {
+		return "", err
+	}
+	if err = os.Rename(tmpFile.Name(), path); err != nil {
+		return "", errors.Wrapf(err, "failed to rename %s to %s", tmpFile.Name(), path)
+	}
+	if err = syncDir(dir); err != nil {
+		return "", err
+	}
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to determine absolute path of %s", path)
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(absPath)}).String(), nil
+}
+
+func (u Uploader) write(file *os.File, content *backup.FileContent) error {
+	if _, err := io.Copy(file, content.Reader()); err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "failed to write %s", file.Name())
+	}
+	if err := file.Sync(); err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "failed to sync %s", file.Name())
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close %s", file.Name())
+	}
+	if err := os.Chmod(file.Name(), u.fileMode); err != nil {
+		return errors.Wrapf(err, "failed to set permissions of %s", file.Name())
+	}
+	if u.uid >= 0 || u.gid >= 0 {
+		if err := os.Chown(file.Name(), u.uid, u.gid); err != nil {
+			return errors.Wrapf(err, "failed to set owner of %s", file.Name())
+		}
+	}
+	return nil
+}
+
+// syncDir persists the rename, otherwise the new directory entry might get lost on a crash.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open directory %s", dir)
+	}
+	defer func() {
+		if err := d.Close(); err != nil {
+			log.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := d.Sync(); err != nil {
+		return errors.Wrapf(err, "failed to sync directory %s", dir)
+	}
+	return nil
+}
+
+// List returns the stored files whose keys start with prefix. Temporary files of running uploads are skipped.
+func (u Uploader) List(prefix string) ([]backup.StoredObject, error) {
+	var objects []backup.StoredObject
+	err := filepath.Walk(u.dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == u.dir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() || strings.HasSuffix(path, tmpSuffix) {
+			return nil
+		}
+		key, err := filepath.Rel(u.dir, path)
+		if err != nil {
+			return err
+		}
+		key = filepath.ToSlash(key)
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, backup.StoredObject{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list files in %s", u.dir)
+	}
+	return objects, nil
+}
+
+// Delete removes the file with the given key.
+func (u Uploader) Delete(key string) error {
+	path, err := u.pathOf(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return errors.Wrapf(err, "failed to delete %s", path)
+	}
+	return nil
+}
+
+// Open returns the content of the file with the given key.
+func (u Uploader) Open(key string) (io.ReadCloser, error) {
+	path, err := u.pathOf(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", path)
+	}
+	return file, nil
+}
+
+// pathOf maps a key to a path below the target directory, keys must not escape it.
+func (u Uploader) pathOf(key string) (string, error) {
+	path := filepath.Join(u.dir, filepath.FromSlash(key))
+	rel, err := filepath.Rel(u.dir, path)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("key %s is not within directory %s", key, u.dir)
+	}
+	return path, nil
+}
@@ -0,0 +1,142 @@
+package filesystem_test
+
+import (
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/filesystem"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+type plainKeyProvider struct{}
+
+func (plainKeyProvider) CreateKeyFor(symbol string) (string, error) {
+	return "metrics/" + symbol, nil
+}
+
+func (plainKeyProvider) Prefix() string {
+	return "metrics/"
+}
+
+func Test_should_write_file_to_key_path_and_return_file_url(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	uploader := filesystem.NewUploader(dir, plainKeyProvider{}, filesystem.WithPermissions(0600))
+	content := []byte("archive")
+
+	storageLocation, err := uploader.Upload(&backup.FileContent{Key: "dump_20191014120000.tar.gz", Content: &content})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "metrics", "dump_20191014120000.tar.gz")
+	if storageLocation != "file://"+path {
+		t.Fatalf("actual: %s expected: %s", storageLocation, "file://"+path)
+	}
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != "archive" {
+		t.Fatalf("unexpected content %s", string(written))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("actual: %s expected: %s", info.Mode().Perm(), os.FileMode(0600))
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("temporary file was not renamed, found %d files", len(files))
+	}
+}
+
+func Test_should_not_leave_partial_file_when_reading_fails(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	uploader := filesystem.NewUploader(dir, plainKeyProvider{})
+
+	_, err := uploader.Upload(&backup.FileContent{Key: "dump_20191014120000.tar.gz", Body: failingReader{}})
+
+	if err == nil {
+		t.Fatal("error should be propagated")
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 0 {
+		t.Fatalf("expected no files, found %d", len(files))
+	}
+}
+
+func Test_should_list_and_delete_stored_files_with_prefix(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	uploader := filesystem.NewUploader(dir, plainKeyProvider{})
+	content := []byte("archive")
+	for _, name := range []string{"dump_1.tar.gz", "dump_2.tar.gz"} {
+		if _, err := uploader.Upload(&backup.FileContent{Key: name, Content: &content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "other.txt"), content, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	objects, err := uploader.List("metrics/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Key != "metrics/dump_1.tar.gz" || objects[0].Size != int64(len(content)) {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+	if err := uploader.Delete("metrics/dump_1.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	objects, err = uploader.List("metrics/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "metrics/dump_2.tar.gz" {
+		t.Fatalf("unexpected objects after delete %v", objects)
+	}
+}
+
+func Test_should_reject_keys_outside_of_target_directory(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	uploader := filesystem.NewUploader(dir, plainKeyProvider{})
+
+	if err := uploader.Delete("../../etc/passwd"); err == nil || !strings.Contains(err.Error(), "not within directory") {
+		t.Fatalf("expected key to be rejected, got %v", err)
+	}
+}
+
+func createTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "filesystem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func removeDir(t *testing.T, dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Errorf("failed to close io directory, %v", err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read(p []byte) (int, error) {
+	return 0, errors.New("disk on fire")
+}
@@ -0,0 +1,149 @@
+package gcs
+
+import (
+	"cloud.google.com/go/storage"
+	"context"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+	"io"
+)
+
+// DefaultChunkSize is the size of the chunks of resumable uploads, a failed chunk is retried without restarting the upload.
+const DefaultChunkSize = 16 * 1024 * 1024
+
+// NewClient creates a client authenticated with the service account JSON in credentialsFile.
+// An empty credentialsFile uses the application default credentials, e.g. workload identity on GKE.
+// STORAGE_EMULATOR_HOST points the client to a fake server.
+func NewClient(credentialsFile string) (*storage.Client, error) {
+	var options []option.ClientOption
+	if credentialsFile != "" {
+		options = append(options, option.WithCredentialsFile(credentialsFile))
+	}
+	client, err := storage.NewClient(context.Background(), options...)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create gcs client")
+	}
+	return client, nil
+}
+
+// ObjectUploader stores files as objects in a Google Cloud Storage bucket.
+type ObjectUploader struct {
+	bucket       *storage.BucketHandle
+	bucketName   string
+	keyProvider  backup.KeyProvider
+	storageClass string
+	chunkSize    int
+}
+
+// Option configures optional behaviour of the ObjectUploader.
+type Option func(u *ObjectUploader)
+
+// WithStorageClass uploads objects directly into the given storage class, e.g. NEARLINE or COLDLINE.
+func WithStorageClass(storageClass string) Option {
+	return func(u *ObjectUploader) {
+		u.storageClass = storageClass
+	}
+}
+
+// WithChunkSize sets the size of the chunks of resumable uploads, values <= 0 keep the default.
+func WithChunkSize(size int64) Option {
+	return func(u *ObjectUploader) {
+		if size > 0 {
+			u.chunkSize = int(size)
+		}
+	}
+}
+
+// NewObjectUploader creates a new gcs uploader, see NewClient.
+func NewObjectUploader(client *storage.Client, bucketName string, keyProvider backup.KeyProvider, options ...Option) ObjectUploader {
+	u := ObjectUploader{bucket: client.Bucket(bucketName), bucketName: bucketName, keyProvider: keyProvider, chunkSize: DefaultChunkSize}
+	for _, option := range options {
+		option(&u)
+	}
+	return u
+}
+
+// Upload streams the content as resumable upload. GCS has no object tags, tags are stored as metadata.
+// The storage location is a gs:// URL.
+func (u ObjectUploader) Upload(content *backup.FileContent) (string, error) {
+	key, err := u.keyProvider.CreateKeyFor(content.Key)
+	if err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	writer := u.bucket.Object(key).NewWriter(ctx)
+	writer.ChunkSize = u.chunkSize
+	writer.ContentType = content.ContentType
+	writer.StorageClass = u.storageClass
+	writer.Metadata = metadataOf(content)
+	if _, err := io.Copy(writer, content.Reader()); err != nil {
+		// cancelling the context aborts the upload, Close only reports the cancellation
+		cancel()
+		_ = writer.Close()
+		return "", errors.Wrapf(err, "failed to upload object %s", key)
+	}
+	if err := writer.Close(); err != nil {
+		return "", errors.Wrapf(err, "failed to upload object %s", key)
+	}
+	return "gs://" + u.bucketName + "/" + key, nil
+}
+
+// List returns the objects whose keys start with prefix.
+func (u ObjectUploader) List(prefix string) ([]backup.StoredObject, error) {
+	var objects []backup.StoredObject
+	it := u.bucket.Objects(context.Background(), &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to list objects with prefix %s", prefix)
+		}
+		objects = append(objects, backup.StoredObject{Key: attrs.Name, Size: attrs.Size, LastModified: attrs.Updated})
+	}
+}
+
+// Delete removes the object with the given key.
+func (u ObjectUploader) Delete(key string) error {
+	if err := u.bucket.Object(key).Delete(context.Background()); err != nil {
+		return errors.Wrapf(err, "failed to delete object %s", key)
+	}
+	return nil
+}
+
+// Open returns the content of the object with the given key.
+func (u ObjectUploader) Open(key string) (io.ReadCloser, error) {
+	reader, err := u.bucket.Object(key).NewReader(context.Background())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download object %s", key)
+	}
+	return reader, nil
+}
+
+// Stat returns content type and metadata of the object with the given key. Tags were stored as metadata,
+// they are returned as metadata.
+func (u ObjectUploader) Stat(key string) (backup.Attributes, error) {
+	attrs, err := u.bucket.Object(key).Attrs(context.Background())
+	if err != nil {
+		return backup.Attributes{}, errors.Wrapf(err, "failed to read attributes of object %s", key)
+	}
+	return backup.Attributes{ContentType: attrs.ContentType, Metadata: attrs.Metadata}, nil
+}
+
+func metadataOf(content *backup.FileContent) map[string]string {
+	if len(content.Tags) == 0 && len(content.Metadata) == 0 {
+		return nil
+	}
+	metadata := map[string]string{}
+	for key, value := range content.Tags {
+		metadata[key] = value
+	}
+	for key, value := range content.Metadata {
+		metadata[key] = value
+	}
+	return metadata
+}
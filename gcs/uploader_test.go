@@ -0,0 +1,106 @@
+package gcs_test
+
+import (
+	"github.com/fsouza/fake-gcs-server/fakestorage"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/gcs"
+	"strings"
+	"testing"
+)
+
+const bucketName = "influx-backup"
+
+type plainKeyProvider struct{}
+
+func (plainKeyProvider) CreateKeyFor(symbol string) (string, error) {
+	return "metrics/" + symbol, nil
+}
+
+func (plainKeyProvider) Prefix() string {
+	return "metrics/"
+}
+
+func startServer(t *testing.T) *fakestorage.Server {
+	server, err := fakestorage.NewServerWithOptions(fakestorage.Options{NoListener: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.CreateBucket(bucketName)
+	return server
+}
+
+func Test_should_upload_object_in_chunks_with_metadata(t *testing.T) {
+	server := startServer(t)
+	defer server.Stop()
+	uploader := gcs.NewObjectUploader(server.Client(), bucketName, plainKeyProvider{}, gcs.WithChunkSize(256*1024), gcs.WithStorageClass("NEARLINE"))
+	body := strings.Repeat("0123456789", 100*1024)
+	content := &backup.FileContent{
+		Key:         "dump_20191014120000.tar.gz",
+		Body:        strings.NewReader(body),
+		Size:        int64(len(body)),
+		ContentType: "application/gzip",
+		Tags:        map[string]string{"database": "metrics"},
+		Metadata:    map[string]string{"uncompressed-size": "42"},
+	}
+
+	storageLocation, err := uploader.Upload(content)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageLocation != "gs://influx-backup/metrics/dump_20191014120000.tar.gz" {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	object, err := server.GetObject(bucketName, "metrics/dump_20191014120000.tar.gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(object.Content) != body {
+		t.Fatalf("actual: %d bytes expected: %d bytes", len(object.Content), len(body))
+	}
+	if object.ContentType != "application/gzip" {
+		t.Fatalf("actual: %s expected: %s", object.ContentType, "application/gzip")
+	}
+	if object.Metadata["database"] != "metrics" || object.Metadata["uncompressed-size"] != "42" {
+		t.Fatalf("unexpected metadata %v", object.Metadata)
+	}
+}
+
+func Test_should_list_and_delete_objects(t *testing.T) {
+	server := startServer(t)
+	defer server.Stop()
+	uploader := gcs.NewObjectUploader(server.Client(), bucketName, plainKeyProvider{})
+	for _, name := range []string{"dump_20191014120000.tar.gz", "dump_20191015120000.tar.gz"} {
+		content := []byte(name)
+		if _, err := uploader.Upload(&backup.FileContent{Key: name, Content: &content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := uploader.Delete("metrics/dump_20191014120000.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := uploader.List("metrics/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != "metrics/dump_20191015120000.tar.gz" {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+	if objects[0].Size != int64(len("dump_20191015120000.tar.gz")) {
+		t.Fatalf("actual: %d expected: %d", objects[0].Size, len("dump_20191015120000.tar.gz"))
+	}
+}
+
+func Test_should_fail_to_delete_missing_object(t *testing.T) {
+	server := startServer(t)
+	defer server.Stop()
+	uploader := gcs.NewObjectUploader(server.Client(), bucketName, plainKeyProvider{})
+
+	err := uploader.Delete("metrics/missing.tar.gz")
+
+	if err == nil {
+		t.Fatal("expected error for missing object")
+	}
+}
@@ -3,8 +3,15 @@ module github.com/hill-daniel/influx-backup
 go 1.12
 
 require (
+	cloud.google.com/go/storage v1.15.0
+	github.com/Azure/azure-storage-blob-go v0.13.0
 	github.com/aws/aws-sdk-go v1.25.10
-	github.com/pkg/errors v0.8.1
-	github.com/sirupsen/logrus v1.4.2
-	golang.org/x/net v0.0.0-20191009170851-d66e71096ffb // indirect
+	github.com/bmatcuk/doublestar v1.3.4
+	github.com/fsouza/fake-gcs-server v1.19.4
+	github.com/klauspost/compress v1.11.13
+	github.com/pkg/errors v0.9.1
+	github.com/pkg/sftp v1.10.1
+	github.com/sirupsen/logrus v1.6.0
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+	google.golang.org/api v0.45.0
 )
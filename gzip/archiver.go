@@ -7,19 +7,94 @@ import (
 	log "github.com/sirupsen/logrus"
 	"io"
 	"os"
+	"path/filepath"
+	"time"
 )
 
-// Tarer is an abstraction for creating Tar archives.
+const (
+	// ContentTypeGzip is the content type of tar.gz archives.
+	ContentTypeGzip = "application/gzip"
+	// ContentTypeZstd is the content type of tar.zst archives.
+	ContentTypeZstd = "application/zstd"
+)
+
+// Tarer is an abstraction for creating compressed Tar archives.
+// Further capabilities of a Tarer are optional, see Streamer, Format and Summarizer.
 type Tarer interface {
 	TarGz(outFilePath string, inPath string) error
 }
 
-// GzTarer gzips and tars archives.
+// Streamer is implemented by Tarers which write archives to a writer as well.
+type Streamer interface {
+	// Stream writes the archive of inPath to w instead of a file, e.g. to upload it without a local copy.
+	Stream(w io.Writer, inPath string) error
+}
+
+// Format is implemented by Tarers which do not create tar.gz archives.
+type Format interface {
+	// Extension is the file extension of created archives, e.g. .tar.gz.
+	Extension() string
+	// ContentType is the content type of created archives, e.g. application/gzip.
+	ContentType() string
+}
+
+// Summarizer is implemented by Tarers which select the archived files, e.g. by a Filter.
+type Summarizer interface {
+	// Summarize returns what an archive of inPath would contain.
+	Summarize(inPath string) (Summary, error)
+}
+
+// FormatOf returns the format of the archives created by the Tarer, tar.gz if it does not implement Format.
+func FormatOf(t Tarer) Format {
+	if format, ok := t.(Format); ok {
+		return format
+	}
+	return GzTarer{}
+}
+
+// SummaryOf returns what an archive of inPath created by the Tarer would contain,
+// all files if it does not implement Summarizer.
+func SummaryOf(t Tarer, inPath string) (Summary, error) {
+	if summarizer, ok := t.(Summarizer); ok {
+		return summarizer.Summarize(inPath)
+	}
+	return Filter{}.Summarize(inPath)
+}
+
+// GzTarer gzips and tars archives, the Filter selects the archived files.
+// Deterministic archives of identical files are identical, entries are sorted and normalized, see normalize.
+// The gzip header has neither a name nor a modification time.
 type GzTarer struct {
+	Filter
+	Deterministic bool
 }
 
 // TarGz and archives given files in path to a tar.gz file.
-func (GzTarer) TarGz(outFilePath string, inPath string) error {
+func (g GzTarer) TarGz(outFilePath string, inPath string) error {
+	return writeArchive(outFilePath, inPath, g.Filter, g.Deterministic, g.compress)
+}
+
+// Stream writes the tar.gz archive of the files in path to w.
+func (g GzTarer) Stream(w io.Writer, inPath string) error {
+	return writeTar(w, inPath, "", g.Filter, g.Deterministic, g.compress)
+}
+
+func (GzTarer) compress(w io.Writer) (io.WriteCloser, error) {
+	return gzip.NewWriter(w), nil
+}
+
+// Extension returns .tar.gz.
+func (GzTarer) Extension() string {
+	return ".tar.gz"
+}
+
+// ContentType returns application/gzip.
+func (GzTarer) ContentType() string {
+	return ContentTypeGzip
+}
+
+// writeArchive tars the files in inPath selected by filter into a file compressed by the writer created by compress.
+func writeArchive(outFilePath string, inPath string, filter Filter, deterministic bool, compress func(w io.Writer) (io.WriteCloser, error)) error {
 	file, err := os.Create(outFilePath)
 	if err != nil {
 		return errors.Wrapf(err, "failed to create file %s", outFilePath)
@@ -29,64 +104,79 @@ func (GzTarer) TarGz(outFilePath string, inPath string) error {
 			log.Errorf("failed to close io file, %v", err)
 		}
 	}()
-
-	gzipWriter := gzip.NewWriter(file)
-	defer func() {
-		if err = gzipWriter.Close(); err != nil {
-			log.Errorf("failed to close io gzipWriter, %v", err)
-		}
-
-	}()
-
-	tarWriter := tar.NewWriter(gzipWriter)
-	defer func() {
-		if err = tarWriter.Close(); err != nil {
-			log.Errorf("failed to close io tarWriter, %v", err)
-		}
-	}()
-
-	if err := iterateDir(inPath, tarWriter, func(currentPath string) bool {
-		return currentPath == outFilePath
-	}); err != nil {
+	if err := writeTar(file, inPath, filepath.Clean(outFilePath), filter, deterministic, compress); err != nil {
 		return err
 	}
-	log.Infof("tar.gz ok")
+	log.Infof("archive %s ok", outFilePath)
 	return nil
 }
 
-func iterateDir(dirPath string, tw *tar.Writer, ignore func(currentPath string) bool) error {
-	dir, err := os.Open(dirPath)
+// writeTar tars the files in inPath selected by filter to w compressed by the writer created by compress.
+// The file skip is left out, it is the archive itself if it is written into inPath.
+func writeTar(w io.Writer, inPath string, skip string, filter Filter, deterministic bool, compress func(w io.Writer) (io.WriteCloser, error)) error {
+	compressWriter, err := compress(w)
 	if err != nil {
-		return errors.Wrapf(err, "failed to open file %s", dirPath)
+		return errors.Wrapf(err, "failed to create compressor for archive of %s", inPath)
 	}
-	defer func() {
-		if err = dir.Close(); err != nil {
-			log.Errorf("failed to close io directory, %v", err)
+	tarWriter := tar.NewWriter(compressWriter)
+	err = filter.Walk(inPath, func(path string, name string, info os.FileInfo) error {
+		if path == skip {
+			return nil
 		}
-	}()
-
-	files, err := dir.Readdir(0)
+		log.Infof("adding... %s\n", path)
+		return tarGzWrite(path, name, tarWriter, info, deterministic)
+	})
 	if err != nil {
-		return errors.Wrapf(err, "failed to read directory %s", dirPath)
-	}
-
-	for _, file := range files {
-		currentPath := dirPath + "/" + file.Name()
-		if file.IsDir() {
-			if err = iterateDir(currentPath, tw, ignore); err != nil {
-				return err
-			}
-		} else if !ignore(currentPath) {
-			log.Infof("adding... %s\n", currentPath)
-			if err := tarGzWrite(dirPath, currentPath, tw, file); err != nil {
-				return err
-			}
-		}
+		_ = compressWriter.Close()
+		return err
+	}
+	// the archive is incomplete if the last blocks cannot be written
+	if err := tarWriter.Close(); err != nil {
+		_ = compressWriter.Close()
+		return errors.Wrapf(err, "failed to finish archive of %s", inPath)
+	}
+	if err := compressWriter.Close(); err != nil {
+		return errors.Wrapf(err, "failed to finish archive of %s", inPath)
 	}
 	return nil
 }
 
-func tarGzWrite(archiveDir string, path string, tarWriter *tar.Writer, fileInfo os.FileInfo) error {
+// tarGzWrite writes the header of an entry and the content of regular files.
+// The header keeps type, permissions, owner and the modification time in nanoseconds, PAX records are used
+// for long names and sub-second times. Access and change times are left out, reading the files changes them.
+func tarGzWrite(path string, name string, tarWriter *tar.Writer, fileInfo os.FileInfo, deterministic bool) error {
+	if fileInfo.Mode()&os.ModeSocket != 0 {
+		log.Warnf("skipping socket %s", path)
+		return nil
+	}
+	var link string
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return errors.Wrapf(err, "failed to read symlink %s", path)
+		}
+	}
+	header, err := tar.FileInfoHeader(fileInfo, link)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create header of %s", path)
+	}
+	header.Name = name
+	if fileInfo.IsDir() {
+		header.Name += "/"
+	}
+	header.Format = tar.FormatPAX
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	if deterministic {
+		normalize(header)
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "failed to write header of %s", path)
+	}
+	if header.Typeflag != tar.TypeReg {
+		return nil
+	}
+
 	file, err := os.Open(path)
 	if err != nil {
 		return errors.Wrapf(err, "failed to open file %s", path)
@@ -96,19 +186,18 @@ func tarGzWrite(archiveDir string, path string, tarWriter *tar.Writer, fileInfo
 			log.Errorf("failed to close io file, %v", err)
 		}
 	}()
-
-	header := new(tar.Header)
-	header.Name = path[len(archiveDir)+1:]
-	header.Size = fileInfo.Size()
-	header.Mode = int64(fileInfo.Mode())
-	header.ModTime = fileInfo.ModTime()
-	err = tarWriter.WriteHeader(header)
-	if err != nil {
-		return errors.Wrapf(err, "failed to write header")
-	}
-	_, err = io.Copy(tarWriter, file)
-	if err != nil {
-		return errors.Wrapf(err, "failed to copy header")
+	if _, err := io.Copy(tarWriter, file); err != nil {
+		return errors.Wrapf(err, "failed to archive file %s", path)
 	}
 	return nil
 }
+
+// normalize removes the modification time and owner from a header, they differ between snapshots of the same data.
+// Extracted entries belong to root, or the extracting user, and are dated 1970-01-01.
+func normalize(header *tar.Header) {
+	header.ModTime = time.Unix(0, 0)
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = ""
+	header.Gname = ""
+}
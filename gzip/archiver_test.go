@@ -3,6 +3,7 @@ package gzip_test
 import (
 	"archive/tar"
 	"bufio"
+	"bytes"
 	"compress/gzip"
 	"fmt"
 	backup "github.com/hill-daniel/influx-backup/gzip"
@@ -11,8 +12,11 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func Test_should_gzip_and_tar_files_in_directory(t *testing.T) {
@@ -170,3 +174,276 @@ func Untar(dst string, src string) error {
 	}
 	return nil
 }
+
+// completeTarer has the optional capabilities every Tarer of this package implements.
+type completeTarer interface {
+	backup.Tarer
+	backup.Streamer
+	backup.Format
+	backup.Summarizer
+}
+
+func Test_should_restore_archived_tree_exactly(t *testing.T) {
+	archivers := []completeTarer{backup.GzTarer{}, backup.ParallelGzTarer{BlockSize: 1024}, backup.ZstdTarer{}}
+	for _, archiver := range archivers {
+		dir, err := ioutil.TempDir("", "roundtrip")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		sourcePath := filepath.Join(dir, "source")
+		if err := writeTree(sourcePath); err != nil {
+			t.Fatal(err)
+		}
+		archivePath := filepath.Join(dir, "dump"+archiver.Extension())
+		if err := archiver.TarGz(archivePath, sourcePath); err != nil {
+			t.Fatal(err)
+		}
+		archive, err := os.Open(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extractPath := filepath.Join(dir, "extracted")
+		err = backup.Extract(archive, extractPath)
+		_ = archive.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		source, err := describeTree(sourcePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extracted, err := describeTree(extractPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(source) != len(extracted) {
+			t.Fatalf("%T: source has %d entries, extracted %d", archiver, len(source), len(extracted))
+		}
+		for name, entry := range source {
+			if extracted[name] != entry {
+				t.Fatalf("%T: %s differs\nsource:    %+v\nextracted: %+v", archiver, name, entry, extracted[name])
+			}
+		}
+	}
+}
+
+func Test_should_archive_symlinks_as_links(t *testing.T) {
+	dir, err := ioutil.TempDir("", "symlink")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	sourcePath := filepath.Join(dir, "source")
+	if err := writeTree(sourcePath); err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(dir, "dump.tar.gz")
+	if err := (backup.GzTarer{}).TarGz(archivePath, sourcePath); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := readHeaders(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	link, ok := headers["data/current"]
+	if !ok || link.Typeflag != tar.TypeSymlink || link.Linkname != "metrics" || link.Size != 0 {
+		t.Fatalf("unexpected header of symlink %+v", link)
+	}
+	if headers["wal/"] == nil || headers["wal/"].Typeflag != tar.TypeDir {
+		t.Fatal("empty directory should be archived")
+	}
+	if headers["data/metrics/autogen/1/000000001-000000001.tsm"] == nil {
+		t.Fatal("nested file should keep its path")
+	}
+}
+
+// writeTree creates nested and empty directories, a symlink, a long path and files with different permissions.
+func writeTree(root string) error {
+	longDir := filepath.Join(root, "data", strings.Repeat("a_very_long_directory_name_", 5))
+	for _, dir := range []string{filepath.Join(root, "data", "metrics", "autogen", "1"), filepath.Join(root, "wal"), longDir} {
+		if err := os.MkdirAll(dir, 0750); err != nil {
+			return err
+		}
+	}
+	files := map[string]os.FileMode{
+		filepath.Join(root, "data", "metrics", "autogen", "1", "000000001-000000001.tsm"): 0640,
+		filepath.Join(root, "meta.00"):      0600,
+		filepath.Join(root, "restore.sh"):   0755,
+		filepath.Join(longDir, "shard.tsm"): 0644,
+	}
+	for path, mode := range files {
+		if err := ioutil.WriteFile(path, []byte("content of "+filepath.Base(path)), mode); err != nil {
+			return err
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+	if err := os.Symlink("metrics", filepath.Join(root, "data", "current")); err != nil {
+		return err
+	}
+	modTime := time.Date(2019, 10, 14, 12, 0, 0, 123456789, time.UTC)
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			return err
+		}
+		return os.Chtimes(path, modTime, modTime)
+	})
+}
+
+type treeEntry struct {
+	mode    os.FileMode
+	content string
+	link    string
+	modTime time.Time
+	uid     int
+	gid     int
+}
+
+// describeTree returns type, permissions, content, link target, modification time and owner of every entry below root.
+func describeTree(root string) (map[string]treeEntry, error) {
+	entries := map[string]treeEntry{}
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || path == root {
+			return err
+		}
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		entry := treeEntry{mode: info.Mode(), uid: header.Uid, gid: header.Gid}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			entry.link, err = os.Readlink(path)
+		case info.Mode().IsRegular():
+			var content []byte
+			content, err = ioutil.ReadFile(path)
+			entry.content = string(content)
+			entry.modTime = info.ModTime().UTC()
+		default:
+			entry.modTime = info.ModTime().UTC()
+		}
+		entries[name] = entry
+		return err
+	})
+	return entries, err
+}
+
+func readHeaders(archivePath string) (map[string]*tar.Header, error) {
+	archive, err := os.Open(archivePath)
+	if err != nil {
+		return nil, err
+	}
+	defer archive.Close()
+	tarStream, err := backup.Decompress(archive)
+	if err != nil {
+		return nil, err
+	}
+	defer tarStream.Close()
+	headers := map[string]*tar.Header{}
+	tarReader := tar.NewReader(tarStream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return headers, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		headers[header.Name] = header
+	}
+}
+
+func Test_should_create_identical_deterministic_archives_of_identical_files(t *testing.T) {
+	archivers := []completeTarer{backup.GzTarer{Deterministic: true}, backup.ParallelGzTarer{Deterministic: true, BlockSize: 1024}, backup.ZstdTarer{Deterministic: true}}
+	for _, archiver := range archivers {
+		dir, err := ioutil.TempDir("", "deterministic")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		first := filepath.Join(dir, "first")
+		if err := writeTree(first); err != nil {
+			t.Fatal(err)
+		}
+		// the same files, created in another order at another time
+		second := filepath.Join(dir, "second")
+		if err := os.MkdirAll(filepath.Join(second, "wal"), 0750); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeTree(second); err != nil {
+			t.Fatal(err)
+		}
+		now := time.Now()
+		if err := os.Chtimes(filepath.Join(second, "meta.00"), now, now); err != nil {
+			t.Fatal(err)
+		}
+
+		var archives [][]byte
+		for _, path := range []string{first, second} {
+			archivePath := path + archiver.Extension()
+			if err := archiver.TarGz(archivePath, path); err != nil {
+				t.Fatal(err)
+			}
+			archive, err := ioutil.ReadFile(archivePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			archives = append(archives, archive)
+		}
+
+		if !bytes.Equal(archives[0], archives[1]) {
+			t.Fatalf("%T: archives of identical files differ", archiver)
+		}
+		headers, err := readHeaders(first + archiver.Extension())
+		if err != nil {
+			t.Fatal(err)
+		}
+		meta := headers["meta.00"]
+		if !meta.ModTime.Equal(time.Unix(0, 0)) || meta.Uid != 0 || meta.Uname != "" {
+			t.Fatalf("%T: header is not normalized %+v", archiver, meta)
+		}
+		if meta.Mode != 0600 {
+			t.Fatalf("%T: permissions should be kept, actual: %o", archiver, meta.Mode)
+		}
+	}
+}
+
+func Test_should_stream_the_same_archive_as_written_to_a_file(t *testing.T) {
+	archivers := []completeTarer{backup.GzTarer{Deterministic: true}, backup.ParallelGzTarer{Deterministic: true, BlockSize: 1024}, backup.ZstdTarer{Deterministic: true}}
+	for _, archiver := range archivers {
+		dir, err := ioutil.TempDir("", "stream")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		sourcePath := filepath.Join(dir, "source")
+		if err := writeTree(sourcePath); err != nil {
+			t.Fatal(err)
+		}
+		archivePath := filepath.Join(dir, "dump"+archiver.Extension())
+		if err := archiver.TarGz(archivePath, sourcePath); err != nil {
+			t.Fatal(err)
+		}
+		written, err := ioutil.ReadFile(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var streamed bytes.Buffer
+		if err := archiver.Stream(&streamed, sourcePath); err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(streamed.Bytes(), written) {
+			t.Fatalf("%T: streamed archive differs from the archive file", archiver)
+		}
+	}
+}
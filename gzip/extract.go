@@ -0,0 +1,199 @@
+package gzip
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// ContentTypeOf returns the content type of an archive by the extension of its name, e.g. of archives left over by a previous run.
+func ContentTypeOf(name string) string {
+	if strings.HasSuffix(name, ZstdTarer{}.Extension()) {
+		return ContentTypeZstd
+	}
+	return ContentTypeGzip
+}
+
+// Decompress returns the tar stream of a gzip or zstd compressed archive, the compression is detected from the magic bytes.
+func Decompress(archive io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(archive)
+	magic, err := buffered.Peek(len(zstdMagic))
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "failed to read archive header")
+	}
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		reader, err := gzip.NewReader(buffered)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read gzip header")
+		}
+		return reader, nil
+	case bytes.HasPrefix(magic, zstdMagic):
+		decoder, err := zstd.NewReader(buffered)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to create zstd decoder")
+		}
+		return decoder.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unknown archive format, magic bytes %x", magic)
+	}
+}
+
+// Extract unpacks a compressed tar archive into dir, see Decompress. Permissions and modification times are restored,
+// ownership only when running as root. Entries pointing outside of dir, also through a symlink, are rejected.
+func Extract(archive io.Reader, dir string) error {
+	tarStream, err := Decompress(archive)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := tarStream.Close(); err != nil {
+			log.Errorf("failed to close io tarStream, %v", err)
+		}
+	}()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", dir)
+	}
+	tarReader := tar.NewReader(tarStream)
+	// times of directories are restored at the end, extracting their content changes them
+	var directories []*tar.Header
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrap(err, "failed to read archive")
+		}
+		target := filepath.Join(dir, header.Name)
+		if target == filepath.Clean(dir) {
+			continue
+		}
+		if err := CheckTarget(dir, header.Name, target); err != nil {
+			return err
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0700); err != nil {
+				return errors.Wrapf(err, "failed to create directory %s", target)
+			}
+			directories = append(directories, header)
+			continue
+		case tar.TypeReg, tar.TypeRegA:
+			if err := extractFile(target, tarReader); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := extractSymlink(target, header.Linkname); err != nil {
+				return err
+			}
+		default:
+			log.Warnf("skipping archive entry %s of unsupported type %c", header.Name, header.Typeflag)
+			continue
+		}
+		if err := restoreAttributes(target, header); err != nil {
+			return err
+		}
+	}
+	for _, header := range directories {
+		if err := restoreAttributes(filepath.Join(dir, header.Name), header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckTarget rejects entries outside of dir and below symlinks, which may point anywhere.
+// An existing file at target is removed, so it is replaced instead of written through.
+func CheckTarget(dir string, name string, target string) error {
+	if !strings.HasPrefix(target, filepath.Clean(dir)+string(os.PathSeparator)) {
+		return fmt.Errorf("archive entry %s points outside of %s", name, dir)
+	}
+	current := filepath.Clean(dir)
+	for _, part := range strings.Split(filepath.Dir(target[len(current)+1:]), string(os.PathSeparator)) {
+		if part == "." {
+			break
+		}
+		current = filepath.Join(current, part)
+		info, err := os.Lstat(current)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "failed to check %s", current)
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("archive entry %s is below symlink %s", name, current)
+		}
+	}
+	// an existing file or link is replaced, writing to it could follow a symlink
+	if info, err := os.Lstat(target); err == nil && !info.IsDir() {
+		if err := os.Remove(target); err != nil {
+			return errors.Wrapf(err, "failed to replace %s", target)
+		}
+	}
+	return nil
+}
+
+func extractFile(target string, content io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory of %s", target)
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create file %s", target)
+	}
+	if _, err := io.Copy(file, content); err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "failed to extract file %s", target)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "failed to extract file %s", target)
+	}
+	return nil
+}
+
+func extractSymlink(target string, link string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory of %s", target)
+	}
+	if err := os.Symlink(link, target); err != nil {
+		return errors.Wrapf(err, "failed to create symlink %s", target)
+	}
+	return nil
+}
+
+// restoreAttributes sets owner, permissions and modification time. Symlinks only get their owner,
+// changing their permissions or times would change the file they point to.
+func restoreAttributes(target string, header *tar.Header) error {
+	if os.Geteuid() == 0 {
+		if err := os.Lchown(target, header.Uid, header.Gid); err != nil {
+			return errors.Wrapf(err, "failed to change owner of %s", target)
+		}
+	}
+	if header.Typeflag == tar.TypeSymlink {
+		return nil
+	}
+	mode := header.FileInfo().Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+	if err := os.Chmod(target, mode); err != nil {
+		return errors.Wrapf(err, "failed to change permissions of %s", target)
+	}
+	if err := os.Chtimes(target, header.ModTime, header.ModTime); err != nil {
+		return errors.Wrapf(err, "failed to change modification time of %s", target)
+	}
+	return nil
+}
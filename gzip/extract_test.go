@@ -0,0 +1,110 @@
+package gzip_test
+
+import (
+	"archive/tar"
+	"bytes"
+	gz "compress/gzip"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func Test_should_extract_archives_of_all_formats(t *testing.T) {
+	for _, archiver := range []completeTarer{gzip.GzTarer{}, gzip.ZstdTarer{}, gzip.ZstdTarer{Level: 19, Long: true}} {
+		dir, err := ioutil.TempDir("", "archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		snapshotPath := filepath.Join(dir, "snapshot")
+		if err := os.Mkdir(snapshotPath, 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := writeTwoFiles(snapshotPath); err != nil {
+			t.Fatal(err)
+		}
+		archivePath := filepath.Join(dir, "dump"+archiver.Extension())
+		if err := archiver.TarGz(archivePath, snapshotPath); err != nil {
+			t.Fatal(err)
+		}
+		if gzip.ContentTypeOf(archivePath) != archiver.ContentType() {
+			t.Fatalf("actual: %s expected: %s", gzip.ContentTypeOf(archivePath), archiver.ContentType())
+		}
+
+		archive, err := os.Open(archivePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		extractPath := filepath.Join(dir, "extracted")
+		err = gzip.Extract(archive, extractPath)
+		_ = archive.Close()
+		if err != nil {
+			t.Fatalf("failed to extract %s, %v", archivePath, err)
+		}
+
+		extracted, err := ioutil.ReadFile(filepath.Join(extractPath, "dat_1.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(extracted) != "hello\ngo1\n" {
+			t.Fatalf("unexpected content %q of %s", extracted, archivePath)
+		}
+	}
+}
+
+func Test_should_reject_unknown_archive_format(t *testing.T) {
+	err := gzip.Extract(bytes.NewReader([]byte("PK\x03\x04 zip archive")), os.TempDir())
+
+	if err == nil {
+		t.Fatal("expected an error for an unknown format")
+	}
+}
+
+func Test_should_reject_invalid_zstd_level(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	err = gzip.ZstdTarer{Level: 23}.TarGz(filepath.Join(dir, "dump.tar.zst"), dir)
+
+	if err == nil {
+		t.Fatal("expected an error for level 23")
+	}
+}
+
+func Test_should_reject_archive_entries_outside_of_target_dir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	var archive bytes.Buffer
+	gzipWriter := gz.NewWriter(&archive)
+	tarWriter := tar.NewWriter(gzipWriter)
+	content := []byte("evil")
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "../evil.txt", Mode: 0600, Size: int64(len(content)), Typeflag: tar.TypeReg}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tarWriter.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	err = gzip.Extract(&archive, filepath.Join(dir, "extracted"))
+
+	if err == nil {
+		t.Fatal("expected an error for an entry outside of the target dir")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "evil.txt")); !os.IsNotExist(err) {
+		t.Fatal("entry outside of the target dir should not be written")
+	}
+}
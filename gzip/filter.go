@@ -0,0 +1,158 @@
+package gzip
+
+import (
+	"fmt"
+	"github.com/bmatcuk/doublestar"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Filter selects the files of an archive by glob patterns matched against the slash separated path relative
+// to the archived directory. ** matches any number of directories, e.g. **/*.tmp or data/**/wal.
+// Patterns without a slash match the name of an entry in any directory, e.g. *.tmp or wal.
+// Excluded directories are skipped with all their content. Without Include patterns all files are included,
+// otherwise only matching files and the directories leading to them.
+type Filter struct {
+	Include []string
+	Exclude []string
+}
+
+// NewFilter creates a Filter and validates its patterns.
+func NewFilter(include []string, exclude []string) (Filter, error) {
+	for _, pattern := range append(append([]string{}, include...), exclude...) {
+		if _, err := doublestar.Match(pattern, pattern); err != nil {
+			return Filter{}, fmt.Errorf("invalid pattern %s", pattern)
+		}
+	}
+	return Filter{Include: include, Exclude: exclude}, nil
+}
+
+// Summary describes the content of an archive.
+type Summary struct {
+	Files       int
+	Directories int
+	// Size is the uncompressed size of all files.
+	Size int64
+}
+
+// Summarize returns what an archive of inPath would contain, e.g. for a dry run.
+func (f Filter) Summarize(inPath string) (Summary, error) {
+	var summary Summary
+	err := f.Walk(inPath, func(path string, name string, info os.FileInfo) error {
+		if info.IsDir() {
+			summary.Directories++
+		} else {
+			summary.Files++
+			summary.Size += info.Size()
+		}
+		log.Debugf("selected %s", name)
+		return nil
+	})
+	return summary, err
+}
+
+// Walk calls fn for every selected entry below root with its path and its name in the archive.
+// Entries of a directory are sorted by name and preceded by the directory, symlinks are not followed.
+func (f Filter) Walk(root string, fn func(path string, name string, info os.FileInfo) error) error {
+	w := walker{filter: f, root: root, fn: fn}
+	return w.walkDir(root)
+}
+
+type walker struct {
+	filter Filter
+	root   string
+	fn     func(path string, name string, info os.FileInfo) error
+	// pending directories are passed to fn before the first included entry below them
+	pending []pendingDir
+}
+
+type pendingDir struct {
+	path string
+	name string
+	info os.FileInfo
+}
+
+func (w *walker) walkDir(dirPath string) error {
+	dir, err := os.Open(dirPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to open file %s", dirPath)
+	}
+	// Readdir does not follow symlinks, they are archived as links
+	files, err := dir.Readdir(0)
+	if closeErr := dir.Close(); closeErr != nil {
+		log.Errorf("failed to close io directory, %v", closeErr)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read directory %s", dirPath)
+	}
+	// the order of Readdir depends on the file system, sorted entries make archives reproducible
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].Name() < files[j].Name()
+	})
+
+	for _, file := range files {
+		currentPath := filepath.Join(dirPath, file.Name())
+		name, err := filepath.Rel(w.root, currentPath)
+		if err != nil {
+			return errors.Wrapf(err, "failed to determine name of %s", currentPath)
+		}
+		name = filepath.ToSlash(name)
+		if matches(w.filter.Exclude, name) {
+			continue
+		}
+		if file.IsDir() {
+			w.pending = append(w.pending, pendingDir{path: currentPath, name: name, info: file})
+			if len(w.filter.Include) == 0 || matches(w.filter.Include, name) {
+				if err := w.flush(); err != nil {
+					return err
+				}
+			}
+			if err := w.walkDir(currentPath); err != nil {
+				return err
+			}
+			if len(w.pending) > 0 {
+				w.pending = w.pending[:len(w.pending)-1]
+			}
+			continue
+		}
+		if len(w.filter.Include) > 0 && !matches(w.filter.Include, name) {
+			continue
+		}
+		if err := w.flush(); err != nil {
+			return err
+		}
+		if err := w.fn(currentPath, name, file); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// flush passes the pending directories to fn, parents first.
+func (w *walker) flush() error {
+	for _, dir := range w.pending {
+		if err := w.fn(dir.path, dir.name, dir.info); err != nil {
+			return err
+		}
+	}
+	w.pending = w.pending[:0]
+	return nil
+}
+
+func matches(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		target := name
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(name)
+		}
+		if matched, _ := doublestar.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package gzip_test
+
+import (
+	"github.com/hill-daniel/influx-backup/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func Test_should_skip_excluded_files_and_directories(t *testing.T) {
+	root, cleanup := writeSnapshot(t)
+	defer cleanup()
+	filter, err := gzip.NewFilter(nil, []string{"*.tmp", "**/*.lock", "wal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := walkedNames(t, filter, root)
+
+	expected := []string{"data", "data/metrics", "data/metrics/autogen", "data/metrics/autogen/000001.tsm", "meta", "meta/meta.00"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("actual: %v expected: %v", names, expected)
+	}
+}
+
+func Test_should_archive_only_included_files_and_their_directories(t *testing.T) {
+	root, cleanup := writeSnapshot(t)
+	defer cleanup()
+	filter, err := gzip.NewFilter([]string{"data/**/*.tsm"}, []string{"*.tmp"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := walkedNames(t, filter, root)
+
+	expected := []string{"data", "data/metrics", "data/metrics/autogen", "data/metrics/autogen/000001.tsm"}
+	if !reflect.DeepEqual(names, expected) {
+		t.Fatalf("actual: %v expected: %v", names, expected)
+	}
+}
+
+func Test_should_summarize_selected_files(t *testing.T) {
+	root, cleanup := writeSnapshot(t)
+	defer cleanup()
+	filter, err := gzip.NewFilter(nil, []string{"wal", "*.tmp", "*.lock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	summary, err := filter.Summarize(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := gzip.Summary{Files: 2, Directories: 4, Size: int64(len("000001.tsm") + len("meta.00"))}
+	if summary != expected {
+		t.Fatalf("actual: %+v expected: %+v", summary, expected)
+	}
+}
+
+func Test_should_apply_filter_to_archive(t *testing.T) {
+	root, cleanup := writeSnapshot(t)
+	defer cleanup()
+	filter, err := gzip.NewFilter(nil, []string{"wal", "*.tmp", "*.lock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	archivePath := filepath.Join(filepath.Dir(root), "dump.tar.zst")
+
+	if err := (gzip.ZstdTarer{Filter: filter}).TarGz(archivePath, root); err != nil {
+		t.Fatal(err)
+	}
+
+	headers, err := readHeaders(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(headers) != 6 || headers["meta/meta.00"] == nil || headers["wal/"] != nil {
+		t.Fatalf("unexpected archive entries %v", headers)
+	}
+}
+
+func Test_should_reject_invalid_pattern(t *testing.T) {
+	if _, err := gzip.NewFilter(nil, []string{"data/[a-"}); err == nil {
+		t.Fatal("expected an error for an invalid pattern")
+	}
+}
+
+// writeSnapshot creates a snapshot with shard, meta, wal, temporary and lock files, every file contains its name.
+func writeSnapshot(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "filter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := filepath.Join(dir, "snapshot")
+	for _, name := range []string{"data/metrics/autogen/000001.tsm", "data/metrics/autogen/000002.tsm.tmp", "data/metrics/autogen/LOCK.lock",
+		"meta/meta.00", "wal/metrics/autogen/_00001.wal", "snapshot.tmp"} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(filepath.Base(name)), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return root, func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove %s, %v", dir, err)
+		}
+	}
+}
+
+func walkedNames(t *testing.T, filter gzip.Filter, root string) []string {
+	var names []string
+	err := filter.Walk(root, func(path string, name string, info os.FileInfo) error {
+		names = append(names, name)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(names)
+	return names
+}
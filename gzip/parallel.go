@@ -0,0 +1,213 @@
+package gzip
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"github.com/pkg/errors"
+	"hash"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"sync"
+)
+
+const (
+	// DefaultBlockSize is the amount of uncompressed data compressed by one worker at a time.
+	DefaultBlockSize = 1024 * 1024
+	// dictionarySize is the window of deflate, the end of the previous block primes the compressor of the next one.
+	dictionarySize = 32 * 1024
+)
+
+// ParallelGzTarer tars archives and gzips them using multiple cores, see ParallelWriter.
+// Workers <= 0 uses all cores, BlockSize <= 0 uses DefaultBlockSize. The Filter selects the archived files,
+// Deterministic normalizes the entries like for a GzTarer.
+type ParallelGzTarer struct {
+	Filter
+	Deterministic bool
+	Workers       int
+	BlockSize     int
+}
+
+// TarGz archives given files in path to a tar.gz file.
+func (p ParallelGzTarer) TarGz(outFilePath string, inPath string) error {
+	return writeArchive(outFilePath, inPath, p.Filter, p.Deterministic, p.compress)
+}
+
+// Stream writes the tar.gz archive of the files in path to w.
+func (p ParallelGzTarer) Stream(w io.Writer, inPath string) error {
+	return writeTar(w, inPath, "", p.Filter, p.Deterministic, p.compress)
+}
+
+func (p ParallelGzTarer) compress(w io.Writer) (io.WriteCloser, error) {
+	return NewParallelWriter(w, p.Workers, p.BlockSize), nil
+}
+
+// Extension returns .tar.gz.
+func (ParallelGzTarer) Extension() string {
+	return GzTarer{}.Extension()
+}
+
+// ContentType returns application/gzip.
+func (ParallelGzTarer) ContentType() string {
+	return ContentTypeGzip
+}
+
+// ParallelWriter compresses blocks of its input concurrently and writes them in order as a single gzip member,
+// which any gunzip can read. Each block is primed with the last 32 KiB of the previous one, so the compression
+// ratio is close to a sequential gzip. Up to workers blocks are compressed at the same time.
+type ParallelWriter struct {
+	w         io.Writer
+	blockSize int
+	block     []byte
+	previous  []byte
+	digest    hash.Hash32
+	size      uint32
+	queue     chan chan compressed
+	done      chan struct{}
+	err       error
+	mutex     sync.Mutex
+	closed    bool
+}
+
+type compressed struct {
+	data []byte
+	err  error
+}
+
+// NewParallelWriter creates a writer gzipping to w, workers <= 0 uses all cores, blockSize <= 0 uses DefaultBlockSize.
+func NewParallelWriter(w io.Writer, workers int, blockSize int) *ParallelWriter {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	p := &ParallelWriter{
+		w:         w,
+		blockSize: blockSize,
+		block:     make([]byte, 0, blockSize),
+		digest:    crc32.NewIEEE(),
+		queue:     make(chan chan compressed, workers),
+		done:      make(chan struct{}),
+	}
+	go p.writeBlocks()
+	return p
+}
+
+// Write buffers p and hands every full block to a worker.
+func (p *ParallelWriter) Write(data []byte) (int, error) {
+	if err := p.failure(); err != nil {
+		return 0, err
+	}
+	if p.closed {
+		return 0, errors.New("write to closed gzip writer")
+	}
+	written := len(data)
+	p.digest.Write(data)
+	p.size += uint32(len(data))
+	for len(data) > 0 {
+		n := copy(p.block[len(p.block):cap(p.block)], data)
+		p.block = p.block[:len(p.block)+n]
+		data = data[n:]
+		if len(p.block) == cap(p.block) {
+			p.compress(false)
+		}
+	}
+	return written, nil
+}
+
+// Close compresses the remaining data, waits for all workers and writes the gzip trailer.
+// It does not close the underlying writer.
+func (p *ParallelWriter) Close() error {
+	if p.closed {
+		return p.failure()
+	}
+	p.closed = true
+	p.compress(true)
+	close(p.queue)
+	<-p.done
+	if err := p.failure(); err != nil {
+		return err
+	}
+	trailer := make([]byte, 8)
+	binary.LittleEndian.PutUint32(trailer[:4], p.digest.Sum32())
+	binary.LittleEndian.PutUint32(trailer[4:], p.size)
+	if _, err := p.w.Write(trailer); err != nil {
+		return errors.Wrap(err, "failed to write gzip trailer")
+	}
+	return nil
+}
+
+// compress starts a worker for the current block, it blocks while all workers are busy.
+func (p *ParallelWriter) compress(last bool) {
+	block, dictionary := p.block, p.previous
+	result := make(chan compressed, 1)
+	p.queue <- result
+	go func() {
+		result <- deflate(block, dictionary, last)
+	}()
+	if len(block) > dictionarySize {
+		p.previous = block[len(block)-dictionarySize:]
+	} else {
+		p.previous = append(append([]byte{}, p.previous...), block...)
+		if len(p.previous) > dictionarySize {
+			p.previous = p.previous[len(p.previous)-dictionarySize:]
+		}
+	}
+	p.block = make([]byte, 0, p.blockSize)
+}
+
+// writeBlocks writes the header and the compressed blocks in the order they were queued.
+func (p *ParallelWriter) writeBlocks() {
+	defer close(p.done)
+	// no name, no modification time, unknown OS
+	header := []byte{0x1f, 0x8b, 8, 0, 0, 0, 0, 0, 0, 255}
+	_, err := p.w.Write(header)
+	for result := range p.queue {
+		block := <-result
+		if err == nil {
+			err = block.err
+		}
+		if err == nil {
+			_, err = p.w.Write(block.data)
+		}
+		if err != nil {
+			// the remaining blocks are drained, Write reports the failure
+			p.fail(errors.Wrap(err, "failed to write compressed block"))
+		}
+	}
+}
+
+// deflate compresses a block primed with dictionary. All blocks but the last end with a sync flush,
+// which aligns them to a byte boundary, so they can be concatenated to one deflate stream.
+func deflate(block []byte, dictionary []byte, last bool) compressed {
+	var buffer bytes.Buffer
+	writer, err := flate.NewWriterDict(&buffer, flate.DefaultCompression, dictionary)
+	if err != nil {
+		return compressed{err: err}
+	}
+	if _, err := writer.Write(block); err != nil {
+		return compressed{err: err}
+	}
+	if last {
+		err = writer.Close()
+	} else {
+		err = writer.Flush()
+	}
+	return compressed{data: buffer.Bytes(), err: err}
+}
+
+func (p *ParallelWriter) fail(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.err == nil {
+		p.err = err
+	}
+}
+
+func (p *ParallelWriter) failure() error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.err
+}
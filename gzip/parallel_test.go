@@ -0,0 +1,180 @@
+package gzip_test
+
+import (
+	"bytes"
+	gz "compress/gzip"
+	"errors"
+	"fmt"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func Test_should_write_single_gzip_member_readable_by_gzip_reader(t *testing.T) {
+	blockSize := 64 * 1024
+	for _, size := range []int{0, 1, blockSize - 1, blockSize, 3*blockSize + 17} {
+		data := sampleData(size)
+		var archive bytes.Buffer
+		writer := gzip.NewParallelWriter(&archive, 4, blockSize)
+		// uneven writes cross the block boundaries
+		for offset := 0; offset < len(data); offset += 1000 {
+			end := offset + 1000
+			if end > len(data) {
+				end = len(data)
+			}
+			if _, err := writer.Write(data[offset:end]); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := gz.NewReader(&archive)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reader.Multistream(false)
+		decompressed, err := ioutil.ReadAll(reader)
+		if err != nil {
+			t.Fatalf("failed to decompress %d bytes, %v", size, err)
+		}
+		if !bytes.Equal(decompressed, data) {
+			t.Fatalf("decompressed data of %d bytes differs", size)
+		}
+		if archive.Len() != 0 {
+			t.Fatalf("%d bytes after the gzip member", archive.Len())
+		}
+	}
+}
+
+func Test_should_compress_like_sequential_gzip(t *testing.T) {
+	data := sampleData(4 * 1024 * 1024)
+	var sequential, parallel bytes.Buffer
+	gzipWriter := gz.NewWriter(&sequential)
+	if _, err := gzipWriter.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	writer := gzip.NewParallelWriter(&parallel, 0, 256*1024)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// blocks are primed with the previous data, the overhead is small
+	if float64(parallel.Len()) > float64(sequential.Len())*1.01 {
+		t.Fatalf("parallel: %d bytes sequential: %d bytes", parallel.Len(), sequential.Len())
+	}
+}
+
+func Test_should_be_readable_by_gunzip(t *testing.T) {
+	gunzip, err := exec.LookPath("gunzip")
+	if err != nil {
+		t.Skip("gunzip not installed")
+	}
+	dir, err := ioutil.TempDir("", "parallel")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	data := sampleData(1024*1024 + 5)
+	var archive bytes.Buffer
+	writer := gzip.NewParallelWriter(&archive, 3, 100*1024)
+	if _, err := writer.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	command := exec.Command(gunzip, "-c")
+	command.Stdin = &archive
+	output, err := command.Output()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(output, data) {
+		t.Fatal("gunzip output differs")
+	}
+}
+
+func Test_should_report_failed_write_of_compressed_data(t *testing.T) {
+	writer := gzip.NewParallelWriter(failingWriter{}, 2, 1024)
+
+	_, _ = writer.Write(sampleData(10 * 1024))
+	err := writer.Close()
+
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func Benchmark_GzTarer(b *testing.B) {
+	benchmarkTarer(b, gzip.GzTarer{})
+}
+
+func Benchmark_ParallelGzTarer(b *testing.B) {
+	benchmarkTarer(b, gzip.ParallelGzTarer{})
+}
+
+func Benchmark_ParallelGzTarer_2_workers(b *testing.B) {
+	benchmarkTarer(b, gzip.ParallelGzTarer{Workers: 2})
+}
+
+func Benchmark_ParallelGzTarer_256K_blocks(b *testing.B) {
+	benchmarkTarer(b, gzip.ParallelGzTarer{BlockSize: 256 * 1024})
+}
+
+func benchmarkTarer(b *testing.B, archiver gzip.Tarer) {
+	dir, err := ioutil.TempDir("", "benchmark")
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	snapshotPath := filepath.Join(dir, "snapshot")
+	if err := os.Mkdir(snapshotPath, 0700); err != nil {
+		b.Fatal(err)
+	}
+	var size int64
+	for i := 0; i < 4; i++ {
+		data := sampleData(8 * 1024 * 1024)
+		if err := ioutil.WriteFile(filepath.Join(snapshotPath, fmt.Sprintf("%06d.tsm", i)), data, 0600); err != nil {
+			b.Fatal(err)
+		}
+		size += int64(len(data))
+	}
+	b.SetBytes(size)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := archiver.TarGz(filepath.Join(dir, "dump.tar.gz"), snapshotPath); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// sampleData is compressible like time series data, repeated words mixed with random numbers.
+func sampleData(size int) []byte {
+	random := rand.New(rand.NewSource(int64(size)))
+	var buffer bytes.Buffer
+	for buffer.Len() < size {
+		fmt.Fprintf(&buffer, "cpu,host=server%02d usage_idle=%d.%d %d\n", random.Intn(20), random.Intn(100), random.Intn(1000), 1571000000000000000+random.Int63n(1e12))
+	}
+	return buffer.Bytes()[:size]
+}
+
+type failingWriter struct {
+}
+
+func (failingWriter) Write(p []byte) (int, error) {
+	return 0, errors.New("disk full")
+}
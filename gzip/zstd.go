@@ -0,0 +1,74 @@
+package gzip
+
+import (
+	"fmt"
+	"github.com/klauspost/compress/zstd"
+	"io"
+)
+
+const (
+	// DefaultZstdLevel is the default compression level of the zstd command line tool.
+	DefaultZstdLevel = 3
+	// longWindowSize is the window of zstd --long, matches up to 128 MiB apart are found.
+	longWindowSize = 1 << 27
+)
+
+// ZstdTarer tars archives and compresses them with zstd, which is faster and compresses TSM files better than gzip.
+// Level is a zstd level from 1 to 22, it is mapped to the closest level supported by the encoder. 0 uses DefaultZstdLevel.
+// Long enables long distance matching with a window of 128 MiB like zstd --long, decompressing needs as much memory.
+// The Filter selects the archived files, Deterministic normalizes the entries like for a GzTarer.
+type ZstdTarer struct {
+	Filter
+	Deterministic bool
+	Level         int
+	Long          bool
+}
+
+// TarGz archives given files in path to a tar.zst file.
+func (z ZstdTarer) TarGz(outFilePath string, inPath string) error {
+	if _, err := z.options(); err != nil {
+		return err
+	}
+	return writeArchive(outFilePath, inPath, z.Filter, z.Deterministic, z.compress)
+}
+
+// Stream writes the tar.zst archive of the files in path to w.
+func (z ZstdTarer) Stream(w io.Writer, inPath string) error {
+	if _, err := z.options(); err != nil {
+		return err
+	}
+	return writeTar(w, inPath, "", z.Filter, z.Deterministic, z.compress)
+}
+
+func (z ZstdTarer) compress(w io.Writer) (io.WriteCloser, error) {
+	options, err := z.options()
+	if err != nil {
+		return nil, err
+	}
+	return zstd.NewWriter(w, options...)
+}
+
+// Extension returns .tar.zst.
+func (ZstdTarer) Extension() string {
+	return ".tar.zst"
+}
+
+// ContentType returns application/zstd.
+func (ZstdTarer) ContentType() string {
+	return ContentTypeZstd
+}
+
+func (z ZstdTarer) options() ([]zstd.EOption, error) {
+	level := z.Level
+	if level == 0 {
+		level = DefaultZstdLevel
+	}
+	if level < 1 || level > 22 {
+		return nil, fmt.Errorf("invalid zstd level %d, expected 1 to 22", level)
+	}
+	options := []zstd.EOption{zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level))}
+	if z.Long {
+		options = append(options, zstd.WithWindowSize(longWindowSize))
+	}
+	return options, nil
+}
@@ -0,0 +1,263 @@
+package hook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// Point is the step of a backup run a hook is run at.
+type Point string
+
+const (
+	// BeforeSnapshot hooks run before the snapshot is taken, e.g. to pause a Kapacitor task.
+	BeforeSnapshot Point = "before-snapshot"
+	// AfterSnapshot hooks run once the snapshot files are written.
+	AfterSnapshot Point = "after-snapshot"
+	// AfterArchive hooks run once the archive is written, before it is uploaded.
+	AfterArchive Point = "after-archive"
+	// AfterUpload hooks run once the archive is uploaded, before it is removed.
+	AfterUpload Point = "after-upload"
+	// OnFailure hooks run if the run fails, the error is passed to them.
+	OnFailure Point = "on-failure"
+)
+
+// Policy decides how a failing hook affects the run.
+type Policy string
+
+const (
+	// Abort fails the run if the hook fails, this is the default.
+	Abort Policy = "abort"
+	// Continue logs the failure of the hook and continues the run.
+	Continue Policy = "continue"
+)
+
+// DefaultTimeout is the timeout of hooks without a timeout.
+const DefaultTimeout = time.Minute
+
+// Hook is a shell command or an HTTP call run at a Point of a backup run.
+// Commands are run by /bin/sh with the variables of the Event in their environment.
+// HTTP calls send the variables as JSON object with POST or PUT, any other status than 2xx fails the hook.
+type Hook struct {
+	Point   Point    `json:"point"`
+	Command string   `json:"command,omitempty"`
+	URL     string   `json:"url,omitempty"`
+	Method  string   `json:"method,omitempty"`
+	Timeout Duration `json:"timeout,omitempty"`
+	OnError Policy   `json:"onError,omitempty"`
+}
+
+// Duration is a time.Duration given as string in JSON, e.g. 30s.
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration like 30s or 5m.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.Wrap(err, "duration must be a string, e.g. 30s")
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(duration)
+	return nil
+}
+
+// MarshalJSON formats the duration like 30s.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Validate checks that the hook has a known point and policy and either a command or a URL.
+func (h Hook) Validate() error {
+	switch h.Point {
+	case BeforeSnapshot, AfterSnapshot, AfterArchive, AfterUpload, OnFailure:
+	default:
+		return fmt.Errorf("unknown hook point %q", h.Point)
+	}
+	switch h.OnError {
+	case "", Abort, Continue:
+	default:
+		return fmt.Errorf("unknown failure policy %q of %s hook, expected %s or %s", h.OnError, h.Point, Abort, Continue)
+	}
+	if (h.Command == "") == (h.URL == "") {
+		return fmt.Errorf("%s hook needs either a command or a url", h.Point)
+	}
+	return nil
+}
+
+// Load reads hooks from a JSON file containing a list of hooks.
+func Load(path string) ([]Hook, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read hooks %s", path)
+	}
+	var hooks []Hook
+	if err := json.Unmarshal(content, &hooks); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse hooks %s", path)
+	}
+	for _, hook := range hooks {
+		if err := hook.Validate(); err != nil {
+			return nil, errors.Wrapf(err, "invalid hook in %s", path)
+		}
+	}
+	return hooks, nil
+}
+
+// Event describes a backup run, it is passed to hooks as environment variables.
+type Event struct {
+	Database        string
+	BackupPath      string
+	ArchivePath     string
+	StorageLocation string
+	Err             error
+}
+
+// Variables returns the variables passed to the hooks of the given point, empty values are left out.
+func (e Event) Variables(point Point) map[string]string {
+	variables := map[string]string{"INFLUX_BACKUP_HOOK": string(point)}
+	for name, value := range map[string]string{
+		"INFLUX_BACKUP_DATABASE": e.Database,
+		"INFLUX_BACKUP_PATH":     e.BackupPath,
+		"INFLUX_BACKUP_ARCHIVE":  e.ArchivePath,
+		"INFLUX_BACKUP_LOCATION": e.StorageLocation,
+	} {
+		if value != "" {
+			variables[name] = value
+		}
+	}
+	if e.Err != nil {
+		variables["INFLUX_BACKUP_ERROR"] = e.Err.Error()
+	}
+	return variables
+}
+
+// Runner runs the hooks of a point in the order they were given.
+type Runner struct {
+	hooks  []Hook
+	client *http.Client
+}
+
+// NewRunner creates a Runner for the given hooks. A nil Runner runs no hooks.
+func NewRunner(hooks []Hook) *Runner {
+	return &Runner{hooks: hooks, client: &http.Client{}}
+}
+
+// Run runs all hooks of the point. The first failing hook with the Abort policy stops the remaining hooks
+// and its error is returned, failures of hooks with the Continue policy are logged.
+func (r *Runner) Run(point Point, event Event) error {
+	if r == nil {
+		return nil
+	}
+	variables := event.Variables(point)
+	for _, hook := range r.hooks {
+		if hook.Point != point {
+			continue
+		}
+		err := r.run(hook, variables)
+		if err == nil {
+			continue
+		}
+		if hook.OnError == Continue {
+			log.Warnf("%s hook failed, continuing, %v", point, err)
+			continue
+		}
+		return errors.Wrapf(err, "%s hook failed", point)
+	}
+	return nil
+}
+
+func (r *Runner) run(hook Hook, variables map[string]string) error {
+	timeout := time.Duration(hook.Timeout)
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if hook.Command != "" {
+		return runCommand(ctx, hook.Command, variables)
+	}
+	return r.call(ctx, hook, variables)
+}
+
+// runCommand runs the command with the output going to the output of this process.
+// The command runs in its own process group, which is killed with all its processes after the timeout.
+func runCommand(ctx context.Context, command string, variables map[string]string) error {
+	log.Infof("running hook %s", command)
+	cmd := exec.Command("/bin/sh", "-c", command)
+	cmd.Env = os.Environ()
+	for name, value := range variables {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	isolate(cmd)
+	if err := cmd.Start(); err != nil {
+		return errors.Wrapf(err, "failed to start command %s", command)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			return errors.Wrapf(err, "command %s failed", command)
+		}
+		return nil
+	case <-ctx.Done():
+		if err := kill(cmd); err != nil {
+			log.Errorf("failed to kill command %s, %v", command, err)
+		}
+		<-done
+		return errors.Wrapf(ctx.Err(), "command %s timed out", command)
+	}
+}
+
+func (r *Runner) call(ctx context.Context, hook Hook, variables map[string]string) error {
+	method := hook.Method
+	if method == "" {
+		method = http.MethodPost
+	}
+	var body io.Reader
+	if method == http.MethodPost || method == http.MethodPut {
+		encoded, err := json.Marshal(variables)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode hook variables")
+		}
+		body = bytes.NewReader(encoded)
+	}
+	request, err := http.NewRequest(method, hook.URL, body)
+	if err != nil {
+		return errors.Wrapf(err, "invalid hook request %s %s", method, hook.URL)
+	}
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+	log.Infof("calling hook %s %s", method, hook.URL)
+	response, err := r.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return errors.Wrapf(err, "call of %s failed", hook.URL)
+	}
+	defer func() {
+		if err := response.Body.Close(); err != nil {
+			log.Errorf("failed to close io response, %v", err)
+		}
+	}()
+	// the body is read, so the connection can be reused
+	_, _ = io.Copy(ioutil.Discard, response.Body)
+	if response.StatusCode < 200 || response.StatusCode > 299 {
+		return fmt.Errorf("call of %s returned %s", hook.URL, response.Status)
+	}
+	return nil
+}
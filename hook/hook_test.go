@@ -0,0 +1,135 @@
+package hook_test
+
+import (
+	"encoding/json"
+	"github.com/hill-daniel/influx-backup/hook"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_should_pass_event_to_command_in_environment(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out")
+	runner := hook.NewRunner([]hook.Hook{
+		{Point: hook.OnFailure, Command: `echo "$INFLUX_BACKUP_HOOK $INFLUX_BACKUP_DATABASE $INFLUX_BACKUP_ERROR" > ` + out},
+		{Point: hook.AfterUpload, Command: "exit 1"},
+	})
+
+	err = runner.Run(hook.OnFailure, hook.Event{Database: "metrics", Err: errors.New("upload failed")})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != "on-failure metrics upload failed\n" {
+		t.Fatalf("unexpected environment %q", written)
+	}
+}
+
+func Test_should_abort_on_failing_hook_unless_policy_continues(t *testing.T) {
+	aborting := hook.NewRunner([]hook.Hook{{Point: hook.BeforeSnapshot, Command: "exit 3"}})
+	continuing := hook.NewRunner([]hook.Hook{{Point: hook.BeforeSnapshot, Command: "exit 3", OnError: hook.Continue}})
+
+	if err := aborting.Run(hook.BeforeSnapshot, hook.Event{}); err == nil || !strings.HasPrefix(err.Error(), "before-snapshot hook failed") {
+		t.Fatalf("expected the hook to abort, got %v", err)
+	}
+	if err := continuing.Run(hook.BeforeSnapshot, hook.Event{}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_stop_command_after_timeout(t *testing.T) {
+	runner := hook.NewRunner([]hook.Hook{{Point: hook.AfterSnapshot, Command: "sleep 5", Timeout: hook.Duration(100 * time.Millisecond)}})
+	start := time.Now()
+
+	err := runner.Run(hook.AfterSnapshot, hook.Event{})
+
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("hook was not stopped, took %s", elapsed)
+	}
+}
+
+func Test_should_send_event_to_url(t *testing.T) {
+	var received map[string]string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+	runner := hook.NewRunner([]hook.Hook{{Point: hook.AfterUpload, URL: server.URL}})
+
+	err := runner.Run(hook.AfterUpload, hook.Event{Database: "metrics", StorageLocation: "s3://bucket/dump_1.tar.gz"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received["INFLUX_BACKUP_HOOK"] != "after-upload" || received["INFLUX_BACKUP_LOCATION"] != "s3://bucket/dump_1.tar.gz" {
+		t.Fatalf("unexpected variables %v", received)
+	}
+}
+
+func Test_should_fail_on_error_status(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+	runner := hook.NewRunner([]hook.Hook{{Point: hook.AfterUpload, URL: server.URL, Method: http.MethodGet}})
+
+	err := runner.Run(hook.AfterUpload, hook.Event{})
+
+	if err == nil || !strings.Contains(err.Error(), "503") {
+		t.Fatalf("expected an error status, got %v", err)
+	}
+}
+
+func Test_should_load_and_validate_hooks(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hook")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	valid := filepath.Join(dir, "valid.json")
+	content := `[{"point": "before-snapshot", "command": "kapacitor disable cpu_alert", "timeout": "30s", "onError": "continue"},
+		{"point": "after-upload", "url": "https://hc-ping.com/uuid", "method": "GET"}]`
+	if err := ioutil.WriteFile(valid, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	invalid := filepath.Join(dir, "invalid.json")
+	if err := ioutil.WriteFile(invalid, []byte(`[{"point": "after-archive"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	hooks, err := hook.Load(valid)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hooks) != 2 || time.Duration(hooks[0].Timeout) != 30*time.Second || hooks[0].OnError != hook.Continue || hooks[1].Method != "GET" {
+		t.Fatalf("unexpected hooks %+v", hooks)
+	}
+	if _, err := hook.Load(invalid); err == nil {
+		t.Fatal("expected an error for a hook without command and url")
+	}
+}
@@ -0,0 +1,11 @@
+package hook
+
+import "os/exec"
+
+// isolate does nothing, processes have no groups to kill.
+func isolate(cmd *exec.Cmd) {}
+
+// kill kills the shell of the command.
+func kill(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
@@ -3,25 +3,97 @@ package influx
 import (
 	"fmt"
 	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/retry"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"os/exec"
+	"path"
+	"strconv"
 	"strings"
 )
 
-// CreateSnapshot takes a snapshot from given influxdb and stores the files at the given path
-func CreateSnapshot(data backup.Data) error {
-	containerID, err := extractInfluxDbContainerID()
-	if err != nil {
+const (
+	exitCodeNotExecutable = 126
+	exitCodeNotFound      = 127
+	// DefaultDataDir is the directory of the shards in the influxdb docker image.
+	DefaultDataDir = "/var/lib/influxdb/data"
+)
+
+// CreateSnapshot takes a snapshot from given influxdb and stores the files at the given path.
+// Failing docker commands are attempted again according to the given policy.
+func CreateSnapshot(data backup.Data, policy retry.Policy) error {
+	policy = policy.WithClassifier(isRetryableCommandError)
+	var containerID string
+	if err := policy.Do("fetching influxdb container id", func() error {
+		id, err := extractInfluxDbContainerID()
+		containerID = id
+		return err
+	}); err != nil {
 		return err
 	}
 	backupInfluxDb := fmt.Sprintf("docker exec %s influxd backup -portable -database %s %s", containerID, data.Database, data.MountedPath)
-	out, err := exec.Command("/bin/sh", "-c", backupInfluxDb).Output()
+	return policy.Do("influxd backup", func() error {
+		out, err := exec.Command("/bin/sh", "-c", backupInfluxDb).Output()
+		if err != nil {
+			log.Infof("command output: %s", string(out))
+			return errors.Wrapf(err, "failed to execute command: %s", backupInfluxDb)
+		}
+		return nil
+	})
+}
+
+// Version returns the version of influxd running in the docker container, e.g. InfluxDB v1.7.8 (git: 1.7 ff383cdc0420217e3460dabe17db54f8557d95b6).
+func Version(policy retry.Policy) (string, error) {
+	policy = policy.WithClassifier(isRetryableCommandError)
+	var version string
+	err := policy.Do("influxd version", func() error {
+		containerID, err := extractInfluxDbContainerID()
+		if err != nil {
+			return err
+		}
+		versionCmd := fmt.Sprintf("docker exec %s influxd version", containerID)
+		out, err := exec.Command("/bin/sh", "-c", versionCmd).Output()
+		if err != nil {
+			return errors.Wrapf(err, "failed to execute command: %s", versionCmd)
+		}
+		version = strings.TrimSpace(string(out))
+		return nil
+	})
+	return version, err
+}
+
+// DataSize returns the size of the shards of the database in the data directory of the docker container.
+// A snapshot has about this size, it is used to check the free space before taking one.
+func DataSize(database string, dataDir string, policy retry.Policy) (int64, error) {
+	policy = policy.WithClassifier(isRetryableCommandError)
+	var size int64
+	err := policy.Do("measuring influxdb data", func() error {
+		containerID, err := extractInfluxDbContainerID()
+		if err != nil {
+			return err
+		}
+		duCmd := fmt.Sprintf("docker exec %s du -sk %s", containerID, path.Join(dataDir, database))
+		out, err := exec.Command("/bin/sh", "-c", duCmd).Output()
+		if err != nil {
+			return errors.Wrapf(err, "failed to execute command: %s", duCmd)
+		}
+		size, err = parseDiskUsage(string(out))
+		return err
+	})
+	return size, err
+}
+
+// parseDiskUsage parses the output of du -sk, e.g. 1024	/var/lib/influxdb/data/metrics.
+func parseDiskUsage(out string) (int64, error) {
+	fields := strings.Fields(out)
+	if len(fields) == 0 {
+		return 0, retry.Permanent(errors.New("no output of du"))
+	}
+	kilobytes, err := strconv.ParseInt(fields[0], 10, 64)
 	if err != nil {
-		log.Infof("command output: %s", string(out))
-		return errors.Wrapf(err, "failed to execute command: %s", backupInfluxDb)
+		return 0, retry.Permanent(errors.Wrapf(err, "unexpected output of du: %s", out))
 	}
-	return nil
+	return kilobytes * 1024, nil
 }
 
 func extractInfluxDbContainerID() (string, error) {
@@ -31,5 +103,21 @@ func extractInfluxDbContainerID() (string, error) {
 		return "", errors.Wrapf(err, "failed to execute command: %s", grepContainerIDCmd)
 	}
 	containerID := strings.TrimSpace(string(bytes))
+	if containerID == "" {
+		return "", retry.Permanent(errors.New("no running influxdb container found"))
+	}
 	return containerID, nil
 }
+
+// isRetryableCommandError treats a shell which is unable to find or execute a command as fatal.
+// Any other failure, e.g. an unresponsive docker daemon, might be transient.
+func isRetryableCommandError(err error) bool {
+	if !retry.IsRetryable(err) {
+		return false
+	}
+	if exitErr, ok := errors.Cause(err).(*exec.ExitError); ok {
+		code := exitErr.ExitCode()
+		return code != exitCodeNotExecutable && code != exitCodeNotFound
+	}
+	return true
+}
@@ -0,0 +1,137 @@
+package backup
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// keyTimestamp matches the timestamp in the name of a backup, e.g. dump_20191014120000.tar.gz.
+var keyTimestamp = regexp.MustCompile(`dump_(\d{14})`)
+
+// Storage is able to list and delete backup files.
+type Storage interface {
+	Lister
+	Deleter
+}
+
+// Prune deletes all but the newest keep backups below the given prefix and returns the deleted keys.
+// Backups are ordered by the timestamp in their keys, so copied or uploaded again backups keep their place.
+// The prefix has to select the backups of a single database, otherwise backups of other databases are deleted.
+// The parts and index of a split archive and the catalog count as one backup, the catalog and the index are deleted first.
+// Locked backups are skipped, they are removed by a later run once their retention expired.
+func Prune(storage Storage, prefix string, keep int) ([]string, error) {
+	if keep < 0 {
+		return nil, fmt.Errorf("invalid number of backups to keep %d", keep)
+	}
+	objects, err := storage.List(prefix)
+	if err != nil {
+		return nil, err
+	}
+	backups := groupBackups(objects)
+	var deleted []string
+	for i := keep; i < len(backups); i++ {
+		keys, err := deleteBackup(storage, backups[i])
+		deleted = append(deleted, keys...)
+		if err != nil {
+			return deleted, err
+		}
+	}
+	return deleted, nil
+}
+
+func deleteBackup(storage Storage, backup storedBackup) ([]string, error) {
+	var deleted []string
+	for i := len(backup.objects) - 1; i >= 0; i-- {
+		key := backup.objects[i].Key
+		err := storage.Delete(key)
+		if errors.Cause(err) == ErrLocked {
+			log.Infof("skipping locked backup %s", key)
+			continue
+		}
+		if err != nil {
+			return deleted, err
+		}
+		log.Infof("deleted backup %s", key)
+		deleted = append(deleted, key)
+	}
+	return deleted, nil
+}
+
+// storedBackup are the stored files of a backup, the archive or the parts of a split archive followed by its index and catalog.
+// created is the timestamp in the key of the backup, the newest modification time of its files if the key has none.
+type storedBackup struct {
+	objects      []StoredObject
+	lastModified time.Time
+	created      time.Time
+}
+
+// TimestampOf returns the time of a backup from the timestamp in its key, e.g. dump_20191014120000.tar.gz.
+// The timestamp is in local time like the names of the archives.
+func TimestampOf(key string) (time.Time, bool) {
+	matches := keyTimestamp.FindAllStringSubmatch(key, -1)
+	if len(matches) == 0 {
+		return time.Time{}, false
+	}
+	t, err := time.ParseInLocation("20060102150405", matches[len(matches)-1][1], time.Local)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// groupBackups groups the stored files by archive, newest backup first.
+func groupBackups(objects []StoredObject) []storedBackup {
+	var backups []storedBackup
+	positions := map[string]int{}
+	for _, object := range objects {
+		archive := ArchiveOf(object.Key)
+		position, ok := positions[archive]
+		if !ok {
+			position = len(backups)
+			positions[archive] = position
+			backups = append(backups, storedBackup{})
+		}
+		backup := &backups[position]
+		backup.objects = append(backup.objects, object)
+		if object.LastModified.After(backup.lastModified) {
+			backup.lastModified = object.LastModified
+		}
+	}
+	for i := range backups {
+		backup := &backups[i]
+		backup.created = backup.lastModified
+		if created, ok := TimestampOf(ArchiveOf(backup.objects[0].Key)); ok {
+			backup.created = created
+		}
+		objects := backup.objects
+		sort.Slice(objects, func(i, j int) bool {
+			iRank, jRank := rankOf(objects[i].Key), rankOf(objects[j].Key)
+			if iRank != jRank {
+				return iRank < jRank
+			}
+			return objects[i].Key < objects[j].Key
+		})
+	}
+	sort.SliceStable(backups, func(i, j int) bool {
+		return backups[i].created.After(backups[j].created)
+	})
+	return backups
+}
+
+// rankOf orders the files of a backup, the index follows the parts and the catalog follows the index.
+// Files are deleted in reverse order, so a catalog never refers to a partially deleted backup.
+func rankOf(key string) int {
+	switch {
+	case strings.HasSuffix(key, CatalogSuffix):
+		return 2
+	case strings.HasSuffix(key, VolumeIndexSuffix):
+		return 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,113 @@
+package backup_test
+
+import (
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_should_delete_all_but_newest_backups(t *testing.T) {
+	now := time.Now()
+	storage := &testStorage{objects: []backup.StoredObject{
+		{Key: "dump_2", LastModified: now.Add(-2 * time.Hour)},
+		{Key: "dump_0", LastModified: now},
+		{Key: "dump_3", LastModified: now.Add(-3 * time.Hour)},
+		{Key: "dump_1", LastModified: now.Add(-time.Hour)},
+	}}
+
+	deleted, err := backup.Prune(storage, "dump_", 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 2 || deleted[0] != "dump_2" || deleted[1] != "dump_3" {
+		t.Fatalf("unexpected deleted backups %v", deleted)
+	}
+}
+
+func Test_should_skip_locked_backups_when_pruning(t *testing.T) {
+	now := time.Now()
+	storage := &testStorage{
+		objects: []backup.StoredObject{
+			{Key: "dump_0", LastModified: now},
+			{Key: "dump_1", LastModified: now.Add(-time.Hour)},
+			{Key: "dump_2", LastModified: now.Add(-2 * time.Hour)},
+		},
+		locked: map[string]bool{"dump_1": true},
+	}
+
+	deleted, err := backup.Prune(storage, "dump_", 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "dump_2" {
+		t.Fatalf("unexpected deleted backups %v", deleted)
+	}
+}
+
+func Test_should_delete_catalog_with_its_backup_first(t *testing.T) {
+	now := time.Now()
+	storage := &testStorage{objects: []backup.StoredObject{
+		{Key: "dump_0.tar.gz", LastModified: now},
+		{Key: "dump_0.tar.gz.catalog.json", LastModified: now},
+		{Key: "dump_1.tar.gz.catalog.json", LastModified: now.Add(-time.Hour)},
+		{Key: "dump_1.tar.gz.index.json", LastModified: now.Add(-time.Hour)},
+		{Key: "dump_1.tar.gz.part-0001", LastModified: now.Add(-2 * time.Hour)},
+	}}
+
+	deleted, err := backup.Prune(storage, "dump_", 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dump_1.tar.gz.catalog.json", "dump_1.tar.gz.index.json", "dump_1.tar.gz.part-0001"}
+	if strings.Join(deleted, ",") != strings.Join(expected, ",") {
+		t.Fatalf("actual: %v expected: %v", deleted, expected)
+	}
+}
+
+func Test_should_order_backups_by_timestamp_in_key(t *testing.T) {
+	now := time.Now()
+	storage := &testStorage{objects: []backup.StoredObject{
+		{Key: "metrics/dump_20191013120000.tar.gz", LastModified: now},
+		{Key: "metrics/dump_20191014120000.tar.gz", LastModified: now.Add(-time.Hour)},
+		{Key: "metrics/dump_20191012120000.tar.gz", LastModified: now.Add(-2 * time.Hour)},
+	}}
+
+	deleted, err := backup.Prune(storage, "metrics/dump_", 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"metrics/dump_20191013120000.tar.gz", "metrics/dump_20191012120000.tar.gz"}
+	if strings.Join(deleted, ",") != strings.Join(expected, ",") {
+		t.Fatalf("actual: %v expected: %v", deleted, expected)
+	}
+}
+
+func Test_should_reject_negative_number_of_backups_to_keep(t *testing.T) {
+	storage := &testStorage{objects: []backup.StoredObject{{Key: "dump_0", LastModified: time.Now()}}}
+
+	if _, err := backup.Prune(storage, "dump_", -1); err == nil {
+		t.Fatal("expected error for negative keep")
+	}
+}
+
+type testStorage struct {
+	objects []backup.StoredObject
+	locked  map[string]bool
+}
+
+func (s *testStorage) List(prefix string) ([]backup.StoredObject, error) {
+	return s.objects, nil
+}
+
+func (s *testStorage) Delete(key string) error {
+	if s.locked[key] {
+		return errors.Wrapf(backup.ErrLocked, "object %s", key)
+	}
+	return nil
+}
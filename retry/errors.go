@@ -0,0 +1,51 @@
+package retry
+
+// permanentError marks an error as fatal, retrying the operation will not help.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string {
+	return e.err.Error()
+}
+
+// Cause returns the wrapped error, compatible with errors.Cause.
+func (e permanentError) Cause() error {
+	return e.err
+}
+
+// Permanent marks the given error as not retryable.
+func Permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return permanentError{err: err}
+}
+
+// IsPermanent reports whether the error or one of the errors it wraps was marked with Permanent,
+// e.g. a permanent error wrapped by errors.Wrap.
+func IsPermanent(err error) bool {
+	for err != nil {
+		if _, ok := err.(permanentError); ok {
+			return true
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return false
+		}
+		err = cause.Cause()
+	}
+	return false
+}
+
+// IsRetryable is the default classification: every error not marked as Permanent is retryable.
+func IsRetryable(err error) bool {
+	return err != nil && !IsPermanent(err)
+}
+
+func unwrap(err error) error {
+	if p, ok := err.(permanentError); ok {
+		return p.err
+	}
+	return err
+}
@@ -0,0 +1,80 @@
+package retry
+
+import (
+	log "github.com/sirupsen/logrus"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy defines how often an operation is attempted and how long to wait in between.
+// The delay grows exponentially from BaseDelay up to MaxDelay, Jitter randomizes it by the given fraction.
+type Policy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	Jitter      float64
+	// Retryable classifies errors, errors not considered retryable abort immediately.
+	// Defaults to IsRetryable if not set.
+	Retryable func(err error) bool
+}
+
+// NoRetry is a policy which attempts an operation exactly once.
+var NoRetry = Policy{MaxAttempts: 1}
+
+// DefaultPolicy creates a policy with five attempts, starting with one second up to 30 seconds delay.
+func DefaultPolicy() Policy {
+	return Policy{MaxAttempts: 5, BaseDelay: time.Second, MaxDelay: 30 * time.Second, Jitter: 0.2}
+}
+
+// WithClassifier returns a copy of the policy using the given function to classify errors.
+func (p Policy) WithClassifier(retryable func(err error) bool) Policy {
+	p.Retryable = retryable
+	return p
+}
+
+// Do executes fn until it succeeds, the error is fatal or the attempts are exhausted.
+// The last error is returned, a Permanent error is unwrapped.
+func (p Policy) Do(operation string, fn func() error) error {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+	retryable := p.Retryable
+	if retryable == nil {
+		retryable = IsRetryable
+	}
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		if err = fn(); err == nil {
+			return nil
+		}
+		if !retryable(err) {
+			log.Errorf("%s failed with fatal error on attempt %d/%d: %v", operation, attempt, attempts, err)
+			return unwrap(err)
+		}
+		if attempt == attempts {
+			break
+		}
+		delay := p.Delay(attempt)
+		log.Warnf("%s failed on attempt %d/%d, retrying in %s: %v", operation, attempt, attempts, delay, err)
+		time.Sleep(delay)
+	}
+	log.Errorf("%s failed after %d attempts: %v", operation, attempts, err)
+	return unwrap(err)
+}
+
+// Delay calculates the time to wait after the given attempt (starting with 1).
+func (p Policy) Delay(attempt int) time.Duration {
+	if p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := float64(p.BaseDelay) * math.Pow(2, float64(attempt-1))
+	if p.MaxDelay > 0 && delay > float64(p.MaxDelay) {
+		delay = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(delay)
+}
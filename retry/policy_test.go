@@ -0,0 +1,117 @@
+package retry_test
+
+import (
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/pkg/errors"
+	"testing"
+	"time"
+)
+
+func Test_should_retry_until_operation_succeeds(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 3}
+	calls := 0
+
+	err := policy.Do("test", func() error {
+		calls++
+		if calls < 3 {
+			return errors.New("transient")
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error %v", err)
+	}
+	if calls != 3 {
+		t.Fatalf("actual: %d expected: %d", calls, 3)
+	}
+}
+
+func Test_should_return_last_error_when_attempts_are_exhausted(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 2}
+	calls := 0
+
+	err := policy.Do("test", func() error {
+		calls++
+		return errors.New("still failing")
+	})
+
+	if err == nil || err.Error() != "still failing" {
+		t.Fatalf("expected an other error, not %v", err)
+	}
+	if calls != 2 {
+		t.Fatalf("actual: %d expected: %d", calls, 2)
+	}
+}
+
+func Test_should_not_retry_permanent_errors(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 5}
+	calls := 0
+
+	err := policy.Do("test", func() error {
+		calls++
+		return retry.Permanent(errors.New("fatal"))
+	})
+
+	if calls != 1 {
+		t.Fatalf("actual: %d expected: %d", calls, 1)
+	}
+	if retry.IsPermanent(err) || err.Error() != "fatal" {
+		t.Fatalf("expected unwrapped error, not %v", err)
+	}
+}
+
+func Test_should_not_retry_wrapped_permanent_errors(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 5}
+	calls := 0
+
+	err := policy.Do("test", func() error {
+		calls++
+		return errors.Wrap(retry.Permanent(errors.New("fatal")), "failed to read archive")
+	})
+
+	if calls != 1 {
+		t.Fatalf("actual: %d expected: %d", calls, 1)
+	}
+	if errors.Cause(err).Error() != "fatal" {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
+
+func Test_should_use_classifier_of_policy(t *testing.T) {
+	policy := retry.Policy{MaxAttempts: 5}.WithClassifier(func(err error) bool {
+		return err.Error() != "fatal"
+	})
+	calls := 0
+
+	_ = policy.Do("test", func() error {
+		calls++
+		return errors.New("fatal")
+	})
+
+	if calls != 1 {
+		t.Fatalf("actual: %d expected: %d", calls, 1)
+	}
+}
+
+func Test_should_increase_delay_exponentially_up_to_max_delay(t *testing.T) {
+	policy := retry.Policy{BaseDelay: time.Second, MaxDelay: 5 * time.Second}
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second}
+	for i, delay := range expected {
+		if actual := policy.Delay(i + 1); actual != delay {
+			t.Fatalf("attempt %d actual: %s expected: %s", i+1, actual, delay)
+		}
+	}
+}
+
+func Test_should_keep_jittered_delay_within_bounds(t *testing.T) {
+	policy := retry.Policy{BaseDelay: time.Second, MaxDelay: time.Minute, Jitter: 0.5}
+
+	for i := 0; i < 100; i++ {
+		delay := policy.Delay(2)
+		if delay < time.Second || delay > 3*time.Second {
+			t.Fatalf("delay %s out of bounds", delay)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package backup
+
+import (
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/pkg/errors"
+	"io"
+	"os"
+)
+
+// ReadWriteStorage stores, lists, reads and deletes backup files.
+type ReadWriteStorage interface {
+	Uploader
+	Storage
+	Opener
+}
+
+// RetryingStorage attempts the calls of a storage without retries of its own again, e.g. a filesystem on an NFS mount
+// or an sftp server. Uploads are only attempted again if the content can be read again, a consumed stream can not.
+type RetryingStorage struct {
+	storage ReadWriteStorage
+	policy  retry.Policy
+}
+
+// NewRetryingStorage wraps the storage in the given policy. Locked objects and missing or inaccessible files
+// are not attempted again, see IsRetryable.
+func NewRetryingStorage(storage ReadWriteStorage, policy retry.Policy) *RetryingStorage {
+	return &RetryingStorage{storage: storage, policy: policy.WithClassifier(IsRetryable)}
+}
+
+// IsRetryable classifies errors of storages, locked objects and missing or inaccessible files fail immediately.
+func IsRetryable(err error) bool {
+	cause := errors.Cause(err)
+	return retry.IsRetryable(err) && cause != ErrLocked && !os.IsNotExist(cause) && !os.IsPermission(cause)
+}
+
+// Upload uploads the content, content given in memory or as seekable body is uploaded again after a failure.
+func (s *RetryingStorage) Upload(content *FileContent) (string, error) {
+	policy := s.policy
+	if content.Body != nil {
+		if _, ok := content.Body.(io.Seeker); !ok {
+			// a consumed stream can not be uploaded again
+			policy = retry.NoRetry
+		}
+	}
+	var storageLocation string
+	err := policy.Do("upload of "+content.Key, func() error {
+		if seeker, ok := content.Body.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return retry.Permanent(err)
+			}
+		}
+		var err error
+		storageLocation, err = s.storage.Upload(content)
+		return err
+	})
+	return storageLocation, err
+}
+
+// List lists the stored files below prefix.
+func (s *RetryingStorage) List(prefix string) ([]StoredObject, error) {
+	var objects []StoredObject
+	err := s.policy.Do("listing objects with prefix "+prefix, func() error {
+		var err error
+		objects, err = s.storage.List(prefix)
+		return err
+	})
+	return objects, err
+}
+
+// Delete removes the stored file.
+func (s *RetryingStorage) Delete(key string) error {
+	return s.policy.Do("deleting "+key, func() error {
+		return s.storage.Delete(key)
+	})
+}
+
+// Open opens the stored file, reading it is not attempted again.
+func (s *RetryingStorage) Open(key string) (io.ReadCloser, error) {
+	var reader io.ReadCloser
+	err := s.policy.Do("download of "+key, func() error {
+		var err error
+		reader, err = s.storage.Open(key)
+		return err
+	})
+	return reader, err
+}
+
+// Stat returns the attributes of the stored file, storages which keep none return empty attributes.
+func (s *RetryingStorage) Stat(key string) (Attributes, error) {
+	stater, ok := s.storage.(Stater)
+	if !ok {
+		return Attributes{}, nil
+	}
+	var attributes Attributes
+	err := s.policy.Do("reading attributes of "+key, func() error {
+		var err error
+		attributes, err = stater.Stat(key)
+		return err
+	})
+	return attributes, err
+}
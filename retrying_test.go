@@ -0,0 +1,116 @@
+package backup_test
+
+import (
+	"bytes"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/pkg/errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+var attemptTwice = retry.Policy{MaxAttempts: 2}
+
+// flakyStorage fails the first call of every operation with failure, the following calls use the memory storage.
+type flakyStorage struct {
+	memoryStorage
+	failure error
+	calls   map[string]int
+}
+
+func newFlakyStorage(failure error) *flakyStorage {
+	return &flakyStorage{memoryStorage: memoryStorage{files: map[string][]byte{}}, failure: failure, calls: map[string]int{}}
+}
+
+func (s *flakyStorage) fail(operation string) error {
+	s.calls[operation]++
+	if s.calls[operation] == 1 {
+		return s.failure
+	}
+	return nil
+}
+
+func (s *flakyStorage) Upload(content *backup.FileContent) (string, error) {
+	if err := s.fail("upload"); err != nil {
+		// a failed upload consumes the content like a broken connection
+		_, _ = io.Copy(ioutil.Discard, content.Reader())
+		return "", err
+	}
+	return s.memoryStorage.Upload(content)
+}
+
+func (s *flakyStorage) List(prefix string) ([]backup.StoredObject, error) {
+	if err := s.fail("list"); err != nil {
+		return nil, err
+	}
+	var objects []backup.StoredObject
+	for key, data := range s.files {
+		objects = append(objects, backup.StoredObject{Key: key, Size: int64(len(data))})
+	}
+	return objects, nil
+}
+
+func (s *flakyStorage) Delete(key string) error {
+	if err := s.fail("delete"); err != nil {
+		return err
+	}
+	delete(s.files, key)
+	return nil
+}
+
+func (s *flakyStorage) Open(key string) (io.ReadCloser, error) {
+	if err := s.fail("open"); err != nil {
+		return nil, err
+	}
+	return s.memoryStorage.Open(key)
+}
+
+func Test_should_attempt_calls_of_storage_again(t *testing.T) {
+	flaky := newFlakyStorage(errors.New("connection reset"))
+	storage := backup.NewRetryingStorage(flaky, attemptTwice)
+
+	if _, err := storage.Upload(&backup.FileContent{Key: "dump_1", Body: bytes.NewReader([]byte("archive"))}); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := storage.List("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := backup.Digest(storage, "dump_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := storage.Delete("dump_1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(objects) != 1 || objects[0].Size != 7 || len(digest) == 0 || len(flaky.files) != 0 {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+}
+
+func Test_should_not_upload_consumed_stream_again(t *testing.T) {
+	flaky := newFlakyStorage(errors.New("connection reset"))
+	storage := backup.NewRetryingStorage(flaky, attemptTwice)
+
+	_, err := storage.Upload(&backup.FileContent{Key: "dump_1", Body: io.MultiReader(bytes.NewReader([]byte("archive")))})
+
+	if err == nil || flaky.calls["upload"] != 1 {
+		t.Fatalf("expected a single failed upload, got %d uploads, %v", flaky.calls["upload"], err)
+	}
+}
+
+func Test_should_not_attempt_deletion_of_locked_or_missing_files_again(t *testing.T) {
+	for _, failure := range []error{errors.Wrap(backup.ErrLocked, "dump_1"), &os.PathError{Op: "remove", Path: "dump_1", Err: os.ErrNotExist}} {
+		flaky := newFlakyStorage(failure)
+		storage := backup.NewRetryingStorage(flaky, attemptTwice)
+
+		err := storage.Delete("dump_1")
+
+		if errors.Cause(err) != errors.Cause(failure) || flaky.calls["delete"] != 1 {
+			t.Fatalf("expected a single failed deletion, got %d deletions, %v", flaky.calls["delete"], err)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package s3
+
+import (
+	"github.com/hill-daniel/influx-backup/disk"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/hill-daniel/influx-backup/hook"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+)
+
+// archiveStrategy writes the archive into the run directory and uploads it, split into volumes if configured.
+// An archive whose upload fails is kept and uploaded by the next run.
+type archiveStrategy struct{}
+
+func (a archiveStrategy) store(d BucketBackup, taken snapshot) (string, string, error) {
+	workDir, err := d.createWorkDir(taken.dir, taken.timestamp)
+	if err != nil {
+		return "", "", err
+	}
+	// TSM files are compressed already, the archive may be as large as the files
+	if err := disk.Require(workDir, taken.size); err != nil {
+		d.removeWorkDir(taken.dir, workDir)
+		return "", "", errors.Wrapf(err, "failed to archive files, however backup was created")
+	}
+	archivePath, err := a.archive(d, taken.dir, workDir, taken.timestamp)
+	if err != nil {
+		d.removeWorkDir(taken.dir, workDir)
+		return "", "", err
+	}
+	name := filepath.Base(archivePath)
+	event := d.event
+	event.ArchivePath = archivePath
+	if err := d.hooks.Run(hook.AfterArchive, event); err != nil {
+		// the archive must not be uploaded as leftover by the next run
+		if err := os.Remove(archivePath); err != nil {
+			log.Errorf("failed to remove archive %s, %v", archivePath, err)
+		}
+		d.removeWorkDir(taken.dir, workDir)
+		return "", "", err
+	}
+	storageLocation, err := d.uploadToS3(name, archivePath, gzip.FormatOf(d.archiver).ContentType(), taken.metadata)
+	if err != nil {
+		return "", "", err
+	}
+	event.StorageLocation = storageLocation
+	hookErr := d.hooks.Run(hook.AfterUpload, event)
+	if err := a.cleanup(d, taken.dir, workDir, archivePath, taken.timestamp); err != nil {
+		log.Error(err)
+	}
+	d.rotate()
+	return name, storageLocation, hookErr
+}
+
+// archive writes the archive under a temporary name first, so only complete archives are uploaded as leftovers.
+func (archiveStrategy) archive(d BucketBackup, inPath string, workDir string, timestamp string) (string, error) {
+	archivePath := filepath.Join(workDir, ArchivePrefix+timestamp+gzip.FormatOf(d.archiver).Extension())
+	if err := d.archiver.TarGz(archivePath+partialSuffix, inPath); err != nil {
+		if err := os.Remove(archivePath + partialSuffix); err != nil && !os.IsNotExist(err) {
+			log.Errorf("failed to remove partial archive, %v", err)
+		}
+		return "", errors.Wrapf(err, "failed to archive files, however backup was created")
+	}
+	if err := os.Rename(archivePath+partialSuffix, archivePath); err != nil {
+		return "", errors.Wrapf(err, "failed to archive files, however backup was created")
+	}
+	return archivePath, nil
+}
+
+// cleanup removes the uploaded archive, the run directory and the snapshot files, unless they are kept locally.
+func (archiveStrategy) cleanup(d BucketBackup, backupDirPath string, workDir string, archivePath string, timestamp string) error {
+	if d.retention.Archives.enabled() {
+		if err := d.retention.keepArchive(archivePath); err != nil {
+			return err
+		}
+	} else if err := os.Remove(archivePath); err != nil {
+		return errors.Wrapf(err, "failed to remove uploaded archive %s", archivePath)
+	}
+	d.removeWorkDir(backupDirPath, workDir)
+	return d.removeSnapshot(backupDirPath, timestamp)
+}
@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"net/url"
+	"time"
+)
+
+const (
+	maxTags           = 10
+	maxTagKeyLength   = 128
+	maxTagValueLength = 256
+)
+
+// objectAttributes are applied when creating an object, regardless of a single or multipart upload.
+type objectAttributes struct {
+	contentType  string
+	storageClass string
+	tagging      string
+	metadata     map[string]*string
+	lockMode     *string
+	retainUntil  *time.Time
+	legalHold    *string
+}
+
+func (a objectAttributes) storageClassValue() *string {
+	if a.storageClass == "" {
+		return nil
+	}
+	return aws.String(a.storageClass)
+}
+
+func (a objectAttributes) taggingValue() *string {
+	if a.tagging == "" {
+		return nil
+	}
+	return aws.String(a.tagging)
+}
+
+// encodeTags creates the URL query encoded tag set expected by S3, validating the limits of S3.
+func encodeTags(tags map[string]string) (string, error) {
+	if len(tags) > maxTags {
+		return "", fmt.Errorf("s3 allows at most %d tags per object, got %d", maxTags, len(tags))
+	}
+	values := url.Values{}
+	for key, value := range tags {
+		if key == "" || len(key) > maxTagKeyLength {
+			return "", fmt.Errorf("tag key %q must have 1 to %d characters", key, maxTagKeyLength)
+		}
+		if len(value) > maxTagValueLength {
+			return "", fmt.Errorf("value of tag %s exceeds %d characters", key, maxTagValueLength)
+		}
+		values.Set(key, value)
+	}
+	return values.Encode(), nil
+}
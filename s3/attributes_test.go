@@ -0,0 +1,82 @@
+package s3_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func Test_should_upload_with_storage_class_tags_and_metadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attributes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	archivePath := filepath.Join(dir, "dump_20191014120000.tar.gz")
+	if err := ioutil.WriteFile(archivePath, make([]byte, s3manager.MinUploadPartSize+1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeMultipartClient{}
+	uploader := &s3manager.Uploader{S3: client, PartSize: s3manager.MinUploadPartSize, Concurrency: 1}
+	binaryUploader := s3.NewBinaryUploader(uploader, s3.HexKeyProvider{}, "bucket",
+		s3.WithResumableUploads(filepath.Join(dir, "state")),
+		s3.WithStorageClass("STANDARD_IA"))
+	file, err := os.Open(archivePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	_, err = binaryUploader.Upload(&backup.FileContent{
+		Key:      "dump_20191014120000.tar.gz",
+		Body:     file,
+		Size:     s3manager.MinUploadPartSize + 1,
+		Tags:     map[string]string{"database": "metrics", "host": "edge 1"},
+		Metadata: map[string]string{"influxdb-version": "v1.7.8"},
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	input := client.createInput
+	if aws.StringValue(input.StorageClass) != "STANDARD_IA" {
+		t.Fatalf("unexpected storage class %s", aws.StringValue(input.StorageClass))
+	}
+	if aws.StringValue(input.Tagging) != "database=metrics&host=edge+1" {
+		t.Fatalf("unexpected tagging %s", aws.StringValue(input.Tagging))
+	}
+	if aws.StringValue(input.Metadata["influxdb-version"]) != "v1.7.8" {
+		t.Fatalf("unexpected metadata %v", input.Metadata)
+	}
+	digest := sha256.Sum256(make([]byte, s3manager.MinUploadPartSize+1))
+	if aws.StringValue(input.Metadata[s3.MetadataSHA256]) != hex.EncodeToString(digest[:]) {
+		t.Fatalf("digest of the archive was not recorded, metadata %v", aws.StringValueMap(input.Metadata))
+	}
+}
+
+func Test_should_reject_more_tags_than_s3_allows(t *testing.T) {
+	binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: &fakeMultipartClient{}}, s3.HexKeyProvider{}, "bucket")
+	tags := make(map[string]string)
+	for i := 0; i < 11; i++ {
+		tags["tag"+strconv.Itoa(i)] = "value"
+	}
+	content := []byte("content")
+
+	_, err := binaryUploader.Upload(&backup.FileContent{Key: "dump", Content: &content, Tags: tags})
+
+	if err == nil {
+		t.Fatal("expected error for too many tags")
+	}
+}
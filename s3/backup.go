@@ -1,74 +1,387 @@
 package s3
 
 import (
-	"bufio"
+	"fmt"
 	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/catalog"
+	"github.com/hill-daniel/influx-backup/chunk"
 	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/hill-daniel/influx-backup/hook"
 	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
-	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
 
-const unixTimestampFormat = "20060102150405"
+const (
+	unixTimestampFormat = "20060102150405"
+	// ArchivePrefix is the name prefix of all created archives.
+	ArchivePrefix = "dump_"
+	// archiveExtensions matches the extensions of all archive formats, see gzip.Tarer.
+	archiveExtensions = ".tar.*"
+	// MetadataUncompressedSize is the metadata key of the size of the archived files.
+	MetadataUncompressedSize = "uncompressed-size"
+	// MetadataSHA256 is the metadata key of the hex encoded SHA-256 digest of an object, recorded at upload.
+	MetadataSHA256 = "sha256"
+	// RunDirPrefix is the name prefix of the directories created for every run in the staging directory.
+	RunDirPrefix = "influx-backup-"
+	// partialSuffix marks archives which are still being written, they are never uploaded as leftovers.
+	partialSuffix = ".partial"
+)
 
-// BucketBackup will gzip the snapshot files and upload them to S3.
-// The created archive and snapshot files are removed after success.
+// BucketBackup will archive the snapshot files with the given gzip.Tarer and upload them to S3.
+// The created archive and snapshot files are removed after success. How the snapshot is stored is up to
+// a strategy, see archiveStrategy, streamStrategy and chunkStrategy.
 type BucketBackup struct {
-	uploader backup.Uploader
-	archiver gzip.Tarer
+	uploader    backup.Uploader
+	archiver    gzip.Tarer
+	tags        map[string]string
+	metadata    map[string]string
+	volumeSize  int64
+	stagingDir  string
+	database    string
+	preexisting map[string]bool
+	streaming   bool
+	retention   LocalRetention
+	hooks       *hook.Runner
+	event       hook.Event
+	chunks      *chunkStrategy
+	// catalogFilter enables the catalog, see WithCatalog.
+	catalogFilter *gzip.Filter
+}
+
+// BackupOption configures optional behaviour of the BucketBackup.
+type BackupOption func(d *BucketBackup)
+
+// WithTags adds the given tags to every uploaded archive.
+func WithTags(tags map[string]string) BackupOption {
+	return func(d *BucketBackup) {
+		d.tags = tags
+	}
+}
+
+// WithMetadata adds the given metadata to every uploaded archive.
+func WithMetadata(metadata map[string]string) BackupOption {
+	return func(d *BucketBackup) {
+		d.metadata = metadata
+	}
+}
+
+// WithVolumeSize splits archives into parts of at most size bytes, see backup.UploadVolumes. 0 uploads archives as a whole.
+func WithVolumeSize(size int64) BackupOption {
+	return func(d *BucketBackup) {
+		d.volumeSize = size
+	}
+}
+
+// WithStagingDir writes archives into a unique directory per run below dir instead of into the snapshot directory.
+// The directory should be on a file system with enough space for an archive, the space is checked before archiving.
+func WithStagingDir(dir string) BackupOption {
+	return func(d *BucketBackup) {
+		d.stagingDir = dir
+	}
+}
+
+// WithDatabase names the database of the snapshot. Run directories of other databases sharing the staging directory
+// are left to the runs of their database.
+func WithDatabase(database string) BackupOption {
+	return func(d *BucketBackup) {
+		d.database = database
+	}
+}
+
+// WithPreexistingEntries names the entries of the snapshot directory which existed before the snapshot was taken.
+// They are kept after a successful upload and only the entries created by the snapshot are removed.
+// Without this option the whole snapshot directory is removed.
+func WithPreexistingEntries(names []string) BackupOption {
+	return func(d *BucketBackup) {
+		d.preexisting = make(map[string]bool)
+		for _, name := range names {
+			d.preexisting[name] = true
+		}
+	}
+}
+
+// WithStreaming uploads archives while they are written, no archive is stored locally. A failed upload
+// is not resumed by the next run, it takes a new snapshot instead. Streamed archives cannot be split into volumes.
+func WithStreaming(enabled bool) BackupOption {
+	return func(d *BucketBackup) {
+		d.streaming = enabled
+	}
+}
+
+// WithHooks runs the after-archive and after-upload hooks, event describes the run.
+// A failing after-archive hook removes the archive instead of uploading it.
+func WithHooks(hooks *hook.Runner, event hook.Event) BackupOption {
+	return func(d *BucketBackup) {
+		d.hooks = hooks
+		d.event = event
+	}
+}
+
+// WithChunkRepository stores the files selected by filter deduplicated in the repository instead of archiving them.
+// Only chunks which are not stored yet are uploaded, the storage location is the one of the index of the backup.
+func WithChunkRepository(repository *chunk.Repository, filter gzip.Filter) BackupOption {
+	return func(d *BucketBackup) {
+		d.chunks = &chunkStrategy{repository: repository, filter: filter}
+	}
+}
+
+// WithCatalog checks the manifests of the portable snapshot before archiving, every file they refer to has to exist
+// and be selected by filter. A catalog of the shards is uploaded next to the backup, see catalog.Catalog.
+func WithCatalog(filter gzip.Filter) BackupOption {
+	return func(d *BucketBackup) {
+		d.catalogFilter = &filter
+	}
 }
 
 // NewBucketBackup creates a new BucketBackup
-func NewBucketBackup(uploader backup.Uploader, archiver gzip.Tarer) *BucketBackup {
-	return &BucketBackup{uploader: uploader, archiver: archiver}
+func NewBucketBackup(uploader backup.Uploader, archiver gzip.Tarer, options ...BackupOption) *BucketBackup {
+	d := &BucketBackup{uploader: uploader, archiver: archiver}
+	for _, option := range options {
+		option(d)
+	}
+	return d
+}
+
+// Validate rejects options which do not work together. Streamed archives and chunks are not written locally,
+// they can neither be split into volumes nor kept as local copies. Streaming needs an archiver implementing gzip.Streamer.
+func (d BucketBackup) Validate() error {
+	if d.chunks == nil && !d.streaming {
+		return nil
+	}
+	if _, ok := d.archiver.(gzip.Streamer); d.chunks == nil && !ok {
+		return fmt.Errorf("archiver %T cannot stream archives", d.archiver)
+	}
+	format := "streamed archives"
+	if d.chunks != nil {
+		format = "backups stored as chunks"
+	}
+	if d.volumeSize > 0 {
+		return fmt.Errorf("%s cannot be split into volumes, volumes need a local archive", format)
+	}
+	if d.retention.Archives.enabled() {
+		return fmt.Errorf("%s write no local archive which could be kept", format)
+	}
+	return nil
 }
 
 // BackUp tars, gzips given dir and uploads it to an s3 bucket.
+// Archives left over by a previously failed run are uploaded first, resuming their upload if possible.
+// The error of a failing after-upload hook is returned along with the storage location of the uploaded archive.
 func (d BucketBackup) BackUp(backupDirPath string) (string, error) {
-	archivePath, err := d.archive(backupDirPath)
-	if err != nil {
+	backupDirPath = strings.TrimRight(backupDirPath, "/")
+	if err := d.Validate(); err != nil {
 		return "", err
 	}
-	key := archivePath[len(backupDirPath)+1:]
-	storageLocation, err := d.uploadToS3(key, archivePath)
-	if err != nil {
+	if err := d.retention.Validate(backupDirPath); err != nil {
 		return "", err
 	}
-	if err := cleanup(backupDirPath); err != nil {
-		log.Error(err)
+	if err := d.uploadLeftovers(backupDirPath); err != nil {
+		return "", err
+	}
+	summary, err := gzip.SummaryOf(d.archiver, backupDirPath)
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to determine size of %s", backupDirPath)
+	}
+	now := time.Now()
+	taken := snapshot{
+		dir:       backupDirPath,
+		timestamp: now.Format(unixTimestampFormat),
+		size:      summary.Size,
+		metadata:  map[string]string{MetadataUncompressedSize: strconv.FormatInt(summary.Size, 10)},
 	}
-	return storageLocation, nil
+	var entry *catalog.Catalog
+	if d.catalogFilter != nil {
+		built, err := catalog.Build(backupDirPath, *d.catalogFilter)
+		if err != nil {
+			return "", errors.Wrapf(err, "invalid snapshot in %s, not archiving it", backupDirPath)
+		}
+		built.Created = now.UTC()
+		entry = &built
+	}
+	name, storageLocation, err := d.strategy().store(d, taken)
+	return d.storeCatalog(entry, name, storageLocation, err)
 }
 
-func (d BucketBackup) archive(inPath string) (string, error) {
-	t := time.Now()
-	timestamp := t.Format(unixTimestampFormat)
-	archivePath := inPath + "/dump_" + timestamp + ".tar.gz"
-	if err := d.archiver.TarGz(archivePath, strings.TrimRight(inPath, "/")); err != nil {
-		return "", errors.Wrapf(err, "failed to archive files, however backup was created")
+// strategy selects how the snapshot is stored, chunks take precedence over streaming.
+func (d BucketBackup) strategy() strategy {
+	switch {
+	case d.chunks != nil:
+		return *d.chunks
+	case d.streaming:
+		return streamStrategy{}
+	default:
+		return archiveStrategy{}
+	}
+}
+
+// storeCatalog uploads the catalog of an uploaded backup. A failed upload fails the run, the backup is kept though.
+// Without catalog or uploaded backup the given storage location and error are returned unchanged.
+func (d BucketBackup) storeCatalog(entry *catalog.Catalog, name string, storageLocation string, err error) (string, error) {
+	if entry == nil || storageLocation == "" {
+		return storageLocation, err
+	}
+	entry.Name, entry.Location = name, storageLocation
+	if _, catalogErr := catalog.Upload(d.uploader, *entry, d.tags, d.metadata); catalogErr != nil {
+		catalogErr = errors.Wrapf(catalogErr, "failed to upload catalog, however backup was uploaded to %s", storageLocation)
+		if err != nil {
+			log.Error(catalogErr)
+			return storageLocation, err
+		}
+		return storageLocation, catalogErr
 	}
-	return archivePath, nil
+	return storageLocation, err
 }
 
-func (d BucketBackup) uploadToS3(key string, archivePath string) (string, error) {
+// uploadLeftovers uploads and removes archives of previous runs which failed during upload.
+// They are uploaded before archiving, otherwise they would end up in the new archive.
+// Run directories in the staging directory are only taken over from finished runs of the same database,
+// those without a complete archive are removed.
+func (d BucketBackup) uploadLeftovers(backupDirPath string) error {
+	pattern := ArchivePrefix + "*" + archiveExtensions
+	leftovers, err := filepath.Glob(filepath.Join(backupDirPath, pattern))
+	if err != nil {
+		return errors.Wrapf(err, "failed to look up leftover archives in %s", backupDirPath)
+	}
+	var abandonedDirs []string
+	if d.stagingDir != "" {
+		runDirs, err := filepath.Glob(filepath.Join(d.stagingDir, RunDirPrefix+"*"))
+		if err != nil {
+			return errors.Wrapf(err, "failed to look up leftover archives in %s", d.stagingDir)
+		}
+		for _, runDir := range runDirs {
+			abandoned, err := d.abandoned(runDir)
+			if err != nil {
+				return err
+			}
+			if !abandoned {
+				continue
+			}
+			staged, err := filepath.Glob(filepath.Join(runDir, pattern))
+			if err != nil {
+				return errors.Wrapf(err, "failed to look up leftover archives in %s", runDir)
+			}
+			leftovers = append(leftovers, staged...)
+			abandonedDirs = append(abandonedDirs, runDir)
+		}
+	}
+	for _, leftover := range leftovers {
+		if strings.HasSuffix(leftover, partialSuffix) {
+			if err := os.Remove(leftover); err != nil {
+				return errors.Wrapf(err, "failed to remove partial archive %s", leftover)
+			}
+			continue
+		}
+		log.Infof("uploading archive %s left over by a previous run", leftover)
+		storageLocation, err := d.uploadToS3(filepath.Base(leftover), leftover, gzip.ContentTypeOf(leftover), nil)
+		if err != nil {
+			return err
+		}
+		log.Infof("uploaded leftover archive to %s", storageLocation)
+		if err := os.Remove(leftover); err != nil {
+			return errors.Wrapf(err, "failed to remove uploaded archive %s", leftover)
+		}
+	}
+	for _, runDir := range abandonedDirs {
+		// only partially written archives remain, they cannot be uploaded
+		if err := os.RemoveAll(runDir); err != nil {
+			return errors.Wrapf(err, "failed to remove run directory %s", runDir)
+		}
+	}
+	return nil
+}
+
+func (d BucketBackup) uploadToS3(key string, archivePath string, contentType string, metadata map[string]string) (string, error) {
 	archiveFile, err := os.Open(archivePath)
 	if err != nil {
 		return "", errors.Wrapf(err, "failed to open file %s", archivePath)
 	}
-	archiveReader := bufio.NewReader(archiveFile)
-	content, err := ioutil.ReadAll(archiveReader)
+	defer func() {
+		if err := archiveFile.Close(); err != nil {
+			log.Errorf("failed to close io file, %v", err)
+		}
+	}()
+	fileInfo, err := archiveFile.Stat()
 	if err != nil {
-		return "", errors.Wrapf(err, "failed to open read archive %s", archivePath)
+		return "", errors.Wrapf(err, "failed to read archive %s", archivePath)
+	}
+	bucketContent := &backup.FileContent{
+		Key:         key,
+		ContentType: contentType,
+		Body:        archiveFile,
+		Size:        fileInfo.Size(),
+		Tags:        d.tags,
+		Metadata:    merge(d.metadata, metadata),
+	}
+	if d.volumeSize > 0 {
+		return backup.UploadVolumes(d.uploader, archiveFile, fileInfo.Size(), bucketContent, d.volumeSize)
 	}
-	bucketContent := &backup.FileContent{Key: key, ContentType: Gzip, Content: &content}
 	return d.uploader.Upload(bucketContent)
 }
 
-func cleanup(path string) error {
-	if path == "/" {
+func merge(maps ...map[string]string) map[string]string {
+	merged := make(map[string]string)
+	for _, m := range maps {
+		for key, value := range m {
+			merged[key] = value
+		}
+	}
+	return merged
+}
+
+// removeSnapshot removes the snapshot files or moves them into the directory of local copies.
+// Entries of the snapshot directory which existed before the snapshot are kept, see WithPreexistingEntries.
+func (d BucketBackup) removeSnapshot(backupDirPath string, timestamp string) error {
+	if d.preexisting == nil && !d.retention.Snapshots.enabled() {
+		return removeDir(backupDirPath)
+	}
+	dir, err := os.Open(backupDirPath)
+	if err != nil {
+		return errors.Wrapf(err, "failed to cleanup files, however backup was created and uploaded")
+	}
+	names, err := dir.Readdirnames(0)
+	if closeErr := dir.Close(); closeErr != nil {
+		log.Errorf("failed to close io directory, %v", closeErr)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to cleanup files, however backup was created and uploaded")
+	}
+	var created []string
+	for _, name := range names {
+		if !d.preexisting[name] {
+			created = append(created, name)
+		}
+	}
+	if d.retention.Snapshots.enabled() {
+		if err := d.retention.keepSnapshot(backupDirPath, created, timestamp); err != nil {
+			return err
+		}
+		if d.preexisting == nil {
+			return removeDir(backupDirPath)
+		}
+		return nil
+	}
+	for _, name := range created {
+		if err := os.RemoveAll(filepath.Join(backupDirPath, name)); err != nil {
+			return errors.Wrapf(err, "failed to cleanup files, however backup was created and uploaded")
+		}
+	}
+	return nil
+}
+
+// rotate removes local copies which are not kept anymore, a failure does not fail the uploaded backup.
+func (d BucketBackup) rotate() {
+	if _, err := d.retention.rotate(time.Now()); err != nil {
+		log.Error(err)
+	}
+}
+
+func removeDir(path string) error {
+	if path == "/" || path == "" {
 		return errors.New("root path provided, not going to cleanup")
 	}
 	if err := os.RemoveAll(path); err != nil {
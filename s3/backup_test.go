@@ -1,17 +1,28 @@
 package s3_test
 
 import (
+	"archive/tar"
 	"bufio"
 	"bytes"
+	gz "compress/gzip"
+	"encoding/json"
 	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/catalog"
+	"github.com/hill-daniel/influx-backup/chunk"
+	"github.com/hill-daniel/influx-backup/filesystem"
 	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/hill-daniel/influx-backup/hook"
 	"github.com/hill-daniel/influx-backup/s3"
 	"github.com/pkg/errors"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 )
 
 func Test_should_create_influx_dump_and_upload_gzipped_file_to_s3_cleaning_up_afterwards(t *testing.T) {
@@ -109,6 +120,568 @@ func Test_should_not_cleanup_when_archiving_fails(t *testing.T) {
 	}
 }
 
+func Test_should_upload_archives_left_over_by_failed_run_first(t *testing.T) {
+	testUploader := &testUploader{}
+	archiver := &gzip.GzTarer{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	leftover := "dump_20191014120000.tar.gz"
+	if err := ioutil.WriteFile(backupPath+"/"+leftover, []byte("previous archive"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, archiver)
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(testUploader.keys) != 2 {
+		t.Fatalf("actual: %d expected: %d uploads", len(testUploader.keys), 2)
+	}
+	if testUploader.keys[0] != leftover {
+		t.Fatalf("actual: %s expected: %s", testUploader.keys[0], leftover)
+	}
+	entries, err := archiveEntries(*testUploader.result.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry == leftover {
+			t.Fatal("leftover archive should not be part of the new archive")
+		}
+	}
+}
+
+func Test_should_add_tags_and_metadata_with_uncompressed_size(t *testing.T) {
+	testUploader := &testUploader{}
+	archiver := &gzip.GzTarer{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, archiver,
+		s3.WithTags(map[string]string{"database": "metrics"}),
+		s3.WithMetadata(map[string]string{"influxdb-version": "v1.7.8"}))
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	result := testUploader.result
+	if result.Tags["database"] != "metrics" {
+		t.Fatalf("unexpected tags %v", result.Tags)
+	}
+	if result.Metadata["influxdb-version"] != "v1.7.8" {
+		t.Fatalf("unexpected metadata %v", result.Metadata)
+	}
+	// two files with 10 bytes each
+	if result.Metadata[s3.MetadataUncompressedSize] != "20" {
+		t.Fatalf("actual: %s expected: %s", result.Metadata[s3.MetadataUncompressedSize], "20")
+	}
+}
+
+func Test_should_upload_zstd_archive_with_its_content_type(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	leftover := "dump_20191014120000.tar.zst"
+	if err := ioutil.WriteFile(backupPath+"/"+leftover, []byte("previous archive"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, gzip.ZstdTarer{Level: 19, Long: true})
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(testUploader.contentTypes) != 2 || testUploader.contentTypes[0] != gzip.ContentTypeZstd || testUploader.contentTypes[1] != gzip.ContentTypeZstd {
+		t.Fatalf("unexpected content types %v", testUploader.contentTypes)
+	}
+	if !strings.HasSuffix(testUploader.result.Key, ".tar.zst") {
+		t.Fatalf("unexpected key %s", testUploader.result.Key)
+	}
+	extractPath := "/tmp/influx_extracted"
+	defer func() {
+		if err := os.RemoveAll(extractPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := gzip.Extract(bytes.NewReader(*testUploader.result.Content), extractPath); err != nil {
+		t.Fatal(err)
+	}
+	extracted, err := ioutil.ReadFile(extractPath + "/dat_1.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(extracted) != "hello\ngo1\n" {
+		t.Fatalf("unexpected content %q", extracted)
+	}
+}
+
+func Test_should_upload_archive_in_volumes(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithVolumeSize(64))
+
+	storageLocation, err := bb.BackUp(backupPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(storageLocation, backup.VolumeIndexSuffix) {
+		t.Fatalf("storage location should be the index, actual: %s", storageLocation)
+	}
+	if len(testUploader.keys) < 3 || !strings.HasSuffix(testUploader.keys[0], ".tar.gz.part-0001") {
+		t.Fatalf("unexpected uploads %v", testUploader.keys)
+	}
+	if testUploader.contentTypes[0] != backup.VolumeContentType || testUploader.contentTypes[len(testUploader.keys)-1] != "application/json" {
+		t.Fatalf("unexpected content types %v", testUploader.contentTypes)
+	}
+}
+
+func Test_should_archive_into_staging_dir_and_remove_only_created_files(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	stagingDir := "/tmp/influx_staging"
+	defer func() {
+		for _, dir := range []string{backupPath, stagingDir} {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Errorf("failed to close io directory, %v", err)
+			}
+		}
+	}()
+	if err := os.MkdirAll(backupPath, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(backupPath+"/README", []byte("kept by the operator"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithStagingDir(stagingDir), s3.WithPreexistingEntries([]string{"README"}))
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(testUploader.result.Key, s3.ArchivePrefix) || strings.Contains(testUploader.result.Key, "/") {
+		t.Fatalf("unexpected key %s", testUploader.result.Key)
+	}
+	entries, err := archiveEntries(*testUploader.result.Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry, s3.ArchivePrefix) {
+			t.Fatalf("archive should not contain itself, found %s", entry)
+		}
+	}
+	remaining, err := ioutil.ReadDir(backupPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 1 || remaining[0].Name() != "README" {
+		t.Fatalf("only the file of the operator should remain, found %v", remaining)
+	}
+	runDirs, err := ioutil.ReadDir(stagingDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runDirs) != 0 {
+		t.Fatalf("run directory should have been removed, found %v", runDirs)
+	}
+}
+
+func Test_should_upload_leftovers_of_staging_dir_and_drop_partial_archives(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	stagingDir := "/tmp/influx_staging"
+	defer func() {
+		for _, dir := range []string{backupPath, stagingDir} {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Errorf("failed to close io directory, %v", err)
+			}
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	leftover := "dump_20191014120000.tar.gz"
+	deadRun := runFile(t, finishedProcess(t), "metrics")
+	if err := createFiles(stagingDir, map[string]string{
+		s3.RunDirPrefix + "20191014120000-1/" + leftover:                        "previous archive",
+		s3.RunDirPrefix + "20191014120000-1/" + s3.RunFile:                      deadRun,
+		s3.RunDirPrefix + "20191014130000-2/dump_20191014130000.tar.gz.partial": "incomplete",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	// a crashed run which did not write its run file yet
+	old := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(stagingDir+"/"+s3.RunDirPrefix+"20191014130000-2", old, old); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithStagingDir(stagingDir), s3.WithDatabase("metrics"))
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(testUploader.keys) != 2 || testUploader.keys[0] != leftover {
+		t.Fatalf("unexpected uploads %v", testUploader.keys)
+	}
+	runDirs, err := ioutil.ReadDir(stagingDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runDirs) != 0 {
+		t.Fatalf("run directories should have been removed, found %v", runDirs)
+	}
+}
+
+func Test_should_stream_archive_without_local_copy(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithStreaming(true))
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkGzFormat(*testUploader.result.Content); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(testUploader.result.Key, s3.ArchivePrefix) || testUploader.result.Metadata[s3.MetadataUncompressedSize] != "20" {
+		t.Fatalf("unexpected upload %s with metadata %v", testUploader.result.Key, testUploader.result.Metadata)
+	}
+	if _, err := os.Stat(backupPath); !os.IsNotExist(err) {
+		t.Fatal("cleanup failed")
+	}
+}
+
+func Test_should_return_archive_error_when_streaming_fails(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, &failingArchiver{}, s3.WithStreaming(true))
+
+	_, err := bb.BackUp(backupPath)
+
+	if err == nil || !strings.HasPrefix(err.Error(), "failed to archive files, however backup was created") {
+		t.Fatalf("expected an archive error, got %v", err)
+	}
+	if len(testUploader.keys) != 0 {
+		t.Fatalf("incomplete archive should not have been uploaded, uploaded %v", testUploader.keys)
+	}
+	if _, err := os.Stat(backupPath); err != nil {
+		t.Fatal("cleanup should not have succeeded")
+	}
+}
+
+func Test_should_reject_volumes_and_local_archives_without_local_archive(t *testing.T) {
+	keepArchive := s3.LocalRetention{Dir: "/tmp/influx_local", Archives: s3.Keep{Last: 1}}
+	for _, bb := range []*s3.BucketBackup{
+		s3.NewBucketBackup(&testUploader{}, gzip.GzTarer{}, s3.WithStreaming(true), s3.WithVolumeSize(64)),
+		s3.NewBucketBackup(&testUploader{}, gzip.GzTarer{}, s3.WithStreaming(true), s3.WithLocalRetention(keepArchive)),
+		s3.NewBucketBackup(&testUploader{}, gzip.GzTarer{}, s3.WithChunkRepository(nil, gzip.Filter{}), s3.WithVolumeSize(64)),
+		s3.NewBucketBackup(&testUploader{}, gzip.GzTarer{}, s3.WithChunkRepository(nil, gzip.Filter{}), s3.WithLocalRetention(keepArchive)),
+	} {
+		if err := bb.Validate(); err == nil {
+			t.Fatal("expected error for options needing a local archive")
+		}
+	}
+	if err := s3.NewBucketBackup(&testUploader{}, gzip.GzTarer{}, s3.WithVolumeSize(64), s3.WithLocalRetention(keepArchive)).Validate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_back_up_with_archiver_implementing_only_tar_gz(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := s3.NewBucketBackup(testUploader, tarOnlyArchiver{}, s3.WithStreaming(true)).Validate(); err == nil {
+		t.Fatal("expected error for streaming with an archiver which cannot stream")
+	}
+
+	_, err := s3.NewBucketBackup(testUploader, tarOnlyArchiver{}).BackUp(backupPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(testUploader.result.Key, ".tar.gz") || testUploader.result.ContentType != gzip.ContentTypeGzip {
+		t.Fatalf("expected tar.gz archive, got %s with content type %s", testUploader.result.Key, testUploader.result.ContentType)
+	}
+}
+
+func Test_should_not_upload_archive_rejected_by_hook(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	hooks := hook.NewRunner([]hook.Hook{{Point: hook.AfterArchive, Command: `gunzip -t "$INFLUX_BACKUP_ARCHIVE" && exit 1`}})
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithHooks(hooks, hook.Event{Database: "metrics"}))
+
+	_, err := bb.BackUp(backupPath)
+
+	if err == nil || !strings.HasPrefix(err.Error(), "after-archive hook failed") {
+		t.Fatalf("expected the hook to fail, got %v", err)
+	}
+	if len(testUploader.keys) != 0 {
+		t.Fatalf("archive should not have been uploaded, uploaded %v", testUploader.keys)
+	}
+	leftovers, err := filepath.Glob(backupPath + "/" + s3.ArchivePrefix + "*")
+	if err != nil || len(leftovers) != 0 {
+		t.Fatalf("rejected archive should have been removed, found %v", leftovers)
+	}
+}
+
+func Test_should_store_snapshot_in_chunk_repository(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	repositoryPath := "/tmp/influx_chunks"
+	defer func() {
+		for _, dir := range []string{backupPath, repositoryPath} {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Errorf("failed to close io directory, %v", err)
+			}
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	store := filesystem.NewUploader(repositoryPath, backup.IdentityKeyProvider{})
+	repository := chunk.NewRepository(&store, "metrics/")
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithChunkRepository(repository, gzip.Filter{}))
+
+	storageLocation, err := bb.BackUp(backupPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(storageLocation, chunk.IndexSuffix) || len(testUploader.keys) != 0 {
+		t.Fatalf("expected the index of the repository, got %s and uploads %v", storageLocation, testUploader.keys)
+	}
+	index, err := repository.ReadIndex("metrics/indexes/" + filepath.Base(storageLocation))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Entries) != 2 || index.Metadata[s3.MetadataUncompressedSize] != "20" {
+		t.Fatalf("unexpected index %+v", index)
+	}
+	if _, err := os.Stat(backupPath); !os.IsNotExist(err) {
+		t.Fatal("cleanup failed")
+	}
+}
+
+func Test_should_upload_catalog_next_to_archive(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createPortableBackup(backupPath, true); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithCatalog(gzip.Filter{}))
+
+	storageLocation, err := bb.BackUp(backupPath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(testUploader.keys) != 2 || testUploader.keys[1] != testUploader.keys[0]+backup.CatalogSuffix {
+		t.Fatalf("expected archive and catalog, got %v", testUploader.keys)
+	}
+	var uploaded catalog.Catalog
+	if err := json.Unmarshal(*testUploader.result.Content, &uploaded); err != nil {
+		t.Fatal(err)
+	}
+	if uploaded.Location != storageLocation || uploaded.Name != testUploader.keys[0] || len(uploaded.Find("metrics", "autogen", 412)) != 1 {
+		t.Fatalf("unexpected catalog %+v", uploaded)
+	}
+}
+
+func Test_should_not_archive_snapshot_with_missing_files(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	defer func() {
+		if err := os.RemoveAll(backupPath); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	if err := createPortableBackup(backupPath, false); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithCatalog(gzip.Filter{}))
+
+	_, err := bb.BackUp(backupPath)
+
+	if err == nil || !strings.Contains(err.Error(), "20191014T120000Z.s412.tar.gz") {
+		t.Fatalf("expected error naming the missing shard, got %v", err)
+	}
+	if len(testUploader.keys) != 0 {
+		t.Fatalf("expected no uploads, got %v", testUploader.keys)
+	}
+	if _, err := os.Stat(filepath.Join(backupPath, "20191014T120000Z.manifest")); err != nil {
+		t.Fatal("snapshot was removed")
+	}
+}
+
+func Test_should_keep_run_directories_of_running_backups_and_other_databases(t *testing.T) {
+	testUploader := &testUploader{}
+	backupPath := "/tmp/influx_snapshot"
+	stagingDir := "/tmp/influx_staging"
+	defer func() {
+		for _, dir := range []string{backupPath, stagingDir} {
+			if err := os.RemoveAll(dir); err != nil {
+				t.Errorf("failed to close io directory, %v", err)
+			}
+		}
+	}()
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	running := s3.RunDirPrefix + "20191014120000-1"
+	otherDatabase := s3.RunDirPrefix + "20191014130000-2"
+	starting := s3.RunDirPrefix + "20191014140000-3"
+	if err := createFiles(stagingDir, map[string]string{
+		running + "/dump_20191014120000.tar.gz.partial": "being written",
+		running + "/" + s3.RunFile:                      runFile(t, os.Getppid(), "metrics"),
+		otherDatabase + "/dump_20191014130000.tar.gz":   "being uploaded",
+		otherDatabase + "/" + s3.RunFile:                runFile(t, finishedProcess(t), "telegraf"),
+		starting + "/dump_20191014140000.tar.gz":        "no run file yet",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithStagingDir(stagingDir), s3.WithDatabase("metrics"))
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(testUploader.keys) != 1 {
+		t.Fatalf("only the new archive should have been uploaded, got %v", testUploader.keys)
+	}
+	for _, dir := range []string{running, otherDatabase, starting} {
+		if _, err := os.Stat(stagingDir + "/" + dir); err != nil {
+			t.Fatalf("run directory %s should have been kept, %v", dir, err)
+		}
+	}
+}
+
+// runFile encodes the run file of a run of the given process on this host.
+func runFile(t *testing.T, pid int, database string) string {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := json.Marshal(map[string]interface{}{"host": host, "pid": pid, "database": database})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(encoded)
+}
+
+// finishedProcess returns the pid of a process which has exited.
+func finishedProcess(t *testing.T) int {
+	cmd := exec.Command("true")
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	return cmd.Process.Pid
+}
+
+func createFiles(dir string, files map[string]string) error {
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			return errors.Wrapf(err, "failed to create directory of %s", path)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+			return errors.Wrapf(err, "failed to write file %s", path)
+		}
+	}
+	return nil
+}
+
+// createPortableBackup writes the manifest of a portable backup of shard 412, its meta data is not readable.
+func createPortableBackup(backupPath string, withShard bool) error {
+	if err := os.MkdirAll(backupPath, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", backupPath)
+	}
+	files := map[string]string{
+		"20191014T120000Z.manifest": `{"meta":{"fileName":"20191014T120000Z.meta","size":4},"limited":true,"files":[` +
+			`{"database":"metrics","policy":"autogen","shardID":412,"fileName":"20191014T120000Z.s412.tar.gz","size":5,"lastModified":0}]}`,
+		"20191014T120000Z.meta": "meta",
+	}
+	if withShard {
+		files["20191014T120000Z.s412.tar.gz"] = "shard"
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(backupPath, name), []byte(content), 0600); err != nil {
+			return errors.Wrapf(err, "failed to write file %s", name)
+		}
+	}
+	return nil
+}
+
 func createSomeFilesForBackup(backupPath string) error {
 	if err := os.MkdirAll(backupPath, 0700); err != nil {
 		return errors.Wrapf(err, "failed to create directory %s", backupPath)
@@ -126,15 +699,23 @@ func createSomeFilesForBackup(backupPath string) error {
 }
 
 type testUploader struct {
-	result     *backup.FileContent
-	shouldFail bool
+	result       *backup.FileContent
+	keys         []string
+	contentTypes []string
+	shouldFail   bool
 }
 
 func (u *testUploader) Upload(content *backup.FileContent) (storageLocation string, err error) {
 	if u.shouldFail {
 		return "", errors.New("upload failed horribly")
 	}
-	u.result = content
+	data, err := ioutil.ReadAll(content.Reader())
+	if err != nil {
+		return "", err
+	}
+	u.result = &backup.FileContent{Key: content.Key, ContentType: content.ContentType, Content: &data, Tags: content.Tags, Metadata: content.Metadata}
+	u.keys = append(u.keys, content.Key)
+	u.contentTypes = append(u.contentTypes, content.ContentType)
 	return "https://some.aws.url/snapshot/" + content.Key, nil
 }
 
@@ -149,9 +730,43 @@ func checkGzFormat(contentBytes []byte) error {
 	return nil
 }
 
+func archiveEntries(contentBytes []byte) ([]string, error) {
+	gzipReader, err := gz.NewReader(bytes.NewReader(contentBytes))
+	if err != nil {
+		return nil, err
+	}
+	tarReader := tar.NewReader(gzipReader)
+	var names []string
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, header.Name)
+	}
+}
+
+// tarOnlyArchiver implements nothing but gzip.Tarer, like archivers written before the optional capabilities.
+type tarOnlyArchiver struct{}
+
+func (tarOnlyArchiver) TarGz(outFilePath string, inPath string) error {
+	return gzip.GzTarer{}.TarGz(outFilePath, inPath)
+}
+
 type failingArchiver struct {
+	gzip.GzTarer
 }
 
 func (failingArchiver) TarGz(outFilePath string, inPath string) error {
 	return errors.New("failed to archive")
 }
+
+func (failingArchiver) Stream(w io.Writer, inPath string) error {
+	if _, err := w.Write([]byte("partial")); err != nil {
+		return err
+	}
+	return errors.New("failed to archive")
+}
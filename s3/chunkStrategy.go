@@ -0,0 +1,30 @@
+package s3
+
+import (
+	"github.com/hill-daniel/influx-backup/chunk"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/hill-daniel/influx-backup/hook"
+	log "github.com/sirupsen/logrus"
+)
+
+// chunkStrategy stores the snapshot files selected by filter in the chunk repository, no archive is written.
+type chunkStrategy struct {
+	repository *chunk.Repository
+	filter     gzip.Filter
+}
+
+func (c chunkStrategy) store(d BucketBackup, taken snapshot) (string, string, error) {
+	name := ArchivePrefix + taken.timestamp
+	storageLocation, _, err := c.repository.Backup(name, taken.dir, c.filter, merge(d.metadata, taken.metadata))
+	if err != nil {
+		return "", "", err
+	}
+	event := d.event
+	event.StorageLocation = storageLocation
+	hookErr := d.hooks.Run(hook.AfterUpload, event)
+	if err := d.removeSnapshot(taken.dir, taken.timestamp); err != nil {
+		log.Error(err)
+	}
+	d.rotate()
+	return name, storageLocation, hookErr
+}
@@ -0,0 +1,227 @@
+package s3
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// maxCopySize is the largest object CopyObject accepts, larger objects are copied in parts.
+	maxCopySize  = 5 * 1024 * 1024 * 1024
+	copyPartSize = 512 * 1024 * 1024
+)
+
+// Open returns the content of the object with the given key.
+func (u BinaryUploader) Open(key string) (io.ReadCloser, error) {
+	var output *awss3.GetObjectOutput
+	err := u.retryPolicy.Do("download of "+key, func() error {
+		var err error
+		output, err = u.uploader.S3.GetObject(&awss3.GetObjectInput{Bucket: aws.String(u.bucketName), Key: aws.String(key)})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to download %s from bucket %s", key, u.bucketName)
+	}
+	return output.Body, nil
+}
+
+// Stat returns content type, tags and metadata of the object with the given key, e.g. to keep them when copying it
+// to another storage. S3 returns metadata keys in canonical form, they are lower-cased as they were written.
+func (u BinaryUploader) Stat(key string) (backup.Attributes, error) {
+	return u.stat(u.bucketName, key)
+}
+
+func (u BinaryUploader) stat(bucket string, key string) (backup.Attributes, error) {
+	head, err := u.head(bucket, key)
+	if err != nil {
+		return backup.Attributes{}, err
+	}
+	var tagging *awss3.GetObjectTaggingOutput
+	err = u.retryPolicy.Do("reading tags of "+key, func() error {
+		var err error
+		tagging, err = u.uploader.S3.GetObjectTagging(&awss3.GetObjectTaggingInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		return err
+	})
+	if err != nil {
+		return backup.Attributes{}, errors.Wrapf(err, "failed to read attributes of %s in bucket %s", key, bucket)
+	}
+	attributes := backup.Attributes{ContentType: aws.StringValue(head.ContentType), Metadata: metadataOf(head)}
+	if len(tagging.TagSet) > 0 {
+		attributes.Tags = make(map[string]string, len(tagging.TagSet))
+		for _, tag := range tagging.TagSet {
+			attributes.Tags[aws.StringValue(tag.Key)] = aws.StringValue(tag.Value)
+		}
+	}
+	return attributes, nil
+}
+
+func (u BinaryUploader) head(bucket string, key string) (*awss3.HeadObjectOutput, error) {
+	var head *awss3.HeadObjectOutput
+	err := u.retryPolicy.Do("reading attributes of "+key, func() error {
+		var err error
+		head, err = u.uploader.S3.HeadObject(&awss3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+		return err
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read attributes of %s in bucket %s", key, bucket)
+	}
+	return head, nil
+}
+
+func metadataOf(head *awss3.HeadObjectOutput) map[string]string {
+	if len(head.Metadata) == 0 {
+		return nil
+	}
+	metadata := make(map[string]string, len(head.Metadata))
+	for name, value := range head.Metadata {
+		metadata[strings.ToLower(name)] = aws.StringValue(value)
+	}
+	return metadata
+}
+
+// CopyFrom copies an object of another bucket server-side, the data is not transferred through this host.
+// Both buckets have to be accessible with the credentials of this uploader. Metadata and tags are copied,
+// storage class and object lock of this uploader are applied to the copy.
+func (u BinaryUploader) CopyFrom(sourceBucket string, key string, size int64) (string, error) {
+	attributes := objectAttributes{storageClass: u.storageClass}
+	u.objectLock.apply(&attributes, time.Now())
+	source := copySource(sourceBucket, key)
+	var err error
+	if size <= maxCopySize {
+		err = u.retryPolicy.Do("copy of "+key, func() error {
+			_, err := u.uploader.S3.CopyObject(&awss3.CopyObjectInput{
+				Bucket:                    aws.String(u.bucketName),
+				Key:                       aws.String(key),
+				CopySource:                aws.String(source),
+				StorageClass:              attributes.storageClassValue(),
+				ObjectLockMode:            attributes.lockMode,
+				ObjectLockRetainUntilDate: attributes.retainUntil,
+				ObjectLockLegalHoldStatus: attributes.legalHold,
+			})
+			return err
+		})
+	} else {
+		err = u.copyInParts(sourceBucket, key, size, attributes)
+	}
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to copy %s from bucket %s to bucket %s", key, sourceBucket, u.bucketName)
+	}
+	return "s3://" + u.bucketName + "/" + key, nil
+}
+
+// VerifyCopy compares size and SHA-256 digest of a copy made by CopyFrom with the source object, only their attributes
+// are read. The digest recorded at upload is copied with the object, S3 checks the integrity of the copied data itself.
+// It returns false if the source has no recorded digest, e.g. a streamed archive, the copy has to be verified by its content then.
+func (u BinaryUploader) VerifyCopy(sourceBucket string, key string) (bool, error) {
+	source, err := u.head(sourceBucket, key)
+	if err != nil {
+		return false, err
+	}
+	expected := metadataOf(source)[MetadataSHA256]
+	if expected == "" {
+		return false, nil
+	}
+	copied, err := u.head(u.bucketName, key)
+	if err != nil {
+		return false, err
+	}
+	if actual := metadataOf(copied)[MetadataSHA256]; actual != expected {
+		return false, fmt.Errorf("digest of copy %s does not match digest of source %s for %s", actual, expected, key)
+	}
+	if actual, expected := aws.Int64Value(copied.ContentLength), aws.Int64Value(source.ContentLength); actual != expected {
+		return false, fmt.Errorf("size of copy %d does not match size of source %d for %s", actual, expected, key)
+	}
+	return true, nil
+}
+
+// copyInParts copies objects larger than 5 GiB with a multipart upload. UploadPartCopy does not copy
+// content type, metadata and tags, they are read from the source object and set on the upload.
+func (u BinaryUploader) copyInParts(sourceBucket string, key string, size int64, attributes objectAttributes) error {
+	source, err := u.stat(sourceBucket, key)
+	if err != nil {
+		return err
+	}
+	tagging, err := encodeTags(source.Tags)
+	if err != nil {
+		return err
+	}
+	attributes.tagging = tagging
+	var contentType *string
+	if source.ContentType != "" {
+		contentType = aws.String(source.ContentType)
+	}
+	created, err := u.uploader.S3.CreateMultipartUpload(&awss3.CreateMultipartUploadInput{
+		Bucket:                    aws.String(u.bucketName),
+		Key:                       aws.String(key),
+		ContentType:               contentType,
+		Metadata:                  aws.StringMap(source.Metadata),
+		Tagging:                   attributes.taggingValue(),
+		StorageClass:              attributes.storageClassValue(),
+		ObjectLockMode:            attributes.lockMode,
+		ObjectLockRetainUntilDate: attributes.retainUntil,
+		ObjectLockLegalHoldStatus: attributes.legalHold,
+	})
+	if err != nil {
+		return err
+	}
+	partSize := partSizeFor(size, copyPartSize)
+	var parts []*awss3.CompletedPart
+	for offset, partNumber := int64(0), int64(1); offset < size; offset, partNumber = offset+partSize, partNumber+1 {
+		last := offset + partSize - 1
+		if last >= size {
+			last = size - 1
+		}
+		var output *awss3.UploadPartCopyOutput
+		err := u.retryPolicy.Do(fmt.Sprintf("copy of part %d of %s", partNumber, key), func() error {
+			var err error
+			output, err = u.uploader.S3.UploadPartCopy(&awss3.UploadPartCopyInput{
+				Bucket:          aws.String(u.bucketName),
+				Key:             aws.String(key),
+				UploadId:        created.UploadId,
+				PartNumber:      aws.Int64(partNumber),
+				CopySource:      aws.String(copySource(sourceBucket, key)),
+				CopySourceRange: aws.String(fmt.Sprintf("bytes=%d-%d", offset, last)),
+			})
+			return err
+		})
+		if err != nil {
+			u.abortCopy(key, created.UploadId)
+			return err
+		}
+		parts = append(parts, &awss3.CompletedPart{ETag: output.CopyPartResult.ETag, PartNumber: aws.Int64(partNumber)})
+	}
+	_, err = u.uploader.S3.CompleteMultipartUpload(&awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(u.bucketName),
+		Key:             aws.String(key),
+		UploadId:        created.UploadId,
+		MultipartUpload: &awss3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		u.abortCopy(key, created.UploadId)
+	}
+	return err
+}
+
+func (u BinaryUploader) abortCopy(key string, uploadID *string) {
+	_, err := u.uploader.S3.AbortMultipartUpload(&awss3.AbortMultipartUploadInput{Bucket: aws.String(u.bucketName), Key: aws.String(key), UploadId: uploadID})
+	if err != nil {
+		log.Errorf("failed to abort copy of %s, %v", key, err)
+	}
+}
+
+// copySource URL-encodes bucket and key, slashes of the key are kept.
+func copySource(bucket string, key string) string {
+	segments := strings.Split(key, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return url.PathEscape(bucket) + "/" + strings.Join(segments, "/")
+}
@@ -0,0 +1,135 @@
+package s3_test
+
+import (
+	"bytes"
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hill-daniel/influx-backup/s3"
+	"io/ioutil"
+	"testing"
+)
+
+func Test_should_copy_small_objects_with_copy_object(t *testing.T) {
+	client := &fakeCopyClient{}
+	binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: client}, s3.HexKeyProvider{}, "new-bucket", s3.WithStorageClass("STANDARD_IA"))
+
+	storageLocation, err := binaryUploader.CopyFrom("old-bucket", "metrics/dump 1.tar.gz", 1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageLocation != "s3://new-bucket/metrics/dump 1.tar.gz" {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	if len(client.copied) != 1 || aws.StringValue(client.copied[0].CopySource) != "old-bucket/metrics/dump%201.tar.gz" {
+		t.Fatalf("unexpected copies %v", client.copied)
+	}
+	if aws.StringValue(client.copied[0].StorageClass) != "STANDARD_IA" {
+		t.Fatalf("unexpected storage class %v", client.copied[0].StorageClass)
+	}
+}
+
+func Test_should_copy_large_objects_in_parts(t *testing.T) {
+	client := &fakeCopyClient{}
+	binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: client}, s3.HexKeyProvider{}, "new-bucket")
+
+	_, err := binaryUploader.CopyFrom("old-bucket", "metrics/dump_1.tar.gz", 6*1024*1024*1024)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(client.copied) != 0 {
+		t.Fatal("large objects can not be copied with CopyObject")
+	}
+	if len(client.ranges) != 12 {
+		t.Fatalf("actual: %d expected: %d parts", len(client.ranges), 12)
+	}
+	if client.ranges[0] != "bytes=0-536870911" || client.ranges[11] != "bytes=5905580032-6442450943" {
+		t.Fatalf("unexpected ranges %v", client.ranges)
+	}
+	if client.completedParts != 12 {
+		t.Fatalf("actual: %d expected: %d completed parts", client.completedParts, 12)
+	}
+	if aws.StringValue(client.created.ContentType) != "application/gzip" || aws.StringValue(client.created.Tagging) != "retention=monthly" {
+		t.Fatalf("content type and tags of the source should be kept, %v", client.created)
+	}
+}
+
+func Test_should_read_attributes_of_object(t *testing.T) {
+	client := &fakeCopyClient{}
+	binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: client}, s3.HexKeyProvider{}, "bucket")
+
+	attributes, err := binaryUploader.Stat("metrics/dump_1.tar.gz")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if attributes.ContentType != "application/gzip" || attributes.Tags["retention"] != "monthly" || attributes.Metadata["uncompressed-size"] != "42" {
+		t.Fatalf("unexpected attributes %+v", attributes)
+	}
+}
+
+func Test_should_verify_server_side_copy_without_downloading_it(t *testing.T) {
+	client := &fakeCopyClient{}
+	binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: client}, s3.HexKeyProvider{}, "new-bucket")
+	if _, err := binaryUploader.CopyFrom("old-bucket", "metrics/dump_1.tar.gz", 1024); err != nil {
+		t.Fatal(err)
+	}
+
+	verified, err := binaryUploader.VerifyCopy("old-bucket", "metrics/dump_1.tar.gz")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verified {
+		t.Fatal("copy with recorded digest should be verified")
+	}
+	if client.downloads != 0 {
+		t.Fatalf("verifying a server-side copy downloaded %d objects", client.downloads)
+	}
+}
+
+type fakeCopyClient struct {
+	s3iface.S3API
+	downloads      int
+	copied         []*awss3.CopyObjectInput
+	created        *awss3.CreateMultipartUploadInput
+	ranges         []string
+	completedParts int
+}
+
+func (c *fakeCopyClient) CopyObject(input *awss3.CopyObjectInput) (*awss3.CopyObjectOutput, error) {
+	c.copied = append(c.copied, input)
+	return &awss3.CopyObjectOutput{}, nil
+}
+
+func (c *fakeCopyClient) GetObject(input *awss3.GetObjectInput) (*awss3.GetObjectOutput, error) {
+	c.downloads++
+	return &awss3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(make([]byte, 1024)))}, nil
+}
+
+func (c *fakeCopyClient) HeadObject(input *awss3.HeadObjectInput) (*awss3.HeadObjectOutput, error) {
+	metadata := map[string]string{"Uncompressed-Size": "42", "Sha256": "5f70bf18a086007016e948b04aed3b82103a36bea41755b6cddfaf10ace3c6ef"}
+	return &awss3.HeadObjectOutput{ContentType: aws.String("application/gzip"), ContentLength: aws.Int64(1024), Metadata: aws.StringMap(metadata)}, nil
+}
+
+func (c *fakeCopyClient) GetObjectTagging(input *awss3.GetObjectTaggingInput) (*awss3.GetObjectTaggingOutput, error) {
+	return &awss3.GetObjectTaggingOutput{TagSet: []*awss3.Tag{{Key: aws.String("retention"), Value: aws.String("monthly")}}}, nil
+}
+
+func (c *fakeCopyClient) CreateMultipartUpload(input *awss3.CreateMultipartUploadInput) (*awss3.CreateMultipartUploadOutput, error) {
+	c.created = input
+	return &awss3.CreateMultipartUploadOutput{UploadId: aws.String("copy")}, nil
+}
+
+func (c *fakeCopyClient) UploadPartCopy(input *awss3.UploadPartCopyInput) (*awss3.UploadPartCopyOutput, error) {
+	c.ranges = append(c.ranges, aws.StringValue(input.CopySourceRange))
+	return &awss3.UploadPartCopyOutput{CopyPartResult: &awss3.CopyPartResult{ETag: aws.String("etag")}}, nil
+}
+
+func (c *fakeCopyClient) CompleteMultipartUpload(input *awss3.CompleteMultipartUploadInput) (*awss3.CompleteMultipartUploadOutput, error) {
+	c.completedParts = len(input.MultipartUpload.Parts)
+	return &awss3.CompleteMultipartUploadOutput{}, nil
+}
@@ -0,0 +1,40 @@
+package s3
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/pkg/errors"
+	"net/http"
+)
+
+// IsRetryable classifies errors returned by S3. Server errors, throttling and network failures are retryable,
+// client errors like a missing bucket or denied access are fatal.
+func IsRetryable(err error) bool {
+	if !retry.IsRetryable(err) {
+		return false
+	}
+	cause := errors.Cause(err)
+	// s3manager wraps the failed request, e.g. of a multipart upload
+	for cause != nil {
+		awsErr, ok := cause.(awserr.Error)
+		if !ok {
+			return request.IsErrorRetryable(cause)
+		}
+		if awsErr.Code() == request.CanceledErrorCode {
+			return false
+		}
+		if request.IsErrorThrottle(awsErr) {
+			return true
+		}
+		if failure, ok := awsErr.(awserr.RequestFailure); ok && failure.StatusCode() > 0 {
+			status := failure.StatusCode()
+			return status >= http.StatusInternalServerError || status == http.StatusTooManyRequests
+		}
+		if awsErr.OrigErr() == nil {
+			return request.IsErrorRetryable(awsErr)
+		}
+		cause = awsErr.OrigErr()
+	}
+	return false
+}
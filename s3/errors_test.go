@@ -0,0 +1,48 @@
+package s3_test
+
+import (
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/hill-daniel/influx-backup/s3"
+	"github.com/pkg/errors"
+	"testing"
+)
+
+func Test_should_classify_server_errors_as_retryable(t *testing.T) {
+	err := awserr.NewRequestFailure(awserr.New("InternalError", "we encountered an internal error", nil), 500, "id")
+
+	if !s3.IsRetryable(errors.Wrap(err, "upload failed")) {
+		t.Fatal("server error should be retryable")
+	}
+}
+
+func Test_should_classify_client_errors_as_fatal(t *testing.T) {
+	err := awserr.NewRequestFailure(awserr.New("NoSuchBucket", "the specified bucket does not exist", nil), 404, "id")
+
+	if s3.IsRetryable(err) {
+		t.Fatal("client error should be fatal")
+	}
+}
+
+func Test_should_classify_throttling_as_retryable(t *testing.T) {
+	err := awserr.NewRequestFailure(awserr.New("SlowDown", "please reduce your request rate", nil), 503, "id")
+
+	if !s3.IsRetryable(err) {
+		t.Fatal("throttling should be retryable")
+	}
+}
+
+func Test_should_inspect_request_failure_wrapped_by_multipart_upload(t *testing.T) {
+	partErr := awserr.NewRequestFailure(awserr.New("InternalError", "we encountered an internal error", nil), 500, "id")
+	err := awserr.New("MultipartUpload", "upload multipart failed", partErr)
+
+	if !s3.IsRetryable(err) {
+		t.Fatal("wrapped server error should be retryable")
+	}
+}
+
+func Test_should_not_retry_permanent_errors(t *testing.T) {
+	if s3.IsRetryable(retry.Permanent(errors.New("nope"))) {
+		t.Fatal("permanent error should be fatal")
+	}
+}
@@ -3,28 +3,42 @@ package s3
 import (
 	"encoding/hex"
 	"fmt"
+	"github.com/hill-daniel/influx-backup"
 )
 
 // BucketKeyProvider is an abstraction for creating keys for an s3 bucket.
-type BucketKeyProvider interface {
-	CreateKeyFor(symbol string) string
-}
+type BucketKeyProvider = backup.KeyProvider
 
-// HexKeyProvider adds a hex prefix for a given string.
-type HexKeyProvider struct{}
+// HexKeyProvider adds a hex prefix for a given string. With a Database the keys are scoped by it,
+// e.g. 6d657472_metrics/dump_20191014120000.tar.gz, so listing the prefix returns only the backups of the database.
+// Without Database the keys of all databases share the prefix 64756d70_dump_.
+type HexKeyProvider struct {
+	Database string
+}
 
 // CreateKeyFor creates a hex prefix for the given symbol to optimize storage on S3.
-// No more than eight chars will be used as the prefix.
+// No more than eight chars will be used as the prefix, the prefix of scoped keys is the one of the database.
 // Example: input: thisIsTheValue output: 74686973_thisIsTheValue
-func (HexKeyProvider) CreateKeyFor(symbol string) string {
-	bytes := []byte(symbol)
-	hexEncodedSymbol := hex.EncodeToString(bytes)
-	runes := []rune(hexEncodedSymbol)
-	var key string
-	if len(runes) > 8 {
-		key = string(runes[0:8])
-	} else {
-		key = hexEncodedSymbol
+func (p HexKeyProvider) CreateKeyFor(symbol string) (string, error) {
+	return p.keyFor(symbol), nil
+}
+
+// Prefix returns the key of the common archive prefix, the hex prefix of every archive is the same.
+func (p HexKeyProvider) Prefix() string {
+	return p.keyFor(ArchivePrefix)
+}
+
+func (p HexKeyProvider) keyFor(symbol string) string {
+	if p.Database != "" {
+		return fmt.Sprintf("%s_%s/%s", hexPrefix(p.Database), p.Database, symbol)
+	}
+	return fmt.Sprintf("%s_%s", hexPrefix(symbol), symbol)
+}
+
+func hexPrefix(symbol string) string {
+	hexEncodedSymbol := hex.EncodeToString([]byte(symbol))
+	if len(hexEncodedSymbol) > 8 {
+		return hexEncodedSymbol[:8]
 	}
-	return fmt.Sprintf("%s_%s", key, symbol)
+	return hexEncodedSymbol
 }
@@ -1,7 +1,12 @@
 package s3_test
 
 import (
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/filesystem"
 	"github.com/hill-daniel/influx-backup/s3"
+	"io/ioutil"
+	"os"
+	"strings"
 	"testing"
 )
 
@@ -9,7 +14,11 @@ func Test_should_create_hex_from_string_and_append_original_value(t *testing.T)
 	symbol := "key"
 	hexKeyProvider := s3.HexKeyProvider{}
 
-	actualKey := hexKeyProvider.CreateKeyFor(symbol)
+	actualKey, err := hexKeyProvider.CreateKeyFor(symbol)
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	expectedKey := "6b6579_key"
 	if actualKey != expectedKey {
@@ -21,10 +30,110 @@ func Test_should_truncate_hex_if_longer_than_8char_and_append_original_value(t *
 	symbol := "thisIsTheValue"
 	hexKeyProvider := s3.HexKeyProvider{}
 
-	actualKey := hexKeyProvider.CreateKeyFor(symbol)
+	actualKey, err := hexKeyProvider.CreateKeyFor(symbol)
+
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	expectedKey := "74686973_thisIsTheValue"
 	if actualKey != expectedKey {
 		t.Fatalf("actual: %s expected: %s", actualKey, expectedKey)
 	}
 }
+
+func Test_should_return_key_of_archive_prefix_as_prefix(t *testing.T) {
+	hexKeyProvider := s3.HexKeyProvider{}
+
+	actualPrefix := hexKeyProvider.Prefix()
+
+	expectedPrefix := "64756d70_dump_"
+	if actualPrefix != expectedPrefix {
+		t.Fatalf("actual: %s expected: %s", actualPrefix, expectedPrefix)
+	}
+}
+
+func Test_should_scope_hex_keys_by_database(t *testing.T) {
+	hexKeyProvider := s3.HexKeyProvider{Database: "metrics"}
+
+	actualKey, err := hexKeyProvider.CreateKeyFor("dump_20191014120000.tar.gz")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedKey := "6d657472_metrics/dump_20191014120000.tar.gz"
+	if actualKey != expectedKey {
+		t.Fatalf("actual: %s expected: %s", actualKey, expectedKey)
+	}
+	if !strings.HasPrefix(actualKey, hexKeyProvider.Prefix()) || strings.HasPrefix(actualKey, s3.HexKeyProvider{Database: "metric"}.Prefix()) {
+		t.Fatalf("prefix %s does not select the key %s only", hexKeyProvider.Prefix(), actualKey)
+	}
+}
+
+func Test_should_prune_only_backups_of_the_database(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buckets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	providers := []s3.HexKeyProvider{{Database: "metrics"}, {Database: "metrics2"}}
+	for _, provider := range providers {
+		uploader := filesystem.NewUploader(dir, provider)
+		for _, name := range []string{"dump_20191013120000.tar.gz", "dump_20191014120000.tar.gz"} {
+			content := []byte(name)
+			if _, err := uploader.Upload(&backup.FileContent{Key: name, Content: &content, Size: int64(len(content))}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	storage := filesystem.NewUploader(dir, backup.IdentityKeyProvider{})
+
+	deleted, err := backup.Prune(&storage, providers[0].Prefix(), 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "6d657472_metrics/dump_20191013120000.tar.gz" {
+		t.Fatalf("unexpected deleted backups %v", deleted)
+	}
+	remaining, err := storage.List(providers[1].Prefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(remaining) != 2 {
+		t.Fatalf("backups of the other database were deleted, remaining %v", remaining)
+	}
+}
+
+func Test_should_still_find_backups_stored_with_unscoped_keys(t *testing.T) {
+	dir, err := ioutil.TempDir("", "buckets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	legacy := filesystem.NewUploader(dir, s3.HexKeyProvider{})
+	for _, name := range []string{"dump_20191013120000.tar.gz", "dump_20191014120000.tar.gz"} {
+		content := []byte(name)
+		if _, err := legacy.Upload(&backup.FileContent{Key: name, Content: &content, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	storage := filesystem.NewUploader(dir, backup.IdentityKeyProvider{})
+
+	objects, err := storage.List(s3.HexKeyProvider{}.Prefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := backup.Prune(&storage, s3.HexKeyProvider{}.Prefix(), 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 || objects[0].Key != "64756d70_dump_20191013120000.tar.gz" {
+		t.Fatalf("backups stored with unscoped keys were not found, got %v", objects)
+	}
+	if len(deleted) != 1 || deleted[0] != "64756d70_dump_20191013120000.tar.gz" {
+		t.Fatalf("unexpected deleted backups %v", deleted)
+	}
+}
@@ -0,0 +1,33 @@
+package s3
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+)
+
+// List returns all objects of the bucket whose keys start with the given prefix.
+func (u BinaryUploader) List(prefix string) ([]backup.StoredObject, error) {
+	var objects []backup.StoredObject
+	err := u.retryPolicy.Do("listing objects with prefix "+prefix, func() error {
+		objects = nil
+		return u.uploader.S3.ListObjectsV2Pages(&awss3.ListObjectsV2Input{
+			Bucket: aws.String(u.bucketName),
+			Prefix: aws.String(prefix),
+		}, func(output *awss3.ListObjectsV2Output, lastPage bool) bool {
+			for _, object := range output.Contents {
+				objects = append(objects, backup.StoredObject{
+					Key:          aws.StringValue(object.Key),
+					Size:         aws.Int64Value(object.Size),
+					LastModified: aws.TimeValue(object.LastModified),
+				})
+			}
+			return true
+		})
+	})
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to list objects with prefix %s in bucket %s", prefix, u.bucketName)
+	}
+	return objects, nil
+}
@@ -0,0 +1,52 @@
+package s3_test
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hill-daniel/influx-backup/s3"
+	"strings"
+	"testing"
+)
+
+func Test_should_list_objects_with_prefix_of_all_pages(t *testing.T) {
+	client := &fakeListClient{keys: [][]string{
+		{"backups/edge-1/metrics/2019/10/13/dump_1.tar.gz", "backups/edge-1/other/2019/10/13/dump_1.tar.gz"},
+		{"backups/edge-1/metrics/2019/10/14/dump_2.tar.gz"},
+	}}
+	binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: client}, s3.HexKeyProvider{}, "bucket")
+
+	objects, err := binaryUploader.List("backups/edge-1/metrics/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 2 {
+		t.Fatalf("actual: %d expected: %d objects", len(objects), 2)
+	}
+	if objects[1].Key != "backups/edge-1/metrics/2019/10/14/dump_2.tar.gz" {
+		t.Fatalf("unexpected key %s", objects[1].Key)
+	}
+}
+
+// fakeListClient returns the keys matching the prefix page by page.
+type fakeListClient struct {
+	s3iface.S3API
+	keys [][]string
+}
+
+func (c *fakeListClient) ListObjectsV2Pages(input *awss3.ListObjectsV2Input, fn func(*awss3.ListObjectsV2Output, bool) bool) error {
+	for i, page := range c.keys {
+		output := &awss3.ListObjectsV2Output{}
+		for _, key := range page {
+			if strings.HasPrefix(key, aws.StringValue(input.Prefix)) {
+				output.Contents = append(output.Contents, &awss3.Object{Key: aws.String(key), Size: aws.Int64(42)})
+			}
+		}
+		if !fn(output, i == len(c.keys)-1) {
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package s3
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hill-daniel/influx-backup/throttle"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// uploadState is persisted after every uploaded part, so an interrupted upload can be continued by the next run.
+type uploadState struct {
+	Bucket   string          `json:"bucket"`
+	Key      string          `json:"key"`
+	UploadID string          `json:"uploadId"`
+	Source   string          `json:"source"`
+	Size     int64           `json:"size"`
+	ModTime  time.Time       `json:"modTime"`
+	PartSize int64           `json:"partSize"`
+	Parts    []completedPart `json:"parts"`
+}
+
+type completedPart struct {
+	PartNumber int64  `json:"partNumber"`
+	ETag       string `json:"etag"`
+}
+
+// resumableUpload uploads a local file in parts and keeps track of the completed parts in a state file.
+type resumableUpload struct {
+	client      s3iface.S3API
+	stateFile   string
+	state       uploadState
+	file        *os.File
+	concurrency int
+	limiter     *throttle.Limiter
+	mutex       sync.Mutex
+}
+
+func (u BinaryUploader) uploadResumable(file *os.File, key string, attributes objectAttributes) (string, error) {
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to read file %s", file.Name())
+	}
+	if err := os.MkdirAll(u.stateDir, 0700); err != nil {
+		return "", errors.Wrapf(err, "failed to create state directory %s", u.stateDir)
+	}
+	upload := &resumableUpload{
+		client:      u.uploader.S3,
+		stateFile:   filepath.Join(u.stateDir, stateFileName(u.bucketName, key)),
+		file:        file,
+		concurrency: u.uploader.Concurrency,
+		limiter:     u.limiter,
+	}
+	if upload.concurrency <= 0 {
+		upload.concurrency = s3manager.DefaultUploadConcurrency
+	}
+	expected := uploadState{
+		Bucket:   u.bucketName,
+		Key:      key,
+		Source:   file.Name(),
+		Size:     fileInfo.Size(),
+		ModTime:  fileInfo.ModTime().UTC(),
+		PartSize: partSizeFor(fileInfo.Size(), u.uploader.PartSize),
+	}
+	if err := upload.prepare(expected, attributes); err != nil {
+		return "", err
+	}
+	if err := upload.uploadMissingParts(); err != nil {
+		return "", err
+	}
+	return upload.complete()
+}
+
+// prepare continues a persisted upload if it matches the file, otherwise a new multipart upload is created.
+func (r *resumableUpload) prepare(expected uploadState, attributes objectAttributes) error {
+	previous, err := readState(r.stateFile)
+	if err != nil {
+		return err
+	}
+	if previous != nil {
+		if previous.matches(expected) {
+			exists, err := r.exists(previous)
+			if err != nil {
+				return err
+			}
+			if exists {
+				log.Infof("resuming upload of %s with %d completed parts", previous.Key, len(previous.Parts))
+				r.state = *previous
+				return nil
+			}
+		} else {
+			r.abort(previous)
+		}
+	}
+	output, err := r.client.CreateMultipartUpload(&awss3.CreateMultipartUploadInput{
+		Bucket:                    aws.String(expected.Bucket),
+		Key:                       aws.String(expected.Key),
+		ContentType:               aws.String(attributes.contentType),
+		StorageClass:              attributes.storageClassValue(),
+		Tagging:                   attributes.taggingValue(),
+		Metadata:                  attributes.metadata,
+		ObjectLockMode:            attributes.lockMode,
+		ObjectLockRetainUntilDate: attributes.retainUntil,
+		ObjectLockLegalHoldStatus: attributes.legalHold,
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to create multipart upload for key %s", expected.Key)
+	}
+	r.state = expected
+	r.state.UploadID = aws.StringValue(output.UploadId)
+	return r.save()
+}
+
+func (s *uploadState) matches(other uploadState) bool {
+	return s.Bucket == other.Bucket && s.Key == other.Key && s.Size == other.Size &&
+		s.ModTime.Equal(other.ModTime) && s.PartSize == other.PartSize && s.UploadID != ""
+}
+
+// exists checks whether S3 still knows the upload, it might have been aborted or cleaned up by a lifecycle rule.
+func (r *resumableUpload) exists(state *uploadState) (bool, error) {
+	_, err := r.client.ListParts(&awss3.ListPartsInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+		MaxParts: aws.Int64(1),
+	})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == awss3.ErrCodeNoSuchUpload {
+		log.Warnf("persisted upload %s for %s does not exist anymore, starting over", state.UploadID, state.Key)
+		return false, nil
+	}
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to look up persisted upload of %s", state.Key)
+	}
+	return true, nil
+}
+
+func (r *resumableUpload) abort(state *uploadState) {
+	if _, err := r.client.AbortMultipartUpload(&awss3.AbortMultipartUploadInput{
+		Bucket:   aws.String(state.Bucket),
+		Key:      aws.String(state.Key),
+		UploadId: aws.String(state.UploadID),
+	}); err != nil {
+		log.Warnf("failed to abort outdated upload %s for %s, %v", state.UploadID, state.Key, err)
+	}
+}
+
+func (r *resumableUpload) uploadMissingParts() error {
+	done := make(map[int64]bool)
+	for _, part := range r.state.Parts {
+		done[part.PartNumber] = true
+	}
+	partCount := (r.state.Size + r.state.PartSize - 1) / r.state.PartSize
+	if partCount == 0 {
+		partCount = 1
+	}
+	partNumbers := make(chan int64)
+	errs := make(chan error, r.concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < r.concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for partNumber := range partNumbers {
+				if err := r.uploadPart(partNumber); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	var err error
+	for partNumber := int64(1); partNumber <= partCount && err == nil; partNumber++ {
+		if done[partNumber] {
+			continue
+		}
+		select {
+		case partNumbers <- partNumber:
+		case err = <-errs:
+		}
+	}
+	close(partNumbers)
+	wg.Wait()
+	close(errs)
+	if err != nil {
+		return err
+	}
+	return <-errs
+}
+
+func (r *resumableUpload) uploadPart(partNumber int64) error {
+	offset := (partNumber - 1) * r.state.PartSize
+	length := r.state.PartSize
+	if offset+length > r.state.Size {
+		length = r.state.Size - offset
+	}
+	var body io.ReadSeeker = io.NewSectionReader(r.file, offset, length)
+	if r.limiter != nil {
+		// the part is buffered, signing the request would otherwise consume the bandwidth twice
+		buffer, err := ioutil.ReadAll(r.limiter.Reader(body))
+		if err != nil {
+			return errors.Wrapf(err, "failed to read part %d of %s", partNumber, r.file.Name())
+		}
+		body = bytes.NewReader(buffer)
+	}
+	output, err := r.client.UploadPart(&awss3.UploadPartInput{
+		Bucket:        aws.String(r.state.Bucket),
+		Key:           aws.String(r.state.Key),
+		UploadId:      aws.String(r.state.UploadID),
+		PartNumber:    aws.Int64(partNumber),
+		Body:          body,
+		ContentLength: aws.Int64(length),
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to upload part %d of %s", partNumber, r.state.Key)
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state.Parts = append(r.state.Parts, completedPart{PartNumber: partNumber, ETag: aws.StringValue(output.ETag)})
+	log.Debugf("uploaded part %d of %s", partNumber, r.state.Key)
+	return r.save()
+}
+
+func (r *resumableUpload) complete() (string, error) {
+	sort.Slice(r.state.Parts, func(i, j int) bool {
+		return r.state.Parts[i].PartNumber < r.state.Parts[j].PartNumber
+	})
+	parts := make([]*awss3.CompletedPart, 0, len(r.state.Parts))
+	for _, part := range r.state.Parts {
+		parts = append(parts, &awss3.CompletedPart{PartNumber: aws.Int64(part.PartNumber), ETag: aws.String(part.ETag)})
+	}
+	output, err := r.client.CompleteMultipartUpload(&awss3.CompleteMultipartUploadInput{
+		Bucket:          aws.String(r.state.Bucket),
+		Key:             aws.String(r.state.Key),
+		UploadId:        aws.String(r.state.UploadID),
+		MultipartUpload: &awss3.CompletedMultipartUpload{Parts: parts},
+	})
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to complete multipart upload of %s", r.state.Key)
+	}
+	if err := os.Remove(r.stateFile); err != nil {
+		log.Warnf("failed to remove upload state %s, %v", r.stateFile, err)
+	}
+	return aws.StringValue(output.Location), nil
+}
+
+// save writes the state to a temporary file first, a crash must not leave a corrupted state behind.
+func (r *resumableUpload) save() error {
+	bytes, err := json.Marshal(r.state)
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal upload state")
+	}
+	tmpFile := r.stateFile + ".tmp"
+	if err := ioutil.WriteFile(tmpFile, bytes, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write upload state %s", tmpFile)
+	}
+	if err := os.Rename(tmpFile, r.stateFile); err != nil {
+		return errors.Wrapf(err, "failed to write upload state %s", r.stateFile)
+	}
+	return nil
+}
+
+func readState(stateFile string) (*uploadState, error) {
+	bytes, err := ioutil.ReadFile(stateFile)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read upload state %s", stateFile)
+	}
+	state := &uploadState{}
+	if err := json.Unmarshal(bytes, state); err != nil {
+		log.Warnf("ignoring corrupted upload state %s, %v", stateFile, err)
+		return nil, nil
+	}
+	return state, nil
+}
+
+func stateFileName(bucketName string, key string) string {
+	hash := sha1.Sum([]byte(bucketName + "/" + key))
+	return hex.EncodeToString(hash[:]) + ".json"
+}
+
+// partSizeFor keeps the number of parts below the limit of S3.
+func partSizeFor(size int64, partSize int64) int64 {
+	if partSize < s3manager.MinUploadPartSize {
+		partSize = s3manager.MinUploadPartSize
+	}
+	if minimum := (size + s3manager.MaxUploadParts - 1) / s3manager.MaxUploadParts; partSize < minimum {
+		partSize = minimum
+	}
+	return partSize
+}
+
+// AbortStaleUploads aborts incomplete multipart uploads below the given prefix which were initiated before olderThan.
+// The keys of the aborted uploads are returned.
+func (u BinaryUploader) AbortStaleUploads(prefix string, olderThan time.Duration) ([]string, error) {
+	threshold := time.Now().Add(-olderThan)
+	var aborted []string
+	input := &awss3.ListMultipartUploadsInput{Bucket: aws.String(u.bucketName), Prefix: aws.String(prefix)}
+	for {
+		var output *awss3.ListMultipartUploadsOutput
+		err := u.retryPolicy.Do("listing multipart uploads", func() error {
+			var err error
+			output, err = u.uploader.S3.ListMultipartUploads(input)
+			return err
+		})
+		if err != nil {
+			return aborted, errors.Wrapf(err, "failed to list multipart uploads of bucket %s", u.bucketName)
+		}
+		for _, upload := range output.Uploads {
+			if aws.TimeValue(upload.Initiated).After(threshold) {
+				continue
+			}
+			key := aws.StringValue(upload.Key)
+			err := u.retryPolicy.Do("aborting multipart upload of "+key, func() error {
+				_, err := u.uploader.S3.AbortMultipartUpload(&awss3.AbortMultipartUploadInput{
+					Bucket:   aws.String(u.bucketName),
+					Key:      upload.Key,
+					UploadId: upload.UploadId,
+				})
+				return err
+			})
+			if err != nil {
+				return aborted, errors.Wrapf(err, "failed to abort multipart upload of %s", key)
+			}
+			log.Infof("aborted multipart upload of %s initiated at %s", key, aws.TimeValue(upload.Initiated))
+			aborted = append(aborted, key)
+		}
+		if !aws.BoolValue(output.IsTruncated) {
+			return aborted, nil
+		}
+		input.KeyMarker = output.NextKeyMarker
+		input.UploadIdMarker = output.NextUploadIdMarker
+	}
+}
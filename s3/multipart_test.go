@@ -0,0 +1,191 @@
+package s3_test
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func Test_should_resume_interrupted_multipart_upload_with_missing_parts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	stateDir := filepath.Join(dir, "state")
+	archivePath := filepath.Join(dir, "dump_20191014120000.tar.gz")
+	if err := ioutil.WriteFile(archivePath, make([]byte, 2*s3manager.MinUploadPartSize+42), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeMultipartClient{failPart: 2}
+	uploader := &s3manager.Uploader{S3: client, PartSize: s3manager.MinUploadPartSize, Concurrency: 1}
+	binaryUploader := s3.NewBinaryUploader(uploader, s3.HexKeyProvider{}, "bucket", s3.WithResumableUploads(stateDir))
+
+	if _, err := uploadFile(binaryUploader, archivePath); err == nil {
+		t.Fatal("first upload should fail")
+	}
+	if states, _ := ioutil.ReadDir(stateDir); len(states) != 1 {
+		t.Fatal("upload state should have been persisted")
+	}
+	client.failPart = 0
+	storageLocation, err := uploadFile(binaryUploader, archivePath)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageLocation != "https://bucket.s3.amazonaws.com/64756d70_dump_20191014120000.tar.gz" {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	if client.created != 1 {
+		t.Fatalf("actual: %d expected: %d created uploads", client.created, 1)
+	}
+	if client.uploadedParts[1] != 1 || client.uploadedParts[2] != 1 || client.uploadedParts[3] != 1 {
+		t.Fatalf("every part should have been uploaded exactly once, %v", client.uploadedParts)
+	}
+	if client.completedParts != 3 {
+		t.Fatalf("actual: %d expected: %d completed parts", client.completedParts, 3)
+	}
+	if states, _ := ioutil.ReadDir(stateDir); len(states) != 0 {
+		t.Fatal("upload state should have been removed")
+	}
+}
+
+func Test_should_start_over_when_persisted_upload_does_not_exist_anymore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "resumable")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	stateDir := filepath.Join(dir, "state")
+	archivePath := filepath.Join(dir, "dump_20191014120000.tar.gz")
+	if err := ioutil.WriteFile(archivePath, make([]byte, 2*s3manager.MinUploadPartSize), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeMultipartClient{failPart: 2}
+	uploader := &s3manager.Uploader{S3: client, PartSize: s3manager.MinUploadPartSize, Concurrency: 1}
+	binaryUploader := s3.NewBinaryUploader(uploader, s3.HexKeyProvider{}, "bucket", s3.WithResumableUploads(stateDir))
+	if _, err := uploadFile(binaryUploader, archivePath); err == nil {
+		t.Fatal("first upload should fail")
+	}
+	client.failPart = 0
+	client.forget = true
+
+	if _, err := uploadFile(binaryUploader, archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	if client.created != 2 {
+		t.Fatalf("actual: %d expected: %d created uploads", client.created, 2)
+	}
+	if client.uploadedParts[1] != 2 {
+		t.Fatalf("first part should have been uploaded again, %v", client.uploadedParts)
+	}
+}
+
+func Test_should_abort_stale_multipart_uploads_only(t *testing.T) {
+	client := &fakeMultipartClient{uploads: []*awss3.MultipartUpload{
+		{Key: aws.String("64756d70_dump_1"), UploadId: aws.String("old"), Initiated: aws.Time(time.Now().Add(-48 * time.Hour))},
+		{Key: aws.String("64756d70_dump_2"), UploadId: aws.String("new"), Initiated: aws.Time(time.Now().Add(-time.Hour))},
+	}}
+	uploader := &s3manager.Uploader{S3: client}
+	binaryUploader := s3.NewBinaryUploader(uploader, s3.HexKeyProvider{}, "bucket")
+
+	aborted, err := binaryUploader.AbortStaleUploads("64756d70_dump_", 24*time.Hour)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(aborted) != 1 || aborted[0] != "64756d70_dump_1" {
+		t.Fatalf("unexpected aborted uploads %v", aborted)
+	}
+	if len(client.aborted) != 1 || client.aborted[0] != "old" {
+		t.Fatalf("unexpected aborted upload ids %v", client.aborted)
+	}
+}
+
+func uploadFile(uploader s3.BinaryUploader, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return "", err
+	}
+	return uploader.Upload(&backup.FileContent{Key: filepath.Base(path), Body: file, Size: fileInfo.Size(), ContentType: s3.Gzip})
+}
+
+// fakeMultipartClient keeps track of multipart calls, all other calls of the interface panic.
+type fakeMultipartClient struct {
+	s3iface.S3API
+	mutex          sync.Mutex
+	failPart       int64
+	forget         bool
+	created        int
+	createInput    *awss3.CreateMultipartUploadInput
+	uploadedParts  map[int64]int
+	completedParts int
+	uploads        []*awss3.MultipartUpload
+	aborted        []string
+}
+
+func (c *fakeMultipartClient) CreateMultipartUpload(input *awss3.CreateMultipartUploadInput) (*awss3.CreateMultipartUploadOutput, error) {
+	c.created++
+	c.createInput = input
+	return &awss3.CreateMultipartUploadOutput{UploadId: aws.String("upload-id")}, nil
+}
+
+func (c *fakeMultipartClient) UploadPart(input *awss3.UploadPartInput) (*awss3.UploadPartOutput, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	partNumber := aws.Int64Value(input.PartNumber)
+	if partNumber == c.failPart {
+		return nil, awserr.NewRequestFailure(awserr.New("InternalError", "we encountered an internal error", nil), 500, "id")
+	}
+	if c.uploadedParts == nil {
+		c.uploadedParts = make(map[int64]int)
+	}
+	c.uploadedParts[partNumber]++
+	return &awss3.UploadPartOutput{ETag: aws.String("etag")}, nil
+}
+
+func (c *fakeMultipartClient) ListParts(input *awss3.ListPartsInput) (*awss3.ListPartsOutput, error) {
+	if c.forget {
+		return nil, awserr.NewRequestFailure(awserr.New(awss3.ErrCodeNoSuchUpload, "the specified upload does not exist", nil), 404, "id")
+	}
+	return &awss3.ListPartsOutput{}, nil
+}
+
+func (c *fakeMultipartClient) CompleteMultipartUpload(input *awss3.CompleteMultipartUploadInput) (*awss3.CompleteMultipartUploadOutput, error) {
+	c.completedParts = len(input.MultipartUpload.Parts)
+	location := "https://" + aws.StringValue(input.Bucket) + ".s3.amazonaws.com/" + aws.StringValue(input.Key)
+	return &awss3.CompleteMultipartUploadOutput{Location: aws.String(location)}, nil
+}
+
+func (c *fakeMultipartClient) AbortMultipartUpload(input *awss3.AbortMultipartUploadInput) (*awss3.AbortMultipartUploadOutput, error) {
+	c.aborted = append(c.aborted, aws.StringValue(input.UploadId))
+	return &awss3.AbortMultipartUploadOutput{}, nil
+}
+
+func (c *fakeMultipartClient) ListMultipartUploads(input *awss3.ListMultipartUploadsInput) (*awss3.ListMultipartUploadsOutput, error) {
+	return &awss3.ListMultipartUploadsOutput{Uploads: c.uploads}, nil
+}
@@ -0,0 +1,149 @@
+package s3
+
+import (
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	"time"
+)
+
+// error codes of S3 for objects in buckets without object lock
+const (
+	errCodeNoObjectLockConfiguration = "ObjectLockConfigurationNotFoundError"
+	errCodeInvalidRequest            = "InvalidRequest"
+)
+
+// ObjectLock protects uploaded objects from deletion (WORM).
+// In governance mode users with special permissions may still delete objects, in compliance mode nobody can.
+type ObjectLock struct {
+	Mode      string
+	RetainFor time.Duration
+	LegalHold bool
+}
+
+// WithObjectLock uploads objects with the given retention and legal hold.
+func WithObjectLock(lock ObjectLock) Option {
+	return func(u *BinaryUploader) {
+		u.objectLock = &lock
+	}
+}
+
+// Validate checks mode and retention period of the lock.
+func (l ObjectLock) Validate() error {
+	if l.Mode != awss3.ObjectLockModeGovernance && l.Mode != awss3.ObjectLockModeCompliance {
+		return fmt.Errorf("invalid object lock mode %q, expected %s or %s", l.Mode, awss3.ObjectLockModeGovernance, awss3.ObjectLockModeCompliance)
+	}
+	if l.RetainFor <= 0 {
+		return fmt.Errorf("retention period of object lock has to be positive, got %s", l.RetainFor)
+	}
+	return nil
+}
+
+func (l *ObjectLock) apply(attributes *objectAttributes, now time.Time) {
+	if l == nil {
+		return
+	}
+	attributes.lockMode = aws.String(l.Mode)
+	attributes.retainUntil = aws.Time(now.Add(l.RetainFor))
+	if l.LegalHold {
+		attributes.legalHold = aws.String(awss3.ObjectLockLegalHoldStatusOn)
+	}
+}
+
+// CheckObjectLock verifies that the bucket has object lock and versioning enabled,
+// otherwise uploads with retention would fail or not be protected.
+func (u BinaryUploader) CheckObjectLock() error {
+	var lockOutput *awss3.GetObjectLockConfigurationOutput
+	err := u.retryPolicy.Do("reading object lock configuration", func() error {
+		var err error
+		lockOutput, err = u.uploader.S3.GetObjectLockConfiguration(&awss3.GetObjectLockConfigurationInput{Bucket: aws.String(u.bucketName)})
+		return err
+	})
+	if isAwsError(err, errCodeNoObjectLockConfiguration) {
+		return fmt.Errorf("object lock is not enabled for bucket %s", u.bucketName)
+	}
+	if err != nil {
+		return errors.Wrapf(err, "failed to read object lock configuration of bucket %s", u.bucketName)
+	}
+	if lockOutput.ObjectLockConfiguration == nil || aws.StringValue(lockOutput.ObjectLockConfiguration.ObjectLockEnabled) != awss3.ObjectLockEnabledEnabled {
+		return fmt.Errorf("object lock is not enabled for bucket %s", u.bucketName)
+	}
+	var versioningOutput *awss3.GetBucketVersioningOutput
+	err = u.retryPolicy.Do("reading bucket versioning", func() error {
+		var err error
+		versioningOutput, err = u.uploader.S3.GetBucketVersioning(&awss3.GetBucketVersioningInput{Bucket: aws.String(u.bucketName)})
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to read versioning of bucket %s", u.bucketName)
+	}
+	if aws.StringValue(versioningOutput.Status) != awss3.BucketVersioningStatusEnabled {
+		return fmt.Errorf("versioning is not enabled for bucket %s", u.bucketName)
+	}
+	return nil
+}
+
+// Delete removes the object with the given key. Objects under retention or legal hold are never deleted,
+// backup.ErrLocked is returned instead.
+func (u BinaryUploader) Delete(key string) error {
+	locked, err := u.isLocked(key)
+	if err != nil {
+		return err
+	}
+	if locked {
+		return errors.Wrapf(backup.ErrLocked, "object %s", key)
+	}
+	err = u.retryPolicy.Do("deleting "+key, func() error {
+		_, err := u.uploader.S3.DeleteObject(&awss3.DeleteObjectInput{Bucket: aws.String(u.bucketName), Key: aws.String(key)})
+		return err
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete %s from bucket %s", key, u.bucketName)
+	}
+	return nil
+}
+
+func (u BinaryUploader) isLocked(key string) (bool, error) {
+	var retention *awss3.GetObjectRetentionOutput
+	err := u.retryPolicy.Do("reading retention of "+key, func() error {
+		output, err := u.uploader.S3.GetObjectRetention(&awss3.GetObjectRetentionInput{Bucket: aws.String(u.bucketName), Key: aws.String(key)})
+		if isUnlocked(err) {
+			return nil
+		}
+		retention = output
+		return err
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read retention of %s", key)
+	}
+	if retention != nil && retention.Retention != nil && aws.TimeValue(retention.Retention.RetainUntilDate).After(time.Now()) {
+		return true, nil
+	}
+	var legalHold *awss3.GetObjectLegalHoldOutput
+	err = u.retryPolicy.Do("reading legal hold of "+key, func() error {
+		output, err := u.uploader.S3.GetObjectLegalHold(&awss3.GetObjectLegalHoldInput{Bucket: aws.String(u.bucketName), Key: aws.String(key)})
+		if isUnlocked(err) {
+			return nil
+		}
+		legalHold = output
+		return err
+	})
+	if err != nil {
+		return false, errors.Wrapf(err, "failed to read legal hold of %s", key)
+	}
+	return legalHold != nil && legalHold.LegalHold != nil && aws.StringValue(legalHold.LegalHold.Status) == awss3.ObjectLockLegalHoldStatusOn, nil
+}
+
+// isUnlocked reports whether S3 answered that there is no lock, either for the object or the whole bucket.
+func isUnlocked(err error) bool {
+	return isAwsError(err, errCodeNoObjectLockConfiguration) || isAwsError(err, errCodeInvalidRequest) ||
+		isAwsError(err, "NoSuchObjectLockConfiguration")
+}
+
+func isAwsError(err error, code string) bool {
+	awsErr, ok := errors.Cause(err).(awserr.Error)
+	return ok && awsErr.Code() == code
+}
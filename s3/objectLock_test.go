@@ -0,0 +1,159 @@
+package s3_test
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/s3"
+	"github.com/pkg/errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func Test_should_upload_with_object_lock_retention_and_legal_hold(t *testing.T) {
+	dir, err := ioutil.TempDir("", "lock")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to close io directory, %v", err)
+		}
+	}()
+	archivePath := filepath.Join(dir, "dump_20191014120000.tar.gz")
+	if err := ioutil.WriteFile(archivePath, make([]byte, s3manager.MinUploadPartSize+1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	client := &fakeMultipartClient{}
+	uploader := &s3manager.Uploader{S3: client, PartSize: s3manager.MinUploadPartSize, Concurrency: 1}
+	binaryUploader := s3.NewBinaryUploader(uploader, s3.HexKeyProvider{}, "bucket",
+		s3.WithResumableUploads(filepath.Join(dir, "state")),
+		s3.WithObjectLock(s3.ObjectLock{Mode: awss3.ObjectLockModeCompliance, RetainFor: 24 * time.Hour, LegalHold: true}))
+
+	if _, err := uploadFile(binaryUploader, archivePath); err != nil {
+		t.Fatal(err)
+	}
+
+	input := client.createInput
+	if aws.StringValue(input.ObjectLockMode) != awss3.ObjectLockModeCompliance {
+		t.Fatalf("unexpected lock mode %s", aws.StringValue(input.ObjectLockMode))
+	}
+	retainUntil := aws.TimeValue(input.ObjectLockRetainUntilDate)
+	if retainUntil.Before(time.Now().Add(23*time.Hour)) || retainUntil.After(time.Now().Add(25*time.Hour)) {
+		t.Fatalf("unexpected retain until date %s", retainUntil)
+	}
+	if aws.StringValue(input.ObjectLockLegalHoldStatus) != awss3.ObjectLockLegalHoldStatusOn {
+		t.Fatalf("unexpected legal hold %s", aws.StringValue(input.ObjectLockLegalHoldStatus))
+	}
+}
+
+func Test_should_reject_invalid_object_lock(t *testing.T) {
+	locks := []s3.ObjectLock{
+		{Mode: "FOREVER", RetainFor: time.Hour},
+		{Mode: awss3.ObjectLockModeGovernance},
+	}
+	for _, lock := range locks {
+		if err := lock.Validate(); err == nil {
+			t.Fatalf("expected lock %v to be invalid", lock)
+		}
+	}
+}
+
+func Test_should_fail_check_when_bucket_has_no_object_lock_or_versioning(t *testing.T) {
+	clients := []*fakeLockClient{
+		{lockEnabled: false, versioning: awss3.BucketVersioningStatusEnabled},
+		{lockEnabled: true, versioning: awss3.BucketVersioningStatusSuspended},
+	}
+	for _, client := range clients {
+		binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: client}, s3.HexKeyProvider{}, "bucket")
+
+		if err := binaryUploader.CheckObjectLock(); err == nil {
+			t.Fatalf("expected check to fail for %+v", client)
+		}
+	}
+}
+
+func Test_should_pass_check_when_bucket_has_object_lock_and_versioning(t *testing.T) {
+	client := &fakeLockClient{lockEnabled: true, versioning: awss3.BucketVersioningStatusEnabled}
+	binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: client}, s3.HexKeyProvider{}, "bucket")
+
+	if err := binaryUploader.CheckObjectLock(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_never_delete_locked_objects(t *testing.T) {
+	client := &fakeLockClient{
+		retainUntil: map[string]time.Time{"retained": time.Now().Add(time.Hour), "expired": time.Now().Add(-time.Hour)},
+		legalHold:   map[string]bool{"held": true},
+	}
+	binaryUploader := s3.NewBinaryUploader(&s3manager.Uploader{S3: client}, s3.HexKeyProvider{}, "bucket")
+
+	for _, key := range []string{"retained", "held"} {
+		if err := binaryUploader.Delete(key); errors.Cause(err) != backup.ErrLocked {
+			t.Fatalf("expected %s to be locked, got %v", key, err)
+		}
+	}
+	for _, key := range []string{"expired", "unlocked"} {
+		if err := binaryUploader.Delete(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(client.deleted) != 2 || client.deleted[0] != "expired" || client.deleted[1] != "unlocked" {
+		t.Fatalf("unexpected deleted objects %v", client.deleted)
+	}
+}
+
+// fakeLockClient answers object lock related calls, objects without retention behave like in a bucket without object lock.
+type fakeLockClient struct {
+	s3iface.S3API
+	lockEnabled bool
+	versioning  string
+	retainUntil map[string]time.Time
+	legalHold   map[string]bool
+	deleted     []string
+}
+
+func (c *fakeLockClient) GetObjectLockConfiguration(input *awss3.GetObjectLockConfigurationInput) (*awss3.GetObjectLockConfigurationOutput, error) {
+	if !c.lockEnabled {
+		return nil, awserr.NewRequestFailure(awserr.New("ObjectLockConfigurationNotFoundError", "object lock configuration does not exist for this bucket", nil), 404, "id")
+	}
+	return &awss3.GetObjectLockConfigurationOutput{ObjectLockConfiguration: &awss3.ObjectLockConfiguration{
+		ObjectLockEnabled: aws.String(awss3.ObjectLockEnabledEnabled),
+	}}, nil
+}
+
+func (c *fakeLockClient) GetBucketVersioning(input *awss3.GetBucketVersioningInput) (*awss3.GetBucketVersioningOutput, error) {
+	return &awss3.GetBucketVersioningOutput{Status: aws.String(c.versioning)}, nil
+}
+
+func (c *fakeLockClient) GetObjectRetention(input *awss3.GetObjectRetentionInput) (*awss3.GetObjectRetentionOutput, error) {
+	key := aws.StringValue(input.Key)
+	retainUntil, ok := c.retainUntil[key]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New("NoSuchObjectLockConfiguration", "the specified object does not have a ObjectLock configuration", nil), 404, "id")
+	}
+	return &awss3.GetObjectRetentionOutput{Retention: &awss3.ObjectLockRetention{
+		Mode:            aws.String(awss3.ObjectLockRetentionModeGovernance),
+		RetainUntilDate: aws.Time(retainUntil),
+	}}, nil
+}
+
+func (c *fakeLockClient) GetObjectLegalHold(input *awss3.GetObjectLegalHoldInput) (*awss3.GetObjectLegalHoldOutput, error) {
+	status := awss3.ObjectLockLegalHoldStatusOff
+	if c.legalHold[aws.StringValue(input.Key)] {
+		status = awss3.ObjectLockLegalHoldStatusOn
+	}
+	return &awss3.GetObjectLegalHoldOutput{LegalHold: &awss3.ObjectLockLegalHold{Status: aws.String(status)}}, nil
+}
+
+func (c *fakeLockClient) DeleteObject(input *awss3.DeleteObjectInput) (*awss3.DeleteObjectOutput, error) {
+	c.deleted = append(c.deleted, aws.StringValue(input.Key))
+	return &awss3.DeleteObjectOutput{}, nil
+}
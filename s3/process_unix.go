@@ -0,0 +1,11 @@
+// +build !windows
+
+package s3
+
+import "syscall"
+
+// processAlive reports whether a process with the given pid exists, signal 0 only checks for it.
+func processAlive(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
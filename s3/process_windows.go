@@ -0,0 +1,13 @@
+package s3
+
+import "os"
+
+// processAlive reports whether a process with the given pid exists, finding a process opens it on windows.
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	_ = process.Release()
+	return true
+}
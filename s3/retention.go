@@ -0,0 +1,224 @@
+package s3
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SnapshotPrefix is the name prefix of snapshot directories kept locally, see LocalRetention.
+const SnapshotPrefix = "snapshot_"
+
+// Keep selects the local copies to keep, the newest Last copies and all copies younger than For.
+// The zero value keeps none.
+type Keep struct {
+	Last int
+	For  time.Duration
+}
+
+func (k Keep) enabled() bool {
+	return k.Last > 0 || k.For > 0
+}
+
+// LocalRetention keeps uploaded archives and snapshot files in Dir for fast restores.
+// Archives are kept as dump_<timestamp>.tar.gz and snapshots as directories snapshot_<timestamp>,
+// they are rotated by the timestamp in their names after every backup. Dir must not be inside the snapshot directory,
+// otherwise the kept copies would be archived again.
+type LocalRetention struct {
+	Dir       string
+	Archives  Keep
+	Snapshots Keep
+}
+
+// WithLocalRetention keeps archives and snapshot files locally instead of removing them after the upload.
+func WithLocalRetention(retention LocalRetention) BackupOption {
+	return func(d *BucketBackup) {
+		d.retention = retention
+	}
+}
+
+// Validate checks that Dir is set if copies are kept and is outside of the snapshot directory.
+func (r LocalRetention) Validate(backupDirPath string) error {
+	if r.Dir == "" {
+		if r.Archives.enabled() || r.Snapshots.enabled() {
+			return errors.New("no directory for local copies of backups")
+		}
+		return nil
+	}
+	dir, err := filepath.Abs(r.Dir)
+	if err != nil {
+		return errors.Wrapf(err, "invalid directory %s", r.Dir)
+	}
+	backupDir, err := filepath.Abs(backupDirPath)
+	if err != nil {
+		return errors.Wrapf(err, "invalid directory %s", backupDirPath)
+	}
+	if relative, err := filepath.Rel(backupDir, dir); err == nil && !strings.HasPrefix(relative, "..") {
+		return fmt.Errorf("directory for local copies %s must not be inside the backup dir %s", r.Dir, backupDirPath)
+	}
+	return nil
+}
+
+// keepArchive moves an uploaded archive into Dir.
+func (r LocalRetention) keepArchive(archivePath string) error {
+	if err := os.MkdirAll(r.Dir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", r.Dir)
+	}
+	return move(archivePath, filepath.Join(r.Dir, filepath.Base(archivePath)))
+}
+
+// keepSnapshot moves the given entries of the snapshot directory into a directory snapshot_<timestamp> in Dir.
+func (r LocalRetention) keepSnapshot(backupDirPath string, names []string, timestamp string) error {
+	snapshotDir := filepath.Join(r.Dir, SnapshotPrefix+timestamp)
+	if err := os.MkdirAll(snapshotDir, 0700); err != nil {
+		return errors.Wrapf(err, "failed to create directory %s", snapshotDir)
+	}
+	for _, name := range names {
+		if err := move(filepath.Join(backupDirPath, name), filepath.Join(snapshotDir, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// localCopy is an archive or snapshot directory kept in Dir.
+type localCopy struct {
+	path    string
+	created time.Time
+}
+
+// rotate removes the copies in Dir which are not kept anymore and returns their paths.
+// Entries without a timestamp in their name are not touched.
+func (r LocalRetention) rotate(now time.Time) ([]string, error) {
+	if r.Dir == "" {
+		return nil, nil
+	}
+	files, err := ioutil.ReadDir(r.Dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read directory %s", r.Dir)
+	}
+	var archives, snapshots []localCopy
+	for _, file := range files {
+		switch {
+		case file.IsDir() && strings.HasPrefix(file.Name(), SnapshotPrefix):
+			if created, ok := parseTimestamp(strings.TrimPrefix(file.Name(), SnapshotPrefix)); ok {
+				snapshots = append(snapshots, localCopy{path: filepath.Join(r.Dir, file.Name()), created: created})
+			}
+		case !file.IsDir() && strings.HasPrefix(file.Name(), ArchivePrefix) && !strings.HasSuffix(file.Name(), partialSuffix):
+			if created, ok := parseTimestamp(strings.TrimPrefix(file.Name(), ArchivePrefix)); ok {
+				archives = append(archives, localCopy{path: filepath.Join(r.Dir, file.Name()), created: created})
+			}
+		}
+	}
+	var removed []string
+	for _, expired := range append(r.Archives.expired(archives, now), r.Snapshots.expired(snapshots, now)...) {
+		if err := os.RemoveAll(expired.path); err != nil {
+			return removed, errors.Wrapf(err, "failed to remove local copy %s", expired.path)
+		}
+		log.Infof("removed local copy %s", expired.path)
+		removed = append(removed, expired.path)
+	}
+	return removed, nil
+}
+
+// expired returns the copies which are neither among the newest Last copies nor younger than For.
+func (k Keep) expired(copies []localCopy, now time.Time) []localCopy {
+	sort.Slice(copies, func(i, j int) bool {
+		return copies[i].created.After(copies[j].created)
+	})
+	var expired []localCopy
+	for i, local := range copies {
+		if i < k.Last || (k.For > 0 && now.Sub(local.created) < k.For) {
+			continue
+		}
+		expired = append(expired, local)
+	}
+	return expired
+}
+
+// parseTimestamp parses the timestamp at the start of value, e.g. 20191014120000.tar.gz.
+func parseTimestamp(value string) (time.Time, bool) {
+	if len(value) < len(unixTimestampFormat) {
+		return time.Time{}, false
+	}
+	created, err := time.ParseInLocation(unixTimestampFormat, value[:len(unixTimestampFormat)], time.Local)
+	return created, err == nil
+}
+
+// move renames source to target, files and directories on another file system are copied and removed.
+func move(source string, target string) error {
+	if err := os.Rename(source, target); err == nil {
+		return nil
+	}
+	// the copy is incomplete until it is renamed
+	partial := target + partialSuffix
+	if err := copyTree(source, partial); err != nil {
+		_ = os.RemoveAll(partial)
+		return errors.Wrapf(err, "failed to move %s to %s", source, target)
+	}
+	if err := os.Rename(partial, target); err != nil {
+		return errors.Wrapf(err, "failed to move %s to %s", source, target)
+	}
+	if err := os.RemoveAll(source); err != nil {
+		return errors.Wrapf(err, "failed to remove %s after copying it to %s", source, target)
+	}
+	return nil
+}
+
+func copyTree(source string, target string) error {
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		destination := filepath.Join(target, relative)
+		switch {
+		case info.IsDir():
+			return os.MkdirAll(destination, info.Mode().Perm())
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			return os.Symlink(link, destination)
+		case info.Mode().IsRegular():
+			return copyFile(path, destination, info.Mode().Perm())
+		}
+		log.Warnf("not copying special file %s", path)
+		return nil
+	})
+}
+
+func copyFile(source string, target string, mode os.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := in.Close(); err != nil {
+			log.Errorf("failed to close io file, %v", err)
+		}
+	}()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		_ = out.Close()
+		return err
+	}
+	return out.Close()
+}
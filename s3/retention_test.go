@@ -0,0 +1,114 @@
+package s3_test
+
+import (
+	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/hill-daniel/influx-backup/s3"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_should_keep_last_archive_and_snapshot_locally(t *testing.T) {
+	backupPath := "/tmp/influx_snapshot"
+	localDir := "/tmp/influx_local"
+	defer removeDirs(t, backupPath, localDir)
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	writeLocalCopies(t, localDir, "dump_20191014120000.tar.gz", "snapshot_20191014120000/20191014120000.meta", "notes.txt")
+	retention := s3.LocalRetention{Dir: localDir, Archives: s3.Keep{Last: 1}, Snapshots: s3.Keep{Last: 1}}
+	testUploader := &testUploader{}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithLocalRetention(retention))
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	timestamp := strings.TrimSuffix(strings.TrimPrefix(testUploader.result.Key, s3.ArchivePrefix), ".tar.gz")
+	expected := []string{testUploader.result.Key, "notes.txt", s3.SnapshotPrefix + timestamp}
+	if names := localNames(t, localDir); strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("actual: %v expected: %v", names, expected)
+	}
+	kept, err := ioutil.ReadFile(filepath.Join(localDir, s3.SnapshotPrefix+timestamp, "dat_0.txt"))
+	if err != nil || string(kept) != "hello\ngo0\n" {
+		t.Fatalf("snapshot files should have been kept, %v", err)
+	}
+	if _, err := os.Stat(backupPath); !os.IsNotExist(err) {
+		t.Fatal("snapshot files should have been moved")
+	}
+}
+
+func Test_should_keep_archives_for_duration(t *testing.T) {
+	backupPath := "/tmp/influx_snapshot"
+	localDir := "/tmp/influx_local"
+	defer removeDirs(t, backupPath, localDir)
+	if err := createSomeFilesForBackup(backupPath); err != nil {
+		t.Fatal(err)
+	}
+	recent := s3.ArchivePrefix + time.Now().Add(-time.Hour).Format("20060102150405") + ".tar.gz"
+	old := s3.ArchivePrefix + time.Now().Add(-48*time.Hour).Format("20060102150405") + ".tar.gz"
+	writeLocalCopies(t, localDir, recent, old)
+	testUploader := &testUploader{}
+	retention := s3.LocalRetention{Dir: localDir, Archives: s3.Keep{For: 24 * time.Hour}}
+	bb := s3.NewBucketBackup(testUploader, gzip.GzTarer{}, s3.WithLocalRetention(retention))
+
+	if _, err := bb.BackUp(backupPath); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{recent, testUploader.result.Key}
+	if names := localNames(t, localDir); strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("actual: %v expected: %v", names, expected)
+	}
+}
+
+func Test_should_reject_local_copies_inside_backup_dir(t *testing.T) {
+	retention := s3.LocalRetention{Dir: "/tmp/influx_snapshot/kept", Archives: s3.Keep{Last: 1}}
+
+	if err := retention.Validate("/tmp/influx_snapshot/"); err == nil {
+		t.Fatal("expected an error for a directory inside the backup dir")
+	}
+	if err := (s3.LocalRetention{Snapshots: s3.Keep{Last: 1}}).Validate("/tmp/influx_snapshot"); err == nil {
+		t.Fatal("expected an error for a missing directory")
+	}
+	if err := (s3.LocalRetention{Dir: "/tmp/influx_snapshot_kept", Archives: s3.Keep{Last: 1}}).Validate("/tmp/influx_snapshot"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writeLocalCopies(t *testing.T, dir string, names ...string) {
+	for _, name := range names {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(name), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func localNames(t *testing.T, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, file := range files {
+		names = append(names, file.Name())
+	}
+	sort.Strings(names)
+	return names
+}
+
+func removeDirs(t *testing.T, dirs ...string) {
+	for _, dir := range dirs {
+		if err := os.RemoveAll(dir); err != nil {
+			t.Errorf("failed to remove %s, %v", dir, err)
+		}
+	}
+}
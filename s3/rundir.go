@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"encoding/json"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	// RunFile is written into every run directory, it names the host, process and database of the run.
+	RunFile = "run.json"
+	// unclaimedRunDirAge is the age after which a run directory without run file is left over,
+	// runs write their run file right after creating the directory.
+	unclaimedRunDirAge = time.Hour
+)
+
+// runInfo identifies the run owning a run directory in the staging directory.
+type runInfo struct {
+	Host     string `json:"host"`
+	PID      int    `json:"pid"`
+	Database string `json:"database"`
+}
+
+func (d BucketBackup) writeRunFile(runDir string) error {
+	host, err := os.Hostname()
+	if err != nil {
+		return errors.Wrap(err, "failed to determine host name for run file")
+	}
+	encoded, err := json.Marshal(runInfo{Host: host, PID: os.Getpid(), Database: d.database})
+	if err != nil {
+		return errors.Wrap(err, "failed to encode run file")
+	}
+	if err := ioutil.WriteFile(filepath.Join(runDir, RunFile), encoded, 0600); err != nil {
+		return errors.Wrapf(err, "failed to write run file into %s", runDir)
+	}
+	return nil
+}
+
+// abandoned reports whether a run directory was left over by a finished run of the same database.
+// Directories of running processes, of other hosts sharing the staging directory and of other databases are kept.
+func (d BucketBackup) abandoned(runDir string) (bool, error) {
+	encoded, err := ioutil.ReadFile(filepath.Join(runDir, RunFile))
+	if err != nil && !os.IsNotExist(err) {
+		return false, errors.Wrapf(err, "failed to read run file of %s", runDir)
+	}
+	var run runInfo
+	if err != nil || json.Unmarshal(encoded, &run) != nil {
+		// the run file is missing or incomplete, only a crashed run leaves it like that for long
+		info, err := os.Stat(runDir)
+		if err != nil {
+			return false, errors.Wrapf(err, "failed to check run directory %s", runDir)
+		}
+		return time.Since(info.ModTime()) > unclaimedRunDirAge, nil
+	}
+	if run.Database != d.database {
+		log.Debugf("keeping run directory %s of database %s", runDir, run.Database)
+		return false, nil
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to determine host name")
+	}
+	if run.Host != host {
+		log.Infof("keeping run directory %s of host %s, its process cannot be checked", runDir, run.Host)
+		return false, nil
+	}
+	if run.PID == os.Getpid() || processAlive(run.PID) {
+		log.Infof("keeping run directory %s of running process %d", runDir, run.PID)
+		return false, nil
+	}
+	return true, nil
+}
+
+// createWorkDir creates the directory of this run in the staging directory, without staging directory
+// archives are written into the snapshot directory.
+func (d BucketBackup) createWorkDir(backupDirPath string, timestamp string) (string, error) {
+	if d.stagingDir == "" {
+		return backupDirPath, nil
+	}
+	if err := os.MkdirAll(d.stagingDir, 0700); err != nil {
+		return "", errors.Wrapf(err, "failed to create staging directory %s", d.stagingDir)
+	}
+	workDir, err := ioutil.TempDir(d.stagingDir, RunDirPrefix+timestamp+"-")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to create run directory in %s", d.stagingDir)
+	}
+	if err := d.writeRunFile(workDir); err != nil {
+		d.removeWorkDir("", workDir)
+		return "", err
+	}
+	return workDir, nil
+}
+
+func (d BucketBackup) removeWorkDir(backupDirPath string, workDir string) {
+	if workDir == backupDirPath {
+		return
+	}
+	if err := os.RemoveAll(workDir); err != nil {
+		log.Errorf("failed to remove run directory %s, %v", workDir, err)
+	}
+}
@@ -0,0 +1,98 @@
+package s3
+
+import (
+	"crypto/tls"
+	"fmt"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"net/http"
+	"os"
+)
+
+// sources of credentials
+const (
+	// CredentialsDefault uses the default chain of the AWS SDK, e.g. environment, ~/.aws and instance roles.
+	CredentialsDefault = "default"
+	// CredentialsEnv uses AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY only.
+	CredentialsEnv = "env"
+	// CredentialsFile uses a credentials file in the format of ~/.aws/credentials.
+	CredentialsFile = "file"
+)
+
+// SessionConfig configures the connection to S3 or S3-compatible storages like MinIO, Ceph RGW or Wasabi.
+// Empty values keep the defaults of the shared AWS config.
+type SessionConfig struct {
+	Endpoint           string
+	Region             string
+	PathStyle          bool
+	CABundle           string
+	InsecureSkipVerify bool
+	Credentials        string
+	CredentialsFile    string
+	CredentialsProfile string
+}
+
+// NewSession creates a session from the shared AWS config, overridden by the given config.
+func NewSession(config SessionConfig) (*session.Session, error) {
+	awsConfig := aws.Config{}
+	if config.Endpoint != "" {
+		awsConfig.Endpoint = aws.String(config.Endpoint)
+	}
+	if config.Region != "" {
+		awsConfig.Region = aws.String(config.Region)
+	}
+	if config.PathStyle {
+		awsConfig.S3ForcePathStyle = aws.Bool(true)
+	}
+	if config.InsecureSkipVerify {
+		awsConfig.HTTPClient = &http.Client{Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: true},
+		}}
+	}
+	creds, err := config.credentials()
+	if err != nil {
+		return nil, err
+	}
+	awsConfig.Credentials = creds
+	options := session.Options{
+		Config:            awsConfig,
+		SharedConfigState: session.SharedConfigEnable,
+	}
+	if config.CABundle != "" {
+		caBundle, err := os.Open(config.CABundle)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to open CA bundle %s", config.CABundle)
+		}
+		defer func() {
+			if err := caBundle.Close(); err != nil {
+				log.Errorf("failed to close io file, %v", err)
+			}
+		}()
+		options.CustomCABundle = caBundle
+	}
+	sess, err := session.NewSessionWithOptions(options)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create s3 session")
+	}
+	return sess, nil
+}
+
+func (c SessionConfig) credentials() (*credentials.Credentials, error) {
+	switch c.Credentials {
+	case "", CredentialsDefault:
+		return nil, nil
+	case CredentialsEnv:
+		return credentials.NewEnvCredentials(), nil
+	case CredentialsFile:
+		if c.CredentialsFile == "" {
+			return nil, errors.New("credentials file is required for file credentials")
+		}
+		return credentials.NewSharedCredentials(c.CredentialsFile, c.CredentialsProfile), nil
+	default:
+		return nil, fmt.Errorf("unknown credentials source %s, expected %s, %s or %s", c.Credentials, CredentialsDefault, CredentialsEnv, CredentialsFile)
+	}
+}
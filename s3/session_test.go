@@ -0,0 +1,75 @@
+package s3_test
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/hill-daniel/influx-backup/s3"
+	"os"
+	"testing"
+)
+
+func Test_should_configure_session_for_s3_compatible_endpoint(t *testing.T) {
+	sess, err := s3.NewSession(s3.SessionConfig{
+		Endpoint:           "https://minio.local:9000",
+		Region:             "eu-central-1",
+		PathStyle:          true,
+		InsecureSkipVerify: true,
+	})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if aws.StringValue(sess.Config.Endpoint) != "https://minio.local:9000" {
+		t.Fatalf("unexpected endpoint %s", aws.StringValue(sess.Config.Endpoint))
+	}
+	if aws.StringValue(sess.Config.Region) != "eu-central-1" {
+		t.Fatalf("unexpected region %s", aws.StringValue(sess.Config.Region))
+	}
+	if !aws.BoolValue(sess.Config.S3ForcePathStyle) {
+		t.Fatal("path-style addressing should be enabled")
+	}
+}
+
+func Test_should_use_static_credentials_from_env(t *testing.T) {
+	defer setEnv(t, "AWS_ACCESS_KEY_ID", "minio")()
+	defer setEnv(t, "AWS_SECRET_ACCESS_KEY", "minio123")()
+	sess, err := s3.NewSession(s3.SessionConfig{Credentials: s3.CredentialsEnv})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := sess.Config.Credentials.Get()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value.AccessKeyID != "minio" || value.SecretAccessKey != "minio123" {
+		t.Fatalf("unexpected credentials %s", value.AccessKeyID)
+	}
+}
+
+func Test_should_reject_unknown_credentials_source(t *testing.T) {
+	if _, err := s3.NewSession(s3.SessionConfig{Credentials: "vault"}); err == nil {
+		t.Fatal("expected unknown credentials source to be rejected")
+	}
+}
+
+func Test_should_fail_for_missing_ca_bundle(t *testing.T) {
+	if _, err := s3.NewSession(s3.SessionConfig{CABundle: "/does/not/exist.pem"}); err == nil {
+		t.Fatal("expected missing CA bundle to be rejected")
+	}
+}
+
+// setEnv sets the variable and returns a function restoring the previous value.
+func setEnv(t *testing.T, key string, value string) func() {
+	previous, ok := os.LookupEnv(key)
+	if err := os.Setenv(key, value); err != nil {
+		t.Fatal(err)
+	}
+	return func() {
+		if ok {
+			_ = os.Setenv(key, previous)
+		} else {
+			_ = os.Unsetenv(key)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+package s3
+
+// snapshot is the snapshot a strategy stores. Size is the size of the snapshot files,
+// metadata describes the snapshot and is stored along with the backup.
+type snapshot struct {
+	dir       string
+	timestamp string
+	size      int64
+	metadata  map[string]string
+}
+
+// strategy stores a snapshot for a BucketBackup and removes it afterwards. It returns the name of the backup
+// and its storage location. The error of a failing after-upload hook is returned along with the storage location.
+type strategy interface {
+	store(d BucketBackup, taken snapshot) (name string, storageLocation string, err error)
+}
@@ -0,0 +1,62 @@
+package s3
+
+import (
+	"fmt"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/gzip"
+	"github.com/hill-daniel/influx-backup/hook"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"io"
+)
+
+var errUploadStopped = errors.New("upload of streamed archive stopped")
+
+// streamStrategy uploads the archive while it is written, no archive is stored locally.
+// A failed upload is not resumed by the next run, it takes a new snapshot instead.
+type streamStrategy struct{}
+
+// store fails if archiving fails, the error of the archiver is returned then.
+// The archiver has to be a gzip.Streamer, see BucketBackup.Validate.
+func (streamStrategy) store(d BucketBackup, taken snapshot) (string, string, error) {
+	streamer, ok := d.archiver.(gzip.Streamer)
+	if !ok {
+		return "", "", fmt.Errorf("archiver %T cannot stream archives", d.archiver)
+	}
+	format := gzip.FormatOf(d.archiver)
+	name := ArchivePrefix + taken.timestamp + format.Extension()
+	reader, writer := io.Pipe()
+	archived := make(chan error, 1)
+	go func() {
+		err := streamer.Stream(writer, taken.dir)
+		_ = writer.CloseWithError(err)
+		archived <- err
+	}()
+	storageLocation, err := d.uploader.Upload(&backup.FileContent{
+		Key:         name,
+		ContentType: format.ContentType(),
+		Body:        reader,
+		Tags:        d.tags,
+		Metadata:    merge(d.metadata, taken.metadata),
+	})
+	// unblocks the archiver if the uploader stopped reading
+	_ = reader.CloseWithError(errUploadStopped)
+	if archiveErr := <-archived; archiveErr != nil && errors.Cause(archiveErr) != errUploadStopped {
+		return "", "", errors.Wrapf(archiveErr, "failed to archive files, however backup was created")
+	}
+	if err != nil {
+		return "", "", err
+	}
+	// a streamed archive is written when it is uploaded, there is no archive file
+	event := d.event
+	hookErr := d.hooks.Run(hook.AfterArchive, event)
+	if hookErr == nil {
+		event.StorageLocation = storageLocation
+		hookErr = d.hooks.Run(hook.AfterUpload, event)
+	}
+	if err := d.removeSnapshot(taken.dir, taken.timestamp); err != nil {
+		log.Error(err)
+	}
+	d.rotate()
+	return name, storageLocation, hookErr
+}
@@ -0,0 +1,120 @@
+package s3
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/pkg/errors"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// DefaultKeyTemplate partitions the keys by host, database and date.
+const DefaultKeyTemplate = `{{.Prefix}}/{{.Host}}/{{.Database}}/{{.Time.Format "2006/01/02"}}/{{.Name}}`
+
+// KeyData holds the values available in a key template.
+type KeyData struct {
+	Prefix   string
+	Host     string
+	Database string
+	Time     time.Time
+	Name     string
+}
+
+// TemplateKeyProvider creates keys from a text/template, e.g. DefaultKeyTemplate.
+type TemplateKeyProvider struct {
+	template *template.Template
+	data     KeyData
+	now      func() time.Time
+}
+
+// NewTemplateKeyProvider parses and validates the template. Prefix, Host and Database of data are used for every key.
+func NewTemplateKeyProvider(keyTemplate string, data KeyData) (*TemplateKeyProvider, error) {
+	tmpl, err := template.New("key").Option("missingkey=error").Parse(keyTemplate)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse key template %s", keyTemplate)
+	}
+	provider := &TemplateKeyProvider{template: tmpl, data: data, now: time.Now}
+	if err := provider.validate(); err != nil {
+		return nil, errors.Wrapf(err, "invalid key template %s", keyTemplate)
+	}
+	return provider, nil
+}
+
+// CreateKeyFor renders the template for the given archive name.
+// The time is taken from the timestamp of the archive name if present, so a later upload keeps the date of the backup.
+// A failed rendering fails the upload, falling back to another key would store the backup where list and prune miss it.
+func (p *TemplateKeyProvider) CreateKeyFor(symbol string) (string, error) {
+	key, err := p.render(symbol, timeOf(symbol, p.now()))
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to render key for %s", symbol)
+	}
+	return key, nil
+}
+
+// Prefix returns the part of the keys which is the same for every backup, e.g. prefix/host/database/.
+// Listing all backups of the database is a prefix query.
+func (p *TemplateKeyProvider) Prefix() string {
+	first, _ := p.render("a", time.Date(2001, 1, 1, 1, 1, 1, 0, time.UTC))
+	second, _ := p.render("b", time.Date(2002, 2, 2, 2, 2, 2, 0, time.UTC))
+	i := 0
+	for i < len(first) && i < len(second) && first[i] == second[i] {
+		i++
+	}
+	return first[:strings.LastIndex(first[:i], "/")+1]
+}
+
+// ScopedToDatabase reports whether the prefix depends on the database, only then listing the prefix
+// returns the backups of a single database. {{.Database}} has to be in a path segment before the first
+// segment which changes with the name or the time of a backup.
+func (p *TemplateKeyProvider) ScopedToDatabase() bool {
+	other := *p
+	other.data.Database = p.data.Database + "-other"
+	prefix, otherPrefix := p.Prefix(), other.Prefix()
+	return !strings.HasPrefix(prefix, otherPrefix) && !strings.HasPrefix(otherPrefix, prefix)
+}
+
+func (p *TemplateKeyProvider) render(name string, t time.Time) (string, error) {
+	data := p.data
+	data.Name = name
+	data.Time = t
+	var buffer bytes.Buffer
+	if err := p.template.Execute(&buffer, data); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+func (p *TemplateKeyProvider) validate() error {
+	first, err := p.render("first.tar.gz", time.Now())
+	if err != nil {
+		return err
+	}
+	second, err := p.render("second.tar.gz", time.Now())
+	if err != nil {
+		return err
+	}
+	if first == second {
+		return errors.New("template has to contain {{.Name}}, otherwise every backup gets the same key")
+	}
+	if strings.HasPrefix(first, "/") || strings.Contains(first, "//") {
+		return fmt.Errorf("key %s must neither start with / nor contain empty path segments", first)
+	}
+	if len(first) > 1024 {
+		return fmt.Errorf("key %s exceeds the maximum length of 1024 bytes", first)
+	}
+	return nil
+}
+
+// timeOf parses the timestamp of archive names like dump_20191014120000.tar.gz.
+func timeOf(name string, fallback time.Time) time.Time {
+	if !strings.HasPrefix(name, ArchivePrefix) || len(name) < len(ArchivePrefix)+len(unixTimestampFormat) {
+		return fallback
+	}
+	timestamp := name[len(ArchivePrefix) : len(ArchivePrefix)+len(unixTimestampFormat)]
+	t, err := time.ParseInLocation(unixTimestampFormat, timestamp, time.Local)
+	if err != nil {
+		return fallback
+	}
+	return t
+}
@@ -0,0 +1,102 @@
+package s3_test
+
+import (
+	"github.com/hill-daniel/influx-backup/s3"
+	"testing"
+	"time"
+)
+
+func Test_should_create_key_from_template_using_time_of_archive_name(t *testing.T) {
+	keyProvider, err := s3.NewTemplateKeyProvider(s3.DefaultKeyTemplate, s3.KeyData{Prefix: "backups", Host: "edge-1", Database: "metrics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actualKey, err := keyProvider.CreateKeyFor("dump_20191014120000.tar.gz")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedKey := "backups/edge-1/metrics/2019/10/14/dump_20191014120000.tar.gz"
+	if actualKey != expectedKey {
+		t.Fatalf("actual: %s expected: %s", actualKey, expectedKey)
+	}
+}
+
+func Test_should_use_current_time_when_name_contains_no_timestamp(t *testing.T) {
+	keyProvider, err := s3.NewTemplateKeyProvider(`{{.Time.Format "2006"}}/{{.Name}}`, s3.KeyData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actualKey, err := keyProvider.CreateKeyFor("manifest.json")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedKey := time.Now().Format("2006") + "/manifest.json"
+	if actualKey != expectedKey {
+		t.Fatalf("actual: %s expected: %s", actualKey, expectedKey)
+	}
+}
+
+func Test_should_return_static_prefix_of_template(t *testing.T) {
+	keyProvider, err := s3.NewTemplateKeyProvider(s3.DefaultKeyTemplate, s3.KeyData{Prefix: "backups", Host: "edge-1", Database: "metrics"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actualPrefix := keyProvider.Prefix()
+
+	expectedPrefix := "backups/edge-1/metrics/"
+	if actualPrefix != expectedPrefix {
+		t.Fatalf("actual: %s expected: %s", actualPrefix, expectedPrefix)
+	}
+}
+
+func Test_should_detect_templates_scoped_to_database(t *testing.T) {
+	for template, expected := range map[string]bool{
+		s3.DefaultKeyTemplate:   true,
+		`{{.Prefix}}/{{.Name}}`: false,
+		`{{.Prefix}}/{{.Time.Format "2006"}}/{{.Database}}/{{.Name}}`: false,
+		`{{.Prefix}}/{{.Database}}-{{.Name}}`:                         false,
+	} {
+		keyProvider, err := s3.NewTemplateKeyProvider(template, s3.KeyData{Prefix: "backups", Host: "edge-1", Database: "metrics"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual := keyProvider.ScopedToDatabase(); actual != expected {
+			t.Fatalf("actual: %t expected: %t for %s", actual, expected, template)
+		}
+	}
+}
+
+func Test_should_reject_invalid_templates(t *testing.T) {
+	templates := []string{
+		"{{.Prefix}}/{{.Database}}/backup.tar.gz",
+		"/{{.Database}}/{{.Name}}",
+		"{{.Prefix}}/{{.Host}}/{{.Name}}",
+		"{{.Unknown}}/{{.Name}}",
+		"{{.Name",
+	}
+	for _, template := range templates {
+		if _, err := s3.NewTemplateKeyProvider(template, s3.KeyData{Prefix: "backups", Database: "metrics"}); err == nil {
+			t.Fatalf("expected template %s to be invalid", template)
+		}
+	}
+}
+
+func Test_should_fail_if_key_can_not_be_rendered(t *testing.T) {
+	keyProvider, err := s3.NewTemplateKeyProvider(`{{.Name}}{{if eq .Name "manifest.json"}}{{slice .Name 99}}{{end}}`, s3.KeyData{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	actualKey, err := keyProvider.CreateKeyFor("manifest.json")
+
+	if err == nil {
+		t.Fatalf("expected error instead of key %s", actualKey)
+	}
+}
@@ -1,11 +1,17 @@
 package s3
 
 import (
-	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/retry"
+	"github.com/hill-daniel/influx-backup/throttle"
 	"github.com/pkg/errors"
+	"io"
+	"os"
+	"time"
 )
 
 const (
@@ -17,28 +23,142 @@ const (
 
 // BinaryUploader uploads files to s3 bucket.
 type BinaryUploader struct {
-	uploader    *s3manager.Uploader
-	keyProvider BucketKeyProvider
-	bucketName  string
+	uploader     *s3manager.Uploader
+	keyProvider  BucketKeyProvider
+	bucketName   string
+	retryPolicy  retry.Policy
+	stateDir     string
+	limiter      *throttle.Limiter
+	storageClass string
+	objectLock   *ObjectLock
+}
+
+// Option configures optional behaviour of the BinaryUploader.
+type Option func(u *BinaryUploader)
+
+// WithRetryPolicy attempts failed uploads again according to the given policy.
+func WithRetryPolicy(policy retry.Policy) Option {
+	return func(u *BinaryUploader) {
+		u.retryPolicy = policy.WithClassifier(IsRetryable)
+	}
+}
+
+// WithResumableUploads persists the progress of multipart uploads of local files in the given directory.
+// An upload interrupted e.g. by a reboot continues with the missing parts when the same file is uploaded again.
+func WithResumableUploads(stateDir string) Option {
+	return func(u *BinaryUploader) {
+		u.stateDir = stateDir
+	}
+}
+
+// WithRateLimit limits the bandwidth used for uploads.
+func WithRateLimit(limiter *throttle.Limiter) Option {
+	return func(u *BinaryUploader) {
+		u.limiter = limiter
+	}
+}
+
+// WithStorageClass uploads objects directly into the given storage class, e.g. STANDARD_IA or GLACIER_IR.
+func WithStorageClass(storageClass string) Option {
+	return func(u *BinaryUploader) {
+		u.storageClass = storageClass
+	}
 }
 
 // NewBinaryUploader creates a new binary uploader.
-func NewBinaryUploader(uploader *s3manager.Uploader, keyProvider BucketKeyProvider, bucketName string) BinaryUploader {
-	return BinaryUploader{uploader: uploader, keyProvider: keyProvider, bucketName: bucketName}
+func NewBinaryUploader(uploader *s3manager.Uploader, keyProvider BucketKeyProvider, bucketName string, options ...Option) BinaryUploader {
+	u := BinaryUploader{uploader: uploader, keyProvider: keyProvider, bucketName: bucketName, retryPolicy: retry.NoRetry}
+	for _, option := range options {
+		option(&u)
+	}
+	return u
 }
 
-// Upload uploads the given object as bytes to S3 for the given key
+// Upload uploads the given object to S3 for the given key.
+// Files larger than a single part are uploaded resumable, if enabled. The SHA-256 digest of seekable content
+// is recorded as metadata, so server-side copies can be verified without downloading them, see VerifyCopy.
 func (u BinaryUploader) Upload(content *backup.FileContent) (storageLocation string, err error) {
-	reader := bytes.NewReader(*content.Content)
-	key := u.keyProvider.CreateKeyFor(content.Key)
-	result, err := u.uploader.Upload(&s3manager.UploadInput{
-		Body:        reader,
-		Bucket:      aws.String(u.bucketName),
-		Key:         &key,
-		ContentType: aws.String(content.ContentType)})
+	key, err := u.keyProvider.CreateKeyFor(content.Key)
+	if err != nil {
+		return "", err
+	}
+	tagging, err := encodeTags(content.Tags)
+	if err != nil {
+		return "", errors.Wrapf(err, "invalid tags for item with key %s", content.Key)
+	}
+	attributes := objectAttributes{
+		contentType:  content.ContentType,
+		storageClass: u.storageClass,
+		tagging:      tagging,
+		metadata:     aws.StringMap(content.Metadata),
+	}
+	u.objectLock.apply(&attributes, time.Now())
+	body := content.Reader()
+	if seeker, ok := body.(io.ReadSeeker); ok {
+		digest, err := digestOf(seeker)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read item with key %s", content.Key)
+		}
+		attributes.metadata[MetadataSHA256] = aws.String(digest)
+	}
+	policy := u.retryPolicy
+	if _, ok := body.(io.Seeker); !ok {
+		// a consumed stream can not be uploaded again
+		policy = retry.NoRetry
+	}
+	err = policy.Do("upload of "+key, func() error {
+		if seeker, ok := body.(io.Seeker); ok {
+			if _, err := seeker.Seek(0, io.SeekStart); err != nil {
+				return retry.Permanent(err)
+			}
+		}
+		if file, ok := body.(*os.File); ok && u.stateDir != "" && content.Size > partSizeFor(content.Size, u.uploader.PartSize) {
+			storageLocation, err = u.uploadResumable(file, key, attributes)
+			return err
+		}
+		result, err := u.uploader.Upload(&s3manager.UploadInput{
+			Body:                      u.limit(body),
+			Bucket:                    aws.String(u.bucketName),
+			Key:                       &key,
+			ContentType:               aws.String(attributes.contentType),
+			StorageClass:              attributes.storageClassValue(),
+			Tagging:                   attributes.taggingValue(),
+			Metadata:                  attributes.metadata,
+			ObjectLockMode:            attributes.lockMode,
+			ObjectLockRetainUntilDate: attributes.retainUntil,
+			ObjectLockLegalHoldStatus: attributes.legalHold})
+		if err != nil {
+			return err
+		}
+		storageLocation = result.Location
+		return nil
+	})
 	if err != nil {
 		err = errors.Wrapf(err, "failed to upload item with key %s to bucket %s", content.Key, u.bucketName)
-		return storageLocation, err
+		return "", err
+	}
+	return storageLocation, nil
+}
+
+// digestOf reads the body from the start to calculate its hex encoded SHA-256 digest, the body is rewound afterwards.
+func digestOf(body io.ReadSeeker) (string, error) {
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, body); err != nil {
+		return "", err
+	}
+	if _, err := body.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// limit hides the Seeker of a throttled reader, s3manager would read seekable bodies twice to sign them.
+func (u BinaryUploader) limit(reader io.Reader) io.Reader {
+	if u.limiter == nil {
+		return reader
 	}
-	return result.Location, nil
+	return u.limiter.Reader(reader)
 }
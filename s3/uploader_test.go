@@ -4,32 +4,42 @@ package s3_test
 
 import (
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	awss3 "github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 	"github.com/hill-daniel/influx-backup"
 	"github.com/hill-daniel/influx-backup/s3"
+	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 )
 
+// The integration tests run against a local MinIO container:
+// docker run -d -p 9000:9000 -e MINIO_ACCESS_KEY=minioadmin -e MINIO_SECRET_KEY=minioadmin minio/minio server /data
 const (
-	bucketName     = "hillda-stuff"
-	uploadFileName = "upload-integration-test.txt"
-	envAwsLoadConf = "AWS_SDK_LOAD_CONFIG"
+	envEndpoint       = "MINIO_ENDPOINT"
+	envAccessKey      = "AWS_ACCESS_KEY_ID"
+	envSecretKey      = "AWS_SECRET_ACCESS_KEY"
+	defaultEndpoint   = "http://localhost:9000"
+	defaultCredential = "minioadmin"
+	bucketName        = "influx-backup-integration-test"
+	uploadFileName    = "upload-integration-test.txt"
 )
 
 func Test_should_upload_file_to_s3(t *testing.T) {
-	// session uses default credentials from ~/.aws/credentials and region from ~/.aws/config
-	sharedSession := createSession()
-	client := awss3.New(sharedSession)
-	uploader := s3manager.NewUploader(sharedSession)
+	client, uploader := createMinioClient(t)
 	keyProvider := s3.HexKeyProvider{}
+	createBucket(client, t)
 	checkUploadFileDoesNotExist(client, keyProvider, t)
 	binaryUploader := s3.NewBinaryUploader(uploader, keyProvider, bucketName)
-	defer cleanup(client, keyProvider)
+	key, err := keyProvider.CreateKeyFor(uploadFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(client, key)
 	fileContent := []byte("If you can read this, the upload was successful")
 	bucketContent := &backup.FileContent{Key: uploadFileName, Content: &fileContent, ContentType: s3.BinaryContent}
 
@@ -43,36 +53,107 @@ func Test_should_upload_file_to_s3(t *testing.T) {
 	}
 }
 
-func createSession() *session.Session {
-	sharedSession := session.Must(session.NewSessionWithOptions(session.Options{
-		SharedConfigState: session.SharedConfigEnable,
-	}))
-	return sharedSession
+func Test_should_upload_file_resumable_and_list_and_delete_it(t *testing.T) {
+	client, uploader := createMinioClient(t)
+	keyProvider := s3.HexKeyProvider{}
+	createBucket(client, t)
+	dir, err := ioutil.TempDir("", "integration")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	archivePath := filepath.Join(dir, "dump_20191014120000.tar.gz")
+	if err := ioutil.WriteFile(archivePath, make([]byte, s3manager.MinUploadPartSize+1), 0600); err != nil {
+		t.Fatal(err)
+	}
+	key, err := keyProvider.CreateKeyFor(filepath.Base(archivePath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cleanup(client, key)
+	binaryUploader := s3.NewBinaryUploader(uploader, keyProvider, bucketName, s3.WithResumableUploads(filepath.Join(dir, "state")))
+
+	if _, err := uploadFile(binaryUploader, archivePath); err != nil {
+		t.Fatalf("failed to upload file, %v", err)
+	}
+
+	objects, err := binaryUploader.List(keyProvider.Prefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 1 || objects[0].Key != key || objects[0].Size != s3manager.MinUploadPartSize+1 {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+	if err := binaryUploader.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+	objects, err = binaryUploader.List(keyProvider.Prefix())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Fatalf("object should have been deleted, %v", objects)
+	}
+}
+
+func createMinioClient(t *testing.T) (*awss3.S3, *s3manager.Uploader) {
+	endpoint := os.Getenv(envEndpoint)
+	if endpoint == "" {
+		endpoint = defaultEndpoint
+	}
+	setDefaultEnv(envAccessKey, defaultCredential)
+	setDefaultEnv(envSecretKey, defaultCredential)
+	sess, err := s3.NewSession(s3.SessionConfig{
+		Endpoint:    endpoint,
+		Region:      "us-east-1",
+		PathStyle:   true,
+		Credentials: s3.CredentialsEnv,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return awss3.New(sess), s3manager.NewUploader(sess)
+}
+
+func setDefaultEnv(key string, value string) {
+	if os.Getenv(key) != "" {
+		return
+	}
+	if err := os.Setenv(key, value); err != nil {
+		log.Printf("failed to set env variable %s", key)
+	}
+}
+
+func createBucket(client *awss3.S3, t *testing.T) {
+	_, err := client.CreateBucket(&awss3.CreateBucketInput{Bucket: aws.String(bucketName)})
+	if awsErr, ok := err.(awserr.Error); ok && awsErr.Code() == awss3.ErrCodeBucketAlreadyOwnedByYou {
+		return
+	}
+	if err != nil {
+		t.Fatalf("failed to create bucket %s, %v", bucketName, err)
+	}
 }
 
 func checkUploadFileDoesNotExist(client *awss3.S3, keyProvider s3.HexKeyProvider, t *testing.T) {
-	_, err := client.HeadObject(&awss3.HeadObjectInput{
+	key, err := keyProvider.CreateKeyFor(uploadFileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = client.HeadObject(&awss3.HeadObjectInput{
 		Bucket: aws.String(bucketName),
-		Key:    keyProvider.CreateKeyFor(uploadFileName),
+		Key:    aws.String(key),
 	})
 	if err == nil {
 		t.Fatalf("cleanup of preceding test failed, object already exists")
 	}
 }
 
-func cleanup(client *awss3.S3, keyProvider s3.HexKeyProvider) {
+func cleanup(client *awss3.S3, key string) {
 	input := &awss3.DeleteObjectInput{
 		Bucket: aws.String(bucketName),
-		Key:    keyProvider.CreateKeyFor(uploadFileName),
+		Key:    aws.String(key),
 	}
 	if _, err := client.DeleteObject(input); err != nil {
-		log.Printf("failed to delete object %s from bucket %s", uploadFileName, bucketName)
-	}
-	cleanupEnv()
-}
-
-func cleanupEnv() {
-	if err := os.Unsetenv(envAwsLoadConf); err != nil {
-		log.Printf("failed to unset env variabke %s", envAwsLoadConf)
+		log.Printf("failed to delete object %s from bucket %s", key, bucketName)
 	}
 }
@@ -0,0 +1,174 @@
+package sftp
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	pkgsftp "github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	defaultPort    = "22"
+	envAuthSock    = "SSH_AUTH_SOCK"
+	defaultUser    = "root"
+	dialTimeout    = 30 * time.Second
+	knownHostsPath = ".ssh/known_hosts"
+)
+
+// Config configures the SSH connection. Host keys are always verified against the known_hosts file.
+type Config struct {
+	// Address is host or host:port, the port defaults to 22.
+	Address string
+	User    string
+	// KeyFile is a private key in PEM or OpenSSH format, KeyPassphrase decrypts it if set.
+	KeyFile       string
+	KeyPassphrase string
+	// UseAgent authenticates with the keys of the agent listening on SSH_AUTH_SOCK.
+	UseAgent bool
+	// KnownHostsFile defaults to ~/.ssh/known_hosts.
+	KnownHostsFile string
+	Timeout        time.Duration
+}
+
+// Connection is an SFTP session on top of an SSH connection.
+type Connection struct {
+	Client *pkgsftp.Client
+	ssh    *ssh.Client
+	host   string
+}
+
+// Dial connects to the SSH server and starts the sftp subsystem.
+func Dial(config Config) (*Connection, error) {
+	address := config.address()
+	clientConfig, err := config.clientConfig()
+	if err != nil {
+		return nil, err
+	}
+	sshClient, err := ssh.Dial("tcp", address, clientConfig)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to connect to %s", address)
+	}
+	client, err := pkgsftp.NewClient(sshClient)
+	if err != nil {
+		if closeErr := sshClient.Close(); closeErr != nil {
+			log.Errorf("failed to close ssh connection, %v", closeErr)
+		}
+		return nil, errors.Wrapf(err, "failed to start sftp subsystem on %s", address)
+	}
+	host, _, _ := net.SplitHostPort(address)
+	return &Connection{Client: client, ssh: sshClient, host: host}, nil
+}
+
+// Host returns the host name of the server, used in storage locations.
+func (c *Connection) Host() string {
+	return c.host
+}
+
+// Close ends the sftp session and the SSH connection.
+func (c *Connection) Close() error {
+	sftpErr := c.Client.Close()
+	if err := c.ssh.Close(); err != nil {
+		return errors.Wrap(err, "failed to close ssh connection")
+	}
+	if sftpErr != nil {
+		return errors.Wrap(sftpErr, "failed to close sftp session")
+	}
+	return nil
+}
+
+func (c Config) address() string {
+	if _, _, err := net.SplitHostPort(c.Address); err == nil {
+		return c.Address
+	}
+	return net.JoinHostPort(c.Address, defaultPort)
+}
+
+func (c Config) clientConfig() (*ssh.ClientConfig, error) {
+	if c.Address == "" {
+		return nil, errors.New("address of the ssh server is required")
+	}
+	auth, err := c.authMethods()
+	if err != nil {
+		return nil, err
+	}
+	hostKeyCallback, err := c.hostKeyCallback()
+	if err != nil {
+		return nil, err
+	}
+	user := c.User
+	if user == "" {
+		user = defaultUser
+	}
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = dialTimeout
+	}
+	return &ssh.ClientConfig{User: user, Auth: auth, HostKeyCallback: hostKeyCallback, Timeout: timeout}, nil
+}
+
+func (c Config) authMethods() ([]ssh.AuthMethod, error) {
+	var methods []ssh.AuthMethod
+	if c.KeyFile != "" {
+		signer, err := c.readKey()
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, ssh.PublicKeys(signer))
+	}
+	if c.UseAgent {
+		socket := os.Getenv(envAuthSock)
+		if socket == "" {
+			return nil, fmt.Errorf("ssh agent requested but %s is not set", envAuthSock)
+		}
+		conn, err := net.Dial("unix", socket)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to connect to ssh agent at %s", socket)
+		}
+		methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+	}
+	if len(methods) == 0 {
+		return nil, errors.New("no ssh authentication configured, set a key file or use the agent")
+	}
+	return methods, nil
+}
+
+func (c Config) readKey() (ssh.Signer, error) {
+	key, err := ioutil.ReadFile(c.KeyFile)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read key file %s", c.KeyFile)
+	}
+	var signer ssh.Signer
+	if c.KeyPassphrase != "" {
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(c.KeyPassphrase))
+	} else {
+		signer, err = ssh.ParsePrivateKey(key)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse key file %s", c.KeyFile)
+	}
+	return signer, nil
+}
+
+func (c Config) hostKeyCallback() (ssh.HostKeyCallback, error) {
+	file := c.KnownHostsFile
+	if file == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to determine home directory for known_hosts")
+		}
+		file = filepath.Join(home, filepath.FromSlash(knownHostsPath))
+	}
+	callback, err := knownhosts.New(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read known hosts %s", file)
+	}
+	return callback, nil
+}
@@ -0,0 +1,168 @@
+package sftp_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"github.com/hill-daniel/influx-backup/sftp"
+	pkgsftp "github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"testing"
+)
+
+// sshServer accepts connections authenticated with the given client key and serves the sftp subsystem.
+type sshServer struct {
+	listener net.Listener
+	hostKey  ssh.Signer
+}
+
+func startSSHServer(t *testing.T, clientKey ssh.PublicKey) *sshServer {
+	hostKey := generateSigner(t)
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(conn ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, ssh.ErrNoAuth
+		},
+	}
+	config.AddHostKey(hostKey)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return &sshServer{listener: listener, hostKey: hostKey}
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			_ = newChannel.Reject(ssh.UnknownChannelType, "only sessions are supported")
+			continue
+		}
+		channel, channelRequests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for request := range channelRequests {
+				isSFTP := request.Type == "subsystem" && string(request.Payload[4:]) == "sftp"
+				_ = request.Reply(isSFTP, nil)
+				if isSFTP {
+					server, err := pkgsftp.NewServer(channel)
+					if err == nil {
+						_ = server.Serve()
+					}
+					_ = channel.Close()
+				}
+			}
+		}()
+	}
+}
+
+func Test_should_connect_with_key_file_to_known_host(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	keyFile, clientKey := writeClientKey(t, dir)
+	server := startSSHServer(t, clientKey)
+	defer server.listener.Close()
+	knownHosts := writeKnownHosts(t, dir, server.listener.Addr().String(), server.hostKey.PublicKey())
+
+	connection, err := sftp.Dial(sftp.Config{Address: server.listener.Addr().String(), User: "backup", KeyFile: keyFile, KnownHostsFile: knownHosts})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.Close()
+	if connection.Host() != "127.0.0.1" {
+		t.Fatalf("actual: %s expected: %s", connection.Host(), "127.0.0.1")
+	}
+	if _, err := connection.Client.Stat(dir); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func Test_should_reject_unknown_host_key(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	keyFile, clientKey := writeClientKey(t, dir)
+	server := startSSHServer(t, clientKey)
+	defer server.listener.Close()
+	knownHosts := writeKnownHosts(t, dir, server.listener.Addr().String(), generateSigner(t).PublicKey())
+
+	_, err := sftp.Dial(sftp.Config{Address: server.listener.Addr().String(), User: "backup", KeyFile: keyFile, KnownHostsFile: knownHosts})
+
+	if err == nil {
+		t.Fatal("expected error for changed host key")
+	}
+}
+
+func Test_should_require_authentication(t *testing.T) {
+	_, err := sftp.Dial(sftp.Config{Address: "127.0.0.1:22"})
+
+	if err == nil {
+		t.Fatal("expected error without key file and agent")
+	}
+}
+
+func generateKey(t *testing.T) *ecdsa.PrivateKey {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func generateSigner(t *testing.T) ssh.Signer {
+	signer, err := ssh.NewSignerFromKey(generateKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signer
+}
+
+func writeClientKey(t *testing.T, dir string) (string, ssh.PublicKey) {
+	key := generateKey(t)
+	der, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(dir, "id_ecdsa")
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ssh.NewPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keyFile, publicKey
+}
+
+func writeKnownHosts(t *testing.T, dir string, address string, hostKey ssh.PublicKey) string {
+	file := filepath.Join(dir, "known_hosts")
+	line := knownhosts.Line([]string{knownhosts.Normalize(address)}, hostKey)
+	if err := ioutil.WriteFile(file, []byte(line+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
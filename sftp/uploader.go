@@ -0,0 +1,167 @@
+package sftp
+
+import (
+	"fmt"
+	"github.com/hill-daniel/influx-backup"
+	"github.com/pkg/errors"
+	pkgsftp "github.com/pkg/sftp"
+	log "github.com/sirupsen/logrus"
+	"io"
+	"math/rand"
+	"net/url"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+)
+
+const tmpSuffix = ".tmp"
+
+// Uploader stores files in a directory of a remote host via SFTP.
+// Files are uploaded under a temporary name and renamed, a broken connection never leaves a partial backup under its final name.
+type Uploader struct {
+	client      *pkgsftp.Client
+	host        string
+	dir         string
+	keyProvider backup.KeyProvider
+	fileMode    os.FileMode
+}
+
+// Option configures optional behaviour of the Uploader.
+type Option func(u *Uploader)
+
+// WithPermissions sets the permissions of stored files.
+func WithPermissions(mode os.FileMode) Option {
+	return func(u *Uploader) {
+		u.fileMode = mode
+	}
+}
+
+// NewUploader creates a new sftp uploader storing files below the remote dir. The host is only used for storage locations.
+func NewUploader(client *pkgsftp.Client, host string, dir string, keyProvider backup.KeyProvider, options ...Option) Uploader {
+	u := Uploader{client: client, host: host, dir: path.Clean(dir), keyProvider: keyProvider, fileMode: 0640}
+	for _, option := range options {
+		option(&u)
+	}
+	return u
+}
+
+// Upload streams the content to a temporary file and renames it to the path of its key.
+// The storage location is an sftp:// URL.
+func (u Uploader) Upload(content *backup.FileContent) (storageLocation string, err error) {
+	key, err := u.keyProvider.CreateKeyFor(content.Key)
+	if err != nil {
+		return "", err
+	}
+	remotePath, err := u.pathOf(key)
+	if err != nil {
+		return "", err
+	}
+	dir := path.Dir(remotePath)
+	if err := u.client.MkdirAll(dir); err != nil {
+		return "", errors.Wrapf(err, "failed to create remote directory %s", dir)
+	}
+	tmpPath := path.Join(dir, "."+path.Base(remotePath)+"."+strconv.FormatUint(uint64(rand.Uint32()), 36)+tmpSuffix)
+	defer func() {
+		if err != nil {
+			if removeErr := u.client.Remove(tmpPath); removeErr != nil && !os.IsNotExist(removeErr) {
+				log.Errorf("failed to remove temporary file %s, %v", tmpPath, removeErr)
+			}
+		}
+	}()
+	if err = u.write(tmpPath, content); err != nil {
+		return "", err
+	}
+	if err = u.rename(tmpPath, remotePath); err != nil {
+		return "", err
+	}
+	return (&url.URL{Scheme: "sftp", Host: u.host, Path: remotePath}).String(), nil
+}
+
+func (u Uploader) write(remotePath string, content *backup.FileContent) error {
+	file, err := u.client.OpenFile(remotePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return errors.Wrapf(err, "failed to create remote file %s", remotePath)
+	}
+	if _, err := io.Copy(file, content.Reader()); err != nil {
+		_ = file.Close()
+		return errors.Wrapf(err, "failed to write remote file %s", remotePath)
+	}
+	if err := file.Close(); err != nil {
+		return errors.Wrapf(err, "failed to close remote file %s", remotePath)
+	}
+	if err := u.client.Chmod(remotePath, u.fileMode); err != nil {
+		return errors.Wrapf(err, "failed to set permissions of %s", remotePath)
+	}
+	return nil
+}
+
+// rename replaces the target atomically if the server supports the posix-rename extension of OpenSSH.
+// Plain SFTP renames fail on existing targets, which is fine since keys of backups are unique.
+func (u Uploader) rename(from string, to string) error {
+	posixErr := u.client.PosixRename(from, to)
+	if posixErr == nil {
+		return nil
+	}
+	if err := u.client.Rename(from, to); err != nil {
+		return errors.Wrapf(err, "failed to rename %s to %s (posix-rename: %v)", from, to, posixErr)
+	}
+	return nil
+}
+
+// List returns the stored files whose keys start with prefix. Temporary files of running uploads are skipped.
+func (u Uploader) List(prefix string) ([]backup.StoredObject, error) {
+	var objects []backup.StoredObject
+	walker := u.client.Walk(u.dir)
+	for walker.Step() {
+		if err := walker.Err(); err != nil {
+			if os.IsNotExist(err) && walker.Path() == u.dir {
+				return nil, nil
+			}
+			return nil, errors.Wrapf(err, "failed to list files in %s", u.dir)
+		}
+		info := walker.Stat()
+		if !info.Mode().IsRegular() || strings.HasSuffix(walker.Path(), tmpSuffix) {
+			continue
+		}
+		key := strings.TrimPrefix(strings.TrimPrefix(walker.Path(), u.dir), "/")
+		if strings.HasPrefix(key, prefix) {
+			objects = append(objects, backup.StoredObject{Key: key, Size: info.Size(), LastModified: info.ModTime()})
+		}
+	}
+	return objects, nil
+}
+
+// Delete removes the file with the given key.
+func (u Uploader) Delete(key string) error {
+	remotePath, err := u.pathOf(key)
+	if err != nil {
+		return err
+	}
+	if err := u.client.Remove(remotePath); err != nil {
+		return errors.Wrapf(err, "failed to delete %s", remotePath)
+	}
+	return nil
+}
+
+// Open returns the content of the file with the given key.
+func (u Uploader) Open(key string) (io.ReadCloser, error) {
+	remotePath, err := u.pathOf(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := u.client.Open(remotePath)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open remote file %s", remotePath)
+	}
+	return file, nil
+}
+
+// pathOf maps a key to a path below the remote directory, keys must not escape it.
+func (u Uploader) pathOf(key string) (string, error) {
+	remotePath := path.Join(u.dir, key)
+	if !strings.HasPrefix(remotePath, strings.TrimSuffix(u.dir, "/")+"/") {
+		return "", fmt.Errorf("key %s is not within directory %s", key, u.dir)
+	}
+	return remotePath, nil
+}
@@ -0,0 +1,192 @@
+package sftp_test
+
+import (
+	"github.com/hill-daniel/influx-backup"
+	"github.com/hill-daniel/influx-backup/sftp"
+	pkgsftp "github.com/pkg/sftp"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+type plainKeyProvider struct{}
+
+func (plainKeyProvider) CreateKeyFor(symbol string) (string, error) {
+	return "metrics/" + symbol, nil
+}
+
+func (plainKeyProvider) Prefix() string {
+	return "metrics/"
+}
+
+type pipe struct {
+	io.Reader
+	io.WriteCloser
+}
+
+// startServer serves the local filesystem over an in-process sftp connection.
+func startServer(t *testing.T) *pkgsftp.Client {
+	serverReader, clientWriter := io.Pipe()
+	clientReader, serverWriter := io.Pipe()
+	server, err := pkgsftp.NewServer(pipe{Reader: serverReader, WriteCloser: serverWriter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		_ = server.Serve()
+		_ = serverWriter.Close()
+	}()
+	client, err := pkgsftp.NewClientPipe(clientReader, clientWriter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func Test_should_upload_file_to_key_path_and_return_sftp_url(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	client := startServer(t)
+	defer client.Close()
+	uploader := sftp.NewUploader(client, "backup.local", dir, plainKeyProvider{}, sftp.WithPermissions(0600))
+	content := []byte("archive")
+
+	storageLocation, err := uploader.Upload(&backup.FileContent{Key: "dump_20191014120000.tar.gz", Content: &content})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "metrics", "dump_20191014120000.tar.gz")
+	if storageLocation != "sftp://backup.local"+path {
+		t.Fatalf("actual: %s expected: %s", storageLocation, "sftp://backup.local"+path)
+	}
+	written, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != "archive" {
+		t.Fatalf("unexpected content %s", string(written))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("actual: %s expected: %s", info.Mode().Perm(), os.FileMode(0600))
+	}
+	files, err := ioutil.ReadDir(filepath.Join(dir, "metrics"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("temporary file was not renamed, found %d files", len(files))
+	}
+}
+
+func Test_should_stream_body_of_file_content(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	client := startServer(t)
+	defer client.Close()
+	uploader := sftp.NewUploader(client, "backup.local", dir, plainKeyProvider{})
+	body := strings.Repeat("0123456789", 10000)
+
+	_, err := uploader.Upload(&backup.FileContent{Key: "dump_20191014120000.tar.gz", Body: strings.NewReader(body), Size: int64(len(body))})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	written, err := ioutil.ReadFile(filepath.Join(dir, "metrics", "dump_20191014120000.tar.gz"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(written) != body {
+		t.Fatalf("actual: %d bytes expected: %d bytes", len(written), len(body))
+	}
+}
+
+func Test_should_list_and_delete_uploaded_files(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	client := startServer(t)
+	defer client.Close()
+	uploader := sftp.NewUploader(client, "backup.local", dir, plainKeyProvider{})
+	for _, name := range []string{"dump_20191014120000.tar.gz", "dump_20191015120000.tar.gz"} {
+		content := []byte(name)
+		if _, err := uploader.Upload(&backup.FileContent{Key: name, Content: &content}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "metrics", ".dump_20191016120000.tar.gz.abc.tmp"), []byte("partial"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := uploader.Delete("metrics/dump_20191014120000.tar.gz"); err != nil {
+		t.Fatal(err)
+	}
+	objects, err := uploader.List("metrics/")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	sort.Strings(keys)
+	if len(keys) != 1 || keys[0] != "metrics/dump_20191015120000.tar.gz" {
+		t.Fatalf("unexpected keys %v", keys)
+	}
+	if objects[0].Size != int64(len("dump_20191015120000.tar.gz")) {
+		t.Fatalf("actual: %d expected: %d", objects[0].Size, len("dump_20191015120000.tar.gz"))
+	}
+}
+
+func Test_should_list_nothing_if_directory_does_not_exist(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	client := startServer(t)
+	defer client.Close()
+	uploader := sftp.NewUploader(client, "backup.local", filepath.Join(dir, "missing"), plainKeyProvider{})
+
+	objects, err := uploader.List("")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(objects) != 0 {
+		t.Fatalf("unexpected objects %v", objects)
+	}
+}
+
+func Test_should_reject_keys_outside_of_directory(t *testing.T) {
+	dir := createTempDir(t)
+	defer removeDir(t, dir)
+	client := startServer(t)
+	defer client.Close()
+	uploader := sftp.NewUploader(client, "backup.local", dir, plainKeyProvider{})
+
+	err := uploader.Delete("../outside.tar.gz")
+
+	if err == nil {
+		t.Fatal("expected error for key outside of directory")
+	}
+}
+
+func createTempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "sftp-uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func removeDir(t *testing.T, dir string) {
+	if err := os.RemoveAll(dir); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,22 @@
+package throttle
+
+import "time"
+
+// Clock abstracts the passing of time, so the limiter can be tested without waiting.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock uses the real time.
+type SystemClock struct{}
+
+// Now returns the current local time.
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Sleep pauses the current goroutine for the given duration.
+func (SystemClock) Sleep(d time.Duration) {
+	time.Sleep(d)
+}
@@ -0,0 +1,71 @@
+package throttle
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// maxChunk limits the bytes read at once, so the rate stays smooth instead of bursting.
+const maxChunk = 32 * 1024
+
+// Limiter is a token bucket allowing up to one second worth of bytes as burst.
+// It is safe for concurrent use, concurrent readers share the bandwidth.
+type Limiter struct {
+	schedule Schedule
+	clock    Clock
+	mutex    sync.Mutex
+	tokens   float64
+	last     time.Time
+}
+
+// NewLimiter creates a limiter for the given schedule.
+func NewLimiter(schedule Schedule, clock Clock) *Limiter {
+	return &Limiter{schedule: schedule, clock: clock}
+}
+
+// WaitN blocks until n bytes may be transferred according to the current rate.
+func (l *Limiter) WaitN(n int) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := l.clock.Now()
+	rate := float64(l.schedule.RateAt(now))
+	if rate <= 0 {
+		l.last = time.Time{}
+		return
+	}
+	if l.last.IsZero() {
+		l.tokens = rate
+	} else {
+		l.tokens += now.Sub(l.last).Seconds() * rate
+		if l.tokens > rate {
+			l.tokens = rate
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+	if l.tokens < 0 {
+		l.clock.Sleep(time.Duration(-l.tokens / rate * float64(time.Second)))
+		l.tokens = 0
+		l.last = l.clock.Now()
+	}
+}
+
+// Reader limits reading from r.
+func (l *Limiter) Reader(r io.Reader) io.Reader {
+	return &reader{reader: r, limiter: l}
+}
+
+type reader struct {
+	reader  io.Reader
+	limiter *Limiter
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) > maxChunk {
+		p = p[:maxChunk]
+	}
+	n, err := r.reader.Read(p)
+	r.limiter.WaitN(n)
+	return n, err
+}
@@ -0,0 +1,83 @@
+package throttle_test
+
+import (
+	"bytes"
+	"github.com/hill-daniel/influx-backup/throttle"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now   time.Time
+	slept time.Duration
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	c.now = c.now.Add(d)
+	c.slept += d
+}
+
+func Test_should_limit_reading_to_bytes_per_second(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2019, 10, 14, 12, 0, 0, 0, time.UTC)}
+	limiter := throttle.NewLimiter(throttle.Schedule{Default: 1024}, clock)
+	content := make([]byte, 4096)
+
+	read, err := ioutil.ReadAll(limiter.Reader(bytes.NewReader(content)))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(read) != len(content) {
+		t.Fatalf("actual: %d expected: %d bytes", len(read), len(content))
+	}
+	// the first second is available as burst
+	if clock.slept != 3*time.Second {
+		t.Fatalf("actual: %s expected: %s", clock.slept, 3*time.Second)
+	}
+}
+
+func Test_should_not_wait_when_unlimited(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2019, 10, 14, 12, 0, 0, 0, time.UTC)}
+	limiter := throttle.NewLimiter(throttle.Schedule{}, clock)
+
+	if _, err := ioutil.ReadAll(limiter.Reader(bytes.NewReader(make([]byte, 1<<20)))); err != nil {
+		t.Fatal(err)
+	}
+
+	if clock.slept != 0 {
+		t.Fatalf("should not have waited, but slept %s", clock.slept)
+	}
+}
+
+func Test_should_refill_tokens_while_time_passes(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2019, 10, 14, 12, 0, 0, 0, time.UTC)}
+	limiter := throttle.NewLimiter(throttle.Schedule{Default: 1000}, clock)
+
+	limiter.WaitN(1000)
+	clock.now = clock.now.Add(time.Second)
+	limiter.WaitN(1000)
+
+	if clock.slept != 0 {
+		t.Fatalf("should not have waited, but slept %s", clock.slept)
+	}
+}
+
+func Test_should_use_rate_of_current_time_window(t *testing.T) {
+	clock := &fakeClock{now: time.Date(2019, 10, 14, 23, 0, 0, 0, time.UTC)}
+	schedule, err := throttle.ParseSchedule(1000, "22:00-06:00=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	limiter := throttle.NewLimiter(schedule, clock)
+
+	limiter.WaitN(5000)
+
+	if clock.slept != 0 {
+		t.Fatalf("should not have waited at night, but slept %s", clock.slept)
+	}
+}
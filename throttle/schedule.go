@@ -0,0 +1,109 @@
+package throttle
+
+import (
+	"fmt"
+	"github.com/pkg/errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule defines the allowed bytes per second depending on the time of day. 0 means unlimited.
+type Schedule struct {
+	Default int64
+	Windows []Window
+}
+
+// Window overrides the default rate between From and To, given as offset since midnight.
+// A window with From after To spans midnight, e.g. 22:00-06:00.
+type Window struct {
+	From           time.Duration
+	To             time.Duration
+	BytesPerSecond int64
+}
+
+// RateAt returns the rate of the first window containing the time of day of t, otherwise the default rate.
+func (s Schedule) RateAt(t time.Time) int64 {
+	midnight := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	offset := t.Sub(midnight)
+	for _, window := range s.Windows {
+		if window.contains(offset) {
+			return window.BytesPerSecond
+		}
+	}
+	return s.Default
+}
+
+func (w Window) contains(offset time.Duration) bool {
+	if w.From <= w.To {
+		return offset >= w.From && offset < w.To
+	}
+	return offset >= w.From || offset < w.To
+}
+
+// ParseSchedule parses comma separated windows like "08:00-18:00=1M,18:00-22:00=10M".
+func ParseSchedule(defaultRate int64, value string) (Schedule, error) {
+	schedule := Schedule{Default: defaultRate}
+	if strings.TrimSpace(value) == "" {
+		return schedule, nil
+	}
+	for _, definition := range strings.Split(value, ",") {
+		window, err := parseWindow(strings.TrimSpace(definition))
+		if err != nil {
+			return Schedule{}, err
+		}
+		schedule.Windows = append(schedule.Windows, window)
+	}
+	return schedule, nil
+}
+
+func parseWindow(definition string) (Window, error) {
+	parts := strings.SplitN(definition, "=", 2)
+	if len(parts) != 2 {
+		return Window{}, fmt.Errorf("invalid window %q, expected format hh:mm-hh:mm=rate", definition)
+	}
+	times := strings.SplitN(parts[0], "-", 2)
+	if len(times) != 2 {
+		return Window{}, fmt.Errorf("invalid window %q, expected format hh:mm-hh:mm=rate", definition)
+	}
+	from, err := parseTimeOfDay(times[0])
+	if err != nil {
+		return Window{}, err
+	}
+	to, err := parseTimeOfDay(times[1])
+	if err != nil {
+		return Window{}, err
+	}
+	rate, err := ParseBytes(parts[1])
+	if err != nil {
+		return Window{}, err
+	}
+	return Window{From: from, To: to, BytesPerSecond: rate}, nil
+}
+
+func parseTimeOfDay(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(value))
+	if err != nil {
+		return 0, errors.Wrapf(err, "invalid time of day %q", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// ParseBytes parses a number of bytes with an optional binary unit suffix, e.g. 512K, 10M or 1G.
+func ParseBytes(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	multiplier := int64(1)
+	units := map[string]int64{"K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+	for unit, factor := range units {
+		if strings.HasSuffix(value, unit) {
+			multiplier = factor
+			value = strings.TrimSuffix(value, unit)
+			break
+		}
+	}
+	number, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("invalid number of bytes %q", value)
+	}
+	return number * multiplier, nil
+}
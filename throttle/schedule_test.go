@@ -0,0 +1,49 @@
+package throttle_test
+
+import (
+	"github.com/hill-daniel/influx-backup/throttle"
+	"testing"
+	"time"
+)
+
+func Test_should_parse_windows_of_schedule(t *testing.T) {
+	schedule, err := throttle.ParseSchedule(0, "08:00-18:00=1M, 22:00-06:00=512K")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day := time.Date(2019, 10, 14, 0, 0, 0, 0, time.UTC)
+	expected := map[time.Duration]int64{
+		9 * time.Hour:  1 << 20,
+		18 * time.Hour: 0,
+		23 * time.Hour: 512 << 10,
+		5 * time.Hour:  512 << 10,
+		7 * time.Hour:  0,
+	}
+	for offset, rate := range expected {
+		if actual := schedule.RateAt(day.Add(offset)); actual != rate {
+			t.Fatalf("at %s actual: %d expected: %d", offset, actual, rate)
+		}
+	}
+}
+
+func Test_should_reject_invalid_windows(t *testing.T) {
+	for _, value := range []string{"08:00=1M", "08:00-25:00=1M", "08:00-18:00", "08:00-18:00=fast"} {
+		if _, err := throttle.ParseSchedule(0, value); err == nil {
+			t.Fatalf("expected error for %q", value)
+		}
+	}
+}
+
+func Test_should_parse_bytes_with_unit(t *testing.T) {
+	expected := map[string]int64{"42": 42, "512k": 512 << 10, "10M": 10 << 20, "1G": 1 << 30}
+	for value, bytes := range expected {
+		actual, err := throttle.ParseBytes(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if actual != bytes {
+			t.Fatalf("%s actual: %d expected: %d", value, actual, bytes)
+		}
+	}
+}
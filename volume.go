@@ -0,0 +1,216 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/pkg/errors"
+	"hash"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	// VolumeIndexSuffix is appended to the name of a split archive for the key of its index.
+	VolumeIndexSuffix = ".index.json"
+	// VolumeContentType is the content type of parts, only the first part starts with the header of the archive.
+	VolumeContentType = "application/octet-stream"
+	// MetadataVolume is the metadata key of the number of a part.
+	MetadataVolume = "volume"
+	// CatalogSuffix is appended to the name of a backup for the key of its catalog, see package catalog.
+	CatalogSuffix = ".catalog.json"
+)
+
+var volumeSuffix = regexp.MustCompile(`\.part-\d{4,}$`)
+
+// VolumeIndex lists the parts of an archive split into volumes in order.
+type VolumeIndex struct {
+	Archive     string       `json:"archive"`
+	ContentType string       `json:"contentType"`
+	Size        int64        `json:"size"`
+	Parts       []VolumePart `json:"parts"`
+}
+
+// VolumePart is a volume of a split archive, its name is the name of the archive with the suffix .part-0001 and so on.
+type VolumePart struct {
+	Name   string `json:"name"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// VolumeName returns the name of the part with the given number, counting from 1.
+func VolumeName(archive string, number int) string {
+	return fmt.Sprintf("%s.part-%04d", archive, number)
+}
+
+// ArchiveOf returns the key of the archive a stored file belongs to, which is the key of a part or index
+// of a split archive or of a catalog without its suffix. Other keys are returned unchanged.
+func ArchiveOf(key string) string {
+	if strings.HasSuffix(key, CatalogSuffix) {
+		return strings.TrimSuffix(key, CatalogSuffix)
+	}
+	if strings.HasSuffix(key, VolumeIndexSuffix) {
+		return strings.TrimSuffix(key, VolumeIndexSuffix)
+	}
+	if location := volumeSuffix.FindStringIndex(key); location != nil {
+		return key[:location[0]]
+	}
+	return key
+}
+
+// UploadVolumes splits an archive of the given size into parts of at most partSize bytes and uploads them,
+// followed by an index listing the parts with their SHA-256 digests. The index is uploaded last, a split archive
+// without index is incomplete. Key, ContentType, Tags and Metadata of content describe the archive, its body is not used.
+// Every part is read from archive on its own, so failed uploads of parts are retried by the uploader.
+// The storage location of the index is returned.
+func UploadVolumes(uploader Uploader, archive io.ReaderAt, size int64, content *FileContent, partSize int64) (string, error) {
+	if partSize <= 0 {
+		return "", fmt.Errorf("invalid volume size %d", partSize)
+	}
+	index := VolumeIndex{Archive: content.Key, ContentType: content.ContentType, Size: size}
+	for offset, number := int64(0), 1; offset < size || number == 1; offset, number = offset+partSize, number+1 {
+		length := partSize
+		if offset+length > size {
+			length = size - offset
+		}
+		digest, err := digestOf(io.NewSectionReader(archive, offset, length))
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to read part %d of %s", number, content.Key)
+		}
+		part := VolumePart{Name: VolumeName(content.Key, number), Size: length, SHA256: hex.EncodeToString(digest)}
+		metadata := map[string]string{MetadataVolume: strconv.Itoa(number)}
+		for key, value := range content.Metadata {
+			metadata[key] = value
+		}
+		_, err = uploader.Upload(&FileContent{
+			Key:         part.Name,
+			ContentType: VolumeContentType,
+			Body:        io.NewSectionReader(archive, offset, length),
+			Size:        length,
+			Tags:        content.Tags,
+			Metadata:    metadata,
+		})
+		if err != nil {
+			return "", err
+		}
+		index.Parts = append(index.Parts, part)
+	}
+	encoded, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return "", errors.Wrapf(err, "failed to encode index of %s", content.Key)
+	}
+	return uploader.Upload(&FileContent{
+		Key:         content.Key + VolumeIndexSuffix,
+		Content:     &encoded,
+		ContentType: "application/json",
+		Size:        int64(len(encoded)),
+		Tags:        content.Tags,
+		Metadata:    content.Metadata,
+	})
+}
+
+// ReadVolumeIndex downloads the index of a split archive.
+func ReadVolumeIndex(opener Opener, key string) (VolumeIndex, error) {
+	reader, err := opener.Open(key)
+	if err != nil {
+		return VolumeIndex{}, err
+	}
+	defer closeReader(reader, key)
+	var index VolumeIndex
+	if err := json.NewDecoder(reader).Decode(&index); err != nil {
+		return VolumeIndex{}, errors.Wrapf(err, "failed to read index %s", key)
+	}
+	return index, nil
+}
+
+// NewVolumeReader streams the parts of a split archive in order, nothing is written to disk.
+// The keys of the parts are derived from the key of the index by replacing the name of the index with the name
+// of the part, wherever the key provider placed it, e.g. metrics/dump_1.tar.gz.index.json/edge-1 for a key template
+// with segments after the name. Reading fails if a part differs from the index.
+func NewVolumeReader(opener Opener, indexKey string, index VolumeIndex) (io.ReadCloser, error) {
+	name := index.Archive + VolumeIndexSuffix
+	position := strings.LastIndex(indexKey, name)
+	if position < 0 {
+		return nil, fmt.Errorf("key %s of the index does not contain its name %s, the keys of the parts are unknown", indexKey, name)
+	}
+	return &volumeReader{opener: opener, prefix: indexKey[:position], suffix: indexKey[position+len(name):], parts: index.Parts}, nil
+}
+
+type volumeReader struct {
+	opener  Opener
+	prefix  string
+	suffix  string
+	parts   []VolumePart
+	current io.ReadCloser
+	part    VolumePart
+	digest  hash.Hash
+	read    int64
+}
+
+// Read reads from the current part and opens the next one at its end, after the digest of the finished part was checked.
+func (r *volumeReader) Read(p []byte) (int, error) {
+	for {
+		if r.current == nil {
+			if len(r.parts) == 0 {
+				return 0, io.EOF
+			}
+			r.part, r.parts = r.parts[0], r.parts[1:]
+			reader, err := r.opener.Open(r.prefix + r.part.Name + r.suffix)
+			if err != nil {
+				return 0, err
+			}
+			r.current, r.digest, r.read = reader, sha256.New(), 0
+		}
+		n, err := r.current.Read(p)
+		r.digest.Write(p[:n])
+		r.read += int64(n)
+		if err == io.EOF {
+			if err := r.finishPart(); err != nil {
+				return n, err
+			}
+			if n == 0 {
+				continue
+			}
+			return n, nil
+		}
+		return n, err
+	}
+}
+
+func (r *volumeReader) finishPart() error {
+	closeReader(r.current, r.part.Name)
+	r.current = nil
+	if r.read != r.part.Size {
+		return fmt.Errorf("part %s has %d bytes, expected %d", r.part.Name, r.read, r.part.Size)
+	}
+	expected, err := hex.DecodeString(r.part.SHA256)
+	if err != nil {
+		return errors.Wrapf(err, "invalid digest of part %s", r.part.Name)
+	}
+	if actual := r.digest.Sum(nil); !bytes.Equal(actual, expected) {
+		return fmt.Errorf("digest %x of part %s does not match digest %x of the index", actual, r.part.Name, expected)
+	}
+	return nil
+}
+
+// Close closes the part being read.
+func (r *volumeReader) Close() error {
+	if r.current == nil {
+		return nil
+	}
+	err := r.current.Close()
+	r.current = nil
+	return err
+}
+
+func digestOf(reader io.Reader) ([]byte, error) {
+	hash := sha256.New()
+	if _, err := io.Copy(hash, reader); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,190 @@
+package backup_test
+
+import (
+	"bytes"
+	"github.com/hill-daniel/influx-backup"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"time"
+)
+
+func Test_should_split_archive_into_volumes_and_reassemble_them(t *testing.T) {
+	storage := &prefixStorage{prefix: "metrics/", memoryStorage: memoryStorage{files: map[string][]byte{}}}
+	archive := []byte("0123456789")
+	content := &backup.FileContent{Key: "dump_1.tar.gz", ContentType: "application/gzip", Metadata: map[string]string{"uncompressed-size": "20"}}
+
+	storageLocation, err := backup.UploadVolumes(storage, bytes.NewReader(archive), int64(len(archive)), content, 4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storageLocation != "memory://metrics/dump_1.tar.gz.index.json" {
+		t.Fatalf("unexpected storage location %s", storageLocation)
+	}
+	for name, expected := range map[string]string{"dump_1.tar.gz.part-0001": "0123", "dump_1.tar.gz.part-0002": "4567", "dump_1.tar.gz.part-0003": "89"} {
+		if string(storage.files["metrics/"+name]) != expected {
+			t.Fatalf("actual: %s expected: %s for %s", storage.files["metrics/"+name], expected, name)
+		}
+	}
+	index, err := backup.ReadVolumeIndex(storage, "metrics/dump_1.tar.gz.index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if index.Archive != "dump_1.tar.gz" || index.ContentType != "application/gzip" || index.Size != 10 || len(index.Parts) != 3 {
+		t.Fatalf("unexpected index %+v", index)
+	}
+	reader, err := backup.NewVolumeReader(storage, "metrics/dump_1.tar.gz.index.json", index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reassembled, err := ioutil.ReadAll(reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := reader.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reassembled, archive) {
+		t.Fatalf("actual: %s expected: %s", reassembled, archive)
+	}
+}
+
+func Test_should_upload_empty_archive_as_single_volume(t *testing.T) {
+	storage := &memoryStorage{files: map[string][]byte{}}
+
+	_, err := backup.UploadVolumes(storage, bytes.NewReader(nil), 0, &backup.FileContent{Key: "dump_1.tar.gz"}, 4)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := backup.ReadVolumeIndex(storage, "dump_1.tar.gz.index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Parts) != 1 || index.Parts[0].Size != 0 {
+		t.Fatalf("unexpected index %+v", index)
+	}
+}
+
+func Test_should_fail_reading_corrupted_volume(t *testing.T) {
+	storage := &memoryStorage{files: map[string][]byte{}}
+	archive := []byte("0123456789")
+	if _, err := backup.UploadVolumes(storage, bytes.NewReader(archive), int64(len(archive)), &backup.FileContent{Key: "dump_1.tar.gz"}, 4); err != nil {
+		t.Fatal(err)
+	}
+	storage.files["dump_1.tar.gz.part-0002"] = []byte("4568")
+	index, err := backup.ReadVolumeIndex(storage, "dump_1.tar.gz.index.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := backup.NewVolumeReader(storage, "dump_1.tar.gz.index.json", index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = ioutil.ReadAll(reader)
+
+	if err == nil || !strings.Contains(err.Error(), "part-0002") {
+		t.Fatalf("expected digest error for the second part, got %v", err)
+	}
+}
+
+func Test_should_read_volumes_with_keys_continuing_after_the_name(t *testing.T) {
+	storage := &prefixStorage{prefix: "metrics/", suffix: "/edge-1", memoryStorage: memoryStorage{files: map[string][]byte{}}}
+	archive := []byte("0123456789")
+	if _, err := backup.UploadVolumes(storage, bytes.NewReader(archive), int64(len(archive)), &backup.FileContent{Key: "dump_1.tar.gz"}, 4); err != nil {
+		t.Fatal(err)
+	}
+	index, err := backup.ReadVolumeIndex(storage, "metrics/dump_1.tar.gz.index.json/edge-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := backup.NewVolumeReader(storage, "metrics/dump_1.tar.gz.index.json/edge-1", index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reassembled, err := ioutil.ReadAll(reader)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(reassembled, archive) {
+		t.Fatalf("actual: %s expected: %s", reassembled, archive)
+	}
+}
+
+func Test_should_determine_archive_of_volumes(t *testing.T) {
+	for key, expected := range map[string]string{
+		"metrics/dump_1.tar.gz.part-0001":    "metrics/dump_1.tar.gz",
+		"metrics/dump_1.tar.gz.part-12345":   "metrics/dump_1.tar.gz",
+		"metrics/dump_1.tar.gz.index.json":   "metrics/dump_1.tar.gz",
+		"metrics/dump_1.tar.gz.catalog.json": "metrics/dump_1.tar.gz",
+		"metrics/dump_1.tar.gz":              "metrics/dump_1.tar.gz",
+	} {
+		if actual := backup.ArchiveOf(key); actual != expected {
+			t.Fatalf("actual: %s expected: %s for %s", actual, expected, key)
+		}
+	}
+}
+
+func Test_should_prune_split_archives_as_one_backup(t *testing.T) {
+	now := time.Now()
+	storage := &testStorage{objects: []backup.StoredObject{
+		{Key: "dump_0.tar.gz", LastModified: now},
+		{Key: "dump_1.tar.gz.part-0001", LastModified: now.Add(-2 * time.Hour)},
+		{Key: "dump_1.tar.gz.part-0002", LastModified: now.Add(-2 * time.Hour)},
+		{Key: "dump_1.tar.gz.index.json", LastModified: now.Add(-time.Hour)},
+		{Key: "dump_2.tar.gz", LastModified: now.Add(-3 * time.Hour)},
+	}}
+
+	deleted, err := backup.Prune(storage, "dump_", 2)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deleted) != 1 || deleted[0] != "dump_2.tar.gz" {
+		t.Fatalf("unexpected deleted backups %v", deleted)
+	}
+
+	deleted, err = backup.Prune(storage, "dump_", 1)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"dump_1.tar.gz.index.json", "dump_1.tar.gz.part-0002", "dump_1.tar.gz.part-0001", "dump_2.tar.gz"}
+	if strings.Join(deleted, ",") != strings.Join(expected, ",") {
+		t.Fatalf("actual: %v expected: %v", deleted, expected)
+	}
+}
+
+func Test_should_select_split_archives_as_one_backup(t *testing.T) {
+	now := time.Now()
+	objects := []backup.StoredObject{
+		{Key: "dump_1.tar.gz.index.json", LastModified: now},
+		{Key: "dump_1.tar.gz.part-0002", LastModified: now},
+		{Key: "dump_1.tar.gz.part-0001", LastModified: now},
+		{Key: "dump_0.tar.gz", LastModified: now.Add(-time.Hour)},
+	}
+
+	selected := backup.Selection{Latest: 1}.Apply(objects)
+
+	if len(selected) != 3 || selected[0].Key != "dump_1.tar.gz.part-0001" || selected[2].Key != "dump_1.tar.gz.index.json" {
+		t.Fatalf("unexpected selection %v", selected)
+	}
+}
+
+// prefixStorage stores files below a prefix and with an optional suffix like a key provider.
+type prefixStorage struct {
+	memoryStorage
+	prefix string
+	suffix string
+}
+
+func (s *prefixStorage) Upload(content *backup.FileContent) (string, error) {
+	prefixed := *content
+	prefixed.Key = s.prefix + content.Key + s.suffix
+	return s.memoryStorage.Upload(&prefixed)
+}